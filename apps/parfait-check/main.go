@@ -0,0 +1,91 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/stockparfait/errors"
+	"github.com/stockparfait/logging"
+	"github.com/stockparfait/stockparfait/db"
+)
+
+type Flags struct {
+	DBDir    string // default: ~/.stockparfait
+	DBName   string // required
+	LogLevel logging.Level
+	Repair   bool // repair the fixable issues
+}
+
+func parseFlags(args []string) (*Flags, error) {
+	var flags Flags
+	fs := flag.NewFlagSet("parfait-check", flag.ExitOnError)
+	fs.StringVar(&flags.DBDir, "cache",
+		filepath.Join(os.Getenv("HOME"), ".stockparfait"),
+		"path to databases")
+	fs.StringVar(&flags.DBName, "db", "", "database name (required)")
+	flags.LogLevel = logging.Info
+	fs.Var(&flags.LogLevel, "log-level", "Log level: debug, info, warning, error")
+	fs.BoolVar(&flags.Repair, "repair", false, "repair the fixable issues in place")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if flags.DBName == "" {
+		return nil, errors.Reason("missing required -db argument")
+	}
+	return &flags, err
+}
+
+// check the DB and print the report in JSON format. Returns the number of
+// issues remaining in the DB.
+func check(ctx context.Context, flags *Flags, w io.Writer) (int, error) {
+	report, err := db.Check(ctx, flags.DBDir, flags.DBName, flags.Repair)
+	if err != nil {
+		return 0, errors.Annotate(err, "failed to check DB")
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return 0, errors.Annotate(err, "failed to print the report")
+	}
+	return report.Unfixed(), nil
+}
+
+func main() {
+	ctx := context.Background()
+	flags, err := parseFlags(os.Args[1:])
+	if err != nil {
+		ctx = logging.Use(ctx, logging.DefaultGoLogger(logging.Info))
+		logging.Errorf(ctx, "failed to parse flags: %s", err.Error())
+		os.Exit(1)
+	}
+	ctx = logging.Use(ctx, logging.DefaultGoLogger(flags.LogLevel))
+
+	n, err := check(ctx, flags, os.Stdout)
+	if err != nil {
+		logging.Errorf(ctx, err.Error())
+		os.Exit(1)
+	}
+	if n > 0 {
+		os.Exit(2)
+	}
+}
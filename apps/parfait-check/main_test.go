@@ -0,0 +1,99 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stockparfait/logging"
+	"github.com/stockparfait/stockparfait/db"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMain(t *testing.T) {
+	t.Parallel()
+
+	tmpdir, tmpdirErr := os.MkdirTemp("", "test_check_app")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	Convey("parseFlags", t, func() {
+		flags, err := parseFlags([]string{
+			"-cache", "path/to/cache", "-db", "name",
+			"-log-level", "warning", "-repair"})
+		So(err, ShouldBeNil)
+		So(flags.DBDir, ShouldEqual, "path/to/cache")
+		So(flags.DBName, ShouldEqual, "name")
+		So(flags.LogLevel, ShouldEqual, logging.Warning)
+		So(flags.Repair, ShouldBeTrue)
+
+		_, err = parseFlags([]string{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("check works", t, func() {
+		ctx := context.Background()
+		dbName := "testdb"
+		tickers := map[string]db.TickerRow{"A": {Active: true}}
+		prices := []db.PriceRow{
+			db.TestPrice(db.NewDate(2019, 1, 2), 11.0, 11.0, 11.0, 1100.0, true),
+			db.TestPrice(db.NewDate(2019, 1, 1), 10.0, 10.0, 10.0, 1000.0, true),
+		}
+		w := db.NewWriter(tmpdir, dbName)
+		So(w.WriteTickers(tickers), ShouldBeNil)
+		So(w.WritePrices("A", prices), ShouldBeNil)
+		So(w.WriteMetadata(w.Metadata), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
+
+		flags, err := parseFlags([]string{"-cache", tmpdir, "-db", dbName})
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		n, err := check(ctx, flags, &buf)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 1)
+		var report db.CheckReport
+		So(json.Unmarshal(buf.Bytes(), &report), ShouldBeNil)
+		So(report, ShouldResemble, db.CheckReport{
+			DB:         dbName,
+			NumTickers: 1,
+			Issues: []db.Issue{{
+				Kind:    db.UnsortedPrices,
+				Ticker:  "A",
+				Message: "price on 2019-01-01 follows 2019-01-02",
+				Fixable: true,
+			}},
+		})
+
+		flags.Repair = true
+		buf.Reset()
+		n, err = check(ctx, flags, &buf)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+
+		flags.Repair = false
+		buf.Reset()
+		n, err = check(ctx, flags, &buf)
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+	})
+}
@@ -16,6 +16,7 @@ package main
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"io"
@@ -26,20 +27,31 @@ import (
 	"github.com/stockparfait/errors"
 	"github.com/stockparfait/logging"
 	"github.com/stockparfait/stockparfait/db"
+	"github.com/stockparfait/stockparfait/provider"
 )
 
 type Flags struct {
 	DBDir    string // default: ~/.stockparfait
 	DBName   string // required
 	LogLevel logging.Level
-	// Exactly one of tickers, prices or update-metadata must be present
+	// Exactly one of tickers, prices, fx, universe, dir, update-metadata, cleanup
+	// or convert must be present.
 	Tickers        string // Import tickers; merge by default
-	Replace        bool   // Replace tickers table rather than merge
+	Replace        bool   // Replace tickers table or universe rather than merge
 	Ticker         string // Must be present with -prices
 	Prices         string // Import prices for a given ticker
-	Schema         string // schema file for either tickers or prices table
+	Adjust         bool   // Compute adjusted -prices from the ticker's actions
+	FX             string // Import FX rates for a given currency pair
+	Pair           string // Must be present with -fx, e.g. EURUSD
+	Universe       string // Import a named universe
+	Members        string // membership CSV for -universe
+	Filter         string // constraint expression JSON for -universe
+	Schema         string // schema file for tickers, prices or FX table
+	Dir            string // Import the entire DB from a directory of CSV files
+	Update         bool   // Update the DB with -dir incrementally
 	UpdateMetadata bool
 	Cleanup        bool
+	Convert        string // storage format to convert the DB to
 }
 
 func parseFlags(args []string) (*Flags, error) {
@@ -53,12 +65,25 @@ func parseFlags(args []string) (*Flags, error) {
 	fs.Var(&flags.LogLevel, "log-level", "Log level: debug, info, warning, error")
 	fs.StringVar(&flags.Tickers, "tickers", "", "import tickers")
 	fs.BoolVar(&flags.Replace, "replace", false,
-		"replace the entire tickers table, don't merge")
+		"replace the entire tickers table or universe, don't merge")
 	fs.StringVar(&flags.Ticker, "ticker", "", "required with -prices")
 	fs.StringVar(&flags.Prices, "prices", "", "import prices for a given ticker")
-	fs.StringVar(&flags.Schema, "schema", "", "schema config for either tickers or prices")
+	fs.BoolVar(&flags.Adjust, "adjust", false,
+		"compute adjusted closes missing from -prices from the actions in the DB")
+	fs.StringVar(&flags.FX, "fx", "", "import FX rates for a given currency pair")
+	fs.StringVar(&flags.Pair, "pair", "", "currency pair, e.g. EURUSD; required with -fx")
+	fs.StringVar(&flags.Universe, "universe", "", "import a named universe")
+	fs.StringVar(&flags.Members, "members", "", "universe membership CSV file")
+	fs.StringVar(&flags.Filter, "filter", "", "universe constraint expression JSON file")
+	fs.StringVar(&flags.Schema, "schema", "",
+		"schema config for tickers, prices, FX rates or universe members")
+	fs.StringVar(&flags.Dir, "dir", "", "import the entire DB from a directory of CSV files")
+	fs.BoolVar(&flags.Update, "update", false,
+		"with -dir, import only the data after the latest date in the DB")
 	fs.BoolVar(&flags.UpdateMetadata, "update-metadata", false, "scan the DB")
 	fs.BoolVar(&flags.Cleanup, "cleanup", false, "clean up orphan price files")
+	fs.StringVar(&flags.Convert, "convert", "",
+		"convert prices to the storage format: gob or columnar")
 
 	err := fs.Parse(args)
 	if err != nil {
@@ -74,19 +99,43 @@ func parseFlags(args []string) (*Flags, error) {
 	if flags.Prices != "" {
 		kinds++
 	}
+	if flags.FX != "" {
+		kinds++
+	}
+	if flags.Universe != "" {
+		kinds++
+	}
+	if flags.Dir != "" {
+		kinds++
+	}
 	if flags.UpdateMetadata {
 		kinds++
 	}
 	if flags.Cleanup {
 		kinds++
 	}
+	if flags.Convert != "" {
+		kinds++
+	}
 	if kinds != 1 {
 		return nil, errors.Reason(
-			"expected exactly one of -tickers, -prices, -update-metadata or -cleanup")
+			"expected exactly one of -tickers, -prices, -fx, -universe, -dir, -update-metadata, -cleanup or -convert")
 	}
 	if flags.Prices != "" && flags.Ticker == "" {
 		return nil, errors.Reason("-ticker is required with -prices")
 	}
+	if flags.Adjust && flags.Prices == "" {
+		return nil, errors.Reason("-adjust requires -prices")
+	}
+	if flags.FX != "" && flags.Pair == "" {
+		return nil, errors.Reason("-pair is required with -fx")
+	}
+	if flags.Universe != "" && flags.Members == "" && flags.Filter == "" {
+		return nil, errors.Reason("-members or -filter is required with -universe")
+	}
+	if flags.Update && flags.Dir == "" {
+		return nil, errors.Reason("-update requires -dir")
+	}
 	return &flags, err
 }
 
@@ -106,11 +155,15 @@ func readJSON(fileName string) (any, error) {
 }
 
 func importTickers(ctx context.Context, flags *Flags) error {
+	w := db.NewWriter(flags.DBDir, flags.DBName)
+	r, err := w.Begin()
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB for writing")
+	}
+	defer w.Rollback()
 	tickers := make(map[string]db.TickerRow)
 	if !flags.Replace {
-		r := db.NewReader(flags.DBDir, flags.DBName)
 		if r.HasTickers() {
-			var err error
 			tickers, err = r.AllTickerRows()
 			if err != nil {
 				return errors.Annotate(err, "failed to read existing tickers")
@@ -137,10 +190,12 @@ func importTickers(ctx context.Context, flags *Flags) error {
 		return errors.Annotate(err, "failed to read tickers from '%s'", flags.Tickers)
 	}
 
-	w := db.NewWriter(flags.DBDir, flags.DBName)
 	if err := w.WriteTickers(tickers); err != nil {
 		return errors.Annotate(err, "failed to write tickers to DB")
 	}
+	if err := w.Commit(); err != nil {
+		return errors.Annotate(err, "failed to commit DB")
+	}
 	logging.Infof(ctx, "imported %d tickers", len(tickers))
 	return nil
 }
@@ -163,24 +218,68 @@ func hasNaN(p db.PriceRow) bool {
 	return false
 }
 
-func updateMonthly(ctx context.Context, flags *Flags, prices []db.PriceRow) error {
-	r := db.NewReader(flags.DBDir, flags.DBName)
-	monthly := make(map[string][]db.ResampledRow)
-	if r.HasMonthly() {
+func updateResampled(ctx context.Context, flags *Flags, r *db.Reader, w *db.Writer, prices []db.PriceRow) error {
+	for _, f := range db.Frequencies {
+		rows := make(map[string][]db.ResampledRow)
+		if r.HasResampled(f) {
+			var err error
+			rows, err = r.AllResampledRows(f)
+			if err != nil {
+				return errors.Annotate(err, "failed to read existing %s data", f)
+			}
+		}
+		rows[flags.Ticker] = db.ComputeResampled(prices, f.Period)
+		if err := w.WriteResampled(f, rows); err != nil {
+			return errors.Annotate(err, "failed to save updated %s data", f)
+		}
+		logging.Infof(ctx, "wrote %d %s samples for %s",
+			len(rows[flags.Ticker]), f, flags.Ticker)
+	}
+	return nil
+}
+
+// pricesHeader returns the header of the prices CSV file, or the one set in the
+// config for a headless file.
+func pricesHeader(f io.ReadSeeker, c *db.PriceRowConfig) ([]string, error) {
+	if len(c.Header) > 0 {
+		return c.Header, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Annotate(err, "failed to rewind the file")
+	}
+	header, err := csv.NewReader(f).Read()
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read the header")
+	}
+	return header, nil
+}
+
+// adjustPrices computes the adjusted closing prices missing from the CSV file
+// from the unadjusted ones and the actions of the ticker read from r.
+func adjustPrices(ctx context.Context, flags *Flags, r *db.Reader, prices []db.PriceRow, hasSplit, hasFully bool) ([]db.PriceRow, error) {
+	if hasSplit && hasFully {
+		logging.Warningf(ctx, "the prices already have adjusted closes, not adjusting")
+		return prices, nil
+	}
+	var actions []db.ActionRow
+	if r.HasActions() {
 		var err error
-		monthly, err = r.AllMonthlyRows()
-		if err != nil {
-			return errors.Annotate(err, "failed to read existing monthly data")
+		if actions, err = r.Actions(flags.Ticker); err != nil {
+			return nil, errors.Annotate(err, "failed to read actions")
 		}
 	}
-	monthly[flags.Ticker] = db.ComputeMonthly(prices)
-	w := db.NewWriter(flags.DBDir, flags.DBName)
-	if err := w.WriteMonthly(monthly); err != nil {
-		return errors.Annotate(err, "failed to save updated monthly data")
+	logging.Infof(ctx, "adjusting prices for %d actions of %s",
+		len(actions), flags.Ticker)
+	adjusted := db.ComputeAdjusted(prices, actions)
+	for i := range adjusted {
+		if hasSplit {
+			adjusted[i].CloseSplitAdjusted = prices[i].CloseSplitAdjusted
+		}
+		if hasFully {
+			adjusted[i].CloseFullyAdjusted = prices[i].CloseFullyAdjusted
+		}
 	}
-	logging.Infof(ctx, "wrote %d monthly samples for %s",
-		len(monthly[flags.Ticker]), flags.Ticker)
-	return nil
+	return adjusted, nil
 }
 
 func importPrices(ctx context.Context, flags *Flags) error {
@@ -232,27 +331,180 @@ func importPrices(ctx context.Context, flags *Flags) error {
 		return errors.Reason("there are no prices to import")
 	}
 	w := db.NewWriter(flags.DBDir, flags.DBName)
+	r, err := w.Begin()
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB for writing")
+	}
+	defer w.Rollback()
+	if flags.Adjust {
+		header, err := pricesHeader(f, c)
+		if err != nil {
+			return errors.Annotate(err, "failed to read header of '%s'", flags.Prices)
+		}
+		hasSplit, hasFully := c.HasAdjusted(header)
+		if prices, err = adjustPrices(ctx, flags, r, prices, hasSplit, hasFully); err != nil {
+			return errors.Annotate(err, "failed to adjust prices for %s", flags.Ticker)
+		}
+	}
 	if err := w.WritePrices(flags.Ticker, prices); err != nil {
 		return errors.Annotate(err, "failed to write prices for %s to DB", flags.Ticker)
 	}
 	logging.Infof(ctx, "imported %d prices to %s", len(prices), flags.Ticker)
-	if err := updateMonthly(ctx, flags, prices); err != nil {
+	if err := updateResampled(ctx, flags, r, w, prices); err != nil {
 		return errors.Annotate(err,
-			"failed to update monthly prices for %s", flags.Ticker)
+			"failed to update resampled prices for %s", flags.Ticker)
+	}
+	if err := w.Commit(); err != nil {
+		return errors.Annotate(err, "failed to commit DB")
+	}
+	return nil
+}
+
+// importFX replaces the FX rates of the currency pair, preserving the other
+// pairs.
+func importFX(ctx context.Context, flags *Flags) error {
+	c := db.NewFXRowConfig()
+	if flags.Schema != "" {
+		js, err := readJSON(flags.Schema)
+		if err != nil {
+			return errors.Annotate(err, "failed to read config")
+		}
+		if err := c.InitMessage(js); err != nil {
+			return errors.Annotate(err, "failed to init FX config")
+		}
+	}
+	f, err := os.Open(flags.FX)
+	if err != nil {
+		return errors.Annotate(err, "cannot open FX file '%s'", flags.FX)
+	}
+	defer f.Close()
+
+	rows, err := db.ReadCSVFX(f, c)
+	if err != nil {
+		return errors.Annotate(err, "failed to read FX rates from '%s'", flags.FX)
+	}
+	if len(rows) == 0 {
+		return errors.Reason("there are no FX rates to import")
+	}
+	w := db.NewWriter(flags.DBDir, flags.DBName)
+	r, err := w.Begin()
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB for writing")
+	}
+	defer w.Rollback()
+	rates := make(map[string][]db.FXRow)
+	if r.HasFX() {
+		rates, err = r.AllFXRows()
+		if err != nil {
+			return errors.Annotate(err, "failed to read existing FX rates")
+		}
+	}
+	rates[flags.Pair] = rows
+	if err := w.WriteFX(rates); err != nil {
+		return errors.Annotate(err, "failed to write FX rates to DB")
+	}
+	if err := w.Commit(); err != nil {
+		return errors.Annotate(err, "failed to commit DB")
+	}
+	logging.Infof(ctx, "imported %d FX rates for %s", len(rows), flags.Pair)
+	return nil
+}
+
+// importUniverse merges the membership snapshots and replaces the filter of the
+// named universe, or replaces the entire universe with -replace.
+func importUniverse(ctx context.Context, flags *Flags) error {
+	w := db.NewWriter(flags.DBDir, flags.DBName)
+	r, err := w.Begin()
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB for writing")
+	}
+	defer w.Rollback()
+	universes := make(map[string]db.Universe)
+	if r.HasUniverses() {
+		if universes, err = r.AllUniverses(); err != nil {
+			return errors.Annotate(err, "failed to read existing universes")
+		}
+	}
+	u := universes[flags.Universe]
+	if flags.Replace {
+		u = db.Universe{}
+	}
+	if flags.Members != "" {
+		c := db.NewUniverseRowConfig()
+		if flags.Schema != "" {
+			js, err := readJSON(flags.Schema)
+			if err != nil {
+				return errors.Annotate(err, "failed to read config")
+			}
+			if err := c.InitMessage(js); err != nil {
+				return errors.Annotate(err, "failed to init universe config")
+			}
+		}
+		f, err := os.Open(flags.Members)
+		if err != nil {
+			return errors.Annotate(err, "cannot open members file '%s'", flags.Members)
+		}
+		defer f.Close()
+
+		snapshots, err := db.ReadCSVUniverse(f, c)
+		if err != nil {
+			return errors.Annotate(err, "failed to read members from '%s'", flags.Members)
+		}
+		u.AddSnapshots(snapshots...)
+		logging.Infof(ctx, "imported %d membership snapshots", len(snapshots))
+	}
+	if flags.Filter != "" {
+		js, err := readJSON(flags.Filter)
+		if err != nil {
+			return errors.Annotate(err, "failed to read filter")
+		}
+		var e db.Expr
+		if err := e.InitMessage(js); err != nil {
+			return errors.Annotate(err, "invalid filter")
+		}
+		filter, err := json.Marshal(js)
+		if err != nil {
+			return errors.Annotate(err, "failed to encode filter")
+		}
+		u.Filter = string(filter)
 	}
+	universes[flags.Universe] = u
+	if err := w.WriteUniverses(universes); err != nil {
+		return errors.Annotate(err, "failed to write universes to DB")
+	}
+	if err := w.Commit(); err != nil {
+		return errors.Annotate(err, "failed to commit DB")
+	}
+	logging.Infof(ctx, "imported universe %s", flags.Universe)
 	return nil
 }
 
+// importDir populates the DB from a directory of CSV files, replacing all of
+// its content, or updates it incrementally with -update.
+func importDir(ctx context.Context, flags *Flags) error {
+	p := provider.NewCSVDir(flags.Dir)
+	if flags.Update {
+		return provider.UpdateAll(ctx, p, flags.DBDir, flags.DBName)
+	}
+	return provider.DownloadAll(ctx, p, flags.DBDir, flags.DBName)
+}
+
 func updateMetadata(ctx context.Context, flags *Flags) error {
-	r := db.NewReader(flags.DBDir, flags.DBName)
-	if !r.HasTickers() {
+	if !db.NewReader(flags.DBDir, flags.DBName).HasTickers() {
 		return errors.Reason("no tickers found in DB %s", flags.DBName)
 	}
+	w := db.NewWriter(flags.DBDir, flags.DBName)
+	r, err := w.Begin()
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB for writing")
+	}
+	defer w.Rollback()
 	tickers, err := r.AllTickerRows()
 	if err != nil {
 		return errors.Annotate(err, "failed to read tickers from %s", flags.DBName)
 	}
-	var m db.Metadata
+	m := db.Metadata{Format: w.Metadata.Format}
+	m.UpdateTickers(tickers)
 	for t := range tickers {
 		prices, err := r.Prices(t)
 		if err != nil {
@@ -260,18 +512,48 @@ func updateMetadata(ctx context.Context, flags *Flags) error {
 			continue
 		}
 		m.UpdatePrices(prices)
-		m.NumTickers++
 	}
-	monthly, err := r.AllMonthlyRows()
-	if err != nil {
-		return errors.Annotate(err, "failed to read monthly data from %s",
-			flags.DBName)
+	for t := range tickers {
+		if !r.HasFundamentals(t) {
+			continue
+		}
+		rows, err := r.AllFundamentalsRows(t)
+		if err != nil {
+			return errors.Annotate(err, "failed to read fundamentals from %s", flags.DBName)
+		}
+		m.UpdateFundamentals(rows)
+	}
+	for _, f := range db.Frequencies {
+		if !r.HasResampled(f) {
+			continue
+		}
+		rows, err := r.AllResampledRows(f)
+		if err != nil {
+			return errors.Annotate(err, "failed to read %s data from %s",
+				f, flags.DBName)
+		}
+		m.UpdateResampled(f, rows)
+	}
+	if r.HasActions() {
+		actions, err := r.AllActionRows()
+		if err != nil {
+			return errors.Annotate(err, "failed to read actions from %s", flags.DBName)
+		}
+		m.UpdateActions(actions)
+	}
+	if r.HasFX() {
+		rates, err := r.AllFXRows()
+		if err != nil {
+			return errors.Annotate(err, "failed to read FX rates from %s", flags.DBName)
+		}
+		m.UpdateFX(rates)
 	}
-	m.UpdateMonthly(monthly)
-	w := db.NewWriter(flags.DBDir, flags.DBName)
 	if err := w.WriteMetadata(m); err != nil {
 		return errors.Annotate(err, "failed to write metadata to %s", flags.DBName)
 	}
+	if err := w.Commit(); err != nil {
+		return errors.Annotate(err, "failed to commit DB")
+	}
 	logging.Infof(ctx, "updated metadata")
 	return nil
 }
@@ -291,6 +573,18 @@ func run(args []string) error {
 		return errors.Annotate(importPrices(ctx, flags),
 			"failed to import prices for %s from '%s'", flags.Ticker, flags.Prices)
 	}
+	if flags.FX != "" {
+		return errors.Annotate(importFX(ctx, flags),
+			"failed to import FX rates for %s from '%s'", flags.Pair, flags.FX)
+	}
+	if flags.Universe != "" {
+		return errors.Annotate(importUniverse(ctx, flags),
+			"failed to import universe %s", flags.Universe)
+	}
+	if flags.Dir != "" {
+		return errors.Annotate(importDir(ctx, flags),
+			"failed to import DB from '%s'", flags.Dir)
+	}
 	if flags.UpdateMetadata {
 		return errors.Annotate(updateMetadata(ctx, flags),
 			"failed to update metadata")
@@ -299,6 +593,14 @@ func run(args []string) error {
 		return errors.Annotate(db.Cleanup(ctx, flags.DBDir, flags.DBName),
 			"failed to clean up DB")
 	}
+	if flags.Convert != "" {
+		format, err := db.NewStorageFormat(flags.Convert)
+		if err != nil {
+			return errors.Annotate(err, "invalid -convert value")
+		}
+		return errors.Annotate(db.Convert(ctx, flags.DBDir, flags.DBName, format),
+			"failed to convert DB to %s format", format)
+	}
 	return nil
 }
 
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"math"
 	"os"
 	"path/filepath"
@@ -56,11 +57,42 @@ func TestMain(t *testing.T) {
 			So(flags.UpdateMetadata, ShouldBeFalse)
 		})
 
+		Convey("-adjust without -prices", func() {
+			_, err := parseFlags([]string{"-db", "name", "-tickers", "t.csv", "-adjust"})
+			So(err, ShouldNotBeNil)
+		})
+
 		Convey("-prices without -ticker", func() {
 			_, err := parseFlags([]string{"-db", "name", "-prices", "prices.csv"})
 			So(err, ShouldNotBeNil)
 		})
 
+		Convey("-fx with -pair", func() {
+			flags, err := parseFlags([]string{
+				"-db", "name", "-fx", "fx.csv", "-pair", "EURUSD"})
+			So(err, ShouldBeNil)
+			So(flags.FX, ShouldEqual, "fx.csv")
+			So(flags.Pair, ShouldEqual, "EURUSD")
+		})
+
+		Convey("-fx without -pair", func() {
+			_, err := parseFlags([]string{"-db", "name", "-fx", "fx.csv"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("-universe with -members", func() {
+			flags, err := parseFlags([]string{
+				"-db", "name", "-universe", "U", "-members", "u.csv"})
+			So(err, ShouldBeNil)
+			So(flags.Universe, ShouldEqual, "U")
+			So(flags.Members, ShouldEqual, "u.csv")
+		})
+
+		Convey("-universe without -members or -filter", func() {
+			_, err := parseFlags([]string{"-db", "name", "-universe", "U"})
+			So(err, ShouldNotBeNil)
+		})
+
 		Convey("-update-metada", func() {
 			flags, err := parseFlags([]string{"-db", "name", "-update-metadata"})
 			So(err, ShouldBeNil)
@@ -70,6 +102,18 @@ func TestMain(t *testing.T) {
 			So(flags.UpdateMetadata, ShouldBeTrue)
 		})
 
+		Convey("-dir with -update", func() {
+			flags, err := parseFlags([]string{"-db", "name", "-dir", "path/to/csv", "-update"})
+			So(err, ShouldBeNil)
+			So(flags.Dir, ShouldEqual, "path/to/csv")
+			So(flags.Update, ShouldBeTrue)
+		})
+
+		Convey("-update without -dir", func() {
+			_, err := parseFlags([]string{"-db", "name", "-tickers", "t.csv", "-update"})
+			So(err, ShouldNotBeNil)
+		})
+
 		Convey("Incompatible flags", func() {
 			_, err := parseFlags([]string{
 				"-db", "name", "-prices", "prices.csv", "-update-metadata"})
@@ -246,6 +290,173 @@ FALSE,11,2020-01-04,ignored,Inf
 			So(prices, ShouldResemble, expected)
 		})
 
+		Convey("import unadjusted prices with -adjust", func() {
+			w := db.NewWriter(tmpdir, dbName)
+			So(w.WriteActions(map[string][]db.ActionRow{
+				"A": {
+					db.TestAction(db.NewDate(2020, 1, 2), db.SplitAction, 2.0, ""),
+					db.TestAction(db.NewDate(2020, 1, 3), db.DividendAction, 0.5, ""),
+				},
+			}), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+			So(testutil.WriteFile(pricesFile, `
+Date,Close,Cash Volume
+2020-01-01,20,1000
+2020-01-02,10,1000
+2020-01-03,9.5,1000
+`),
+				ShouldBeNil)
+			So(run(append(args, "-prices", pricesFile, "-ticker", "A", "-adjust")),
+				ShouldBeNil)
+			expected := []db.PriceRow{
+				db.TestPriceRow(db.NewDate(2020, 1, 1), 0, 0, 0, 20, 10, 9.5, 1000, true),
+				db.TestPriceRow(db.NewDate(2020, 1, 2), 0, 0, 0, 10, 10, 9.5, 1000, true),
+				db.TestPriceRow(db.NewDate(2020, 1, 3), 0, 0, 0, 9.5, 9.5, 9.5, 1000, true),
+			}
+			reader := db.NewReader(tmpdir, dbName)
+			prices, err := reader.Prices("A")
+			So(err, ShouldBeNil)
+			So(prices, ShouldResemble, expected)
+
+			// Vendor-supplied split adjusted closes are preserved.
+			So(testutil.WriteFile(pricesFile, `
+Date,Close,Close split adj,Cash Volume
+2020-01-01,20,11,1000
+2020-01-02,10,10,1000
+2020-01-03,9.5,9.5,1000
+`),
+				ShouldBeNil)
+			So(run(append(args, "-prices", pricesFile, "-ticker", "A", "-adjust")),
+				ShouldBeNil)
+			expected[0].CloseSplitAdjusted = 11
+			reader = db.NewReader(tmpdir, dbName)
+			prices, err = reader.Prices("A")
+			So(err, ShouldBeNil)
+			So(prices, ShouldResemble, expected)
+		})
+
+		Convey("import FX rates", func() {
+			fxFile := filepath.Join(tmpdir, "fx.csv")
+			So(testutil.WriteFile(tickersFile, `
+Ticker,Currency
+A,EUR
+`),
+				ShouldBeNil)
+			So(testutil.WriteFile(pricesFile, `
+Date,Open,High,Low,Close,Close split adj,Close fully adj,Cash Volume
+2020-01-02,10,10,10,10,10,10,1000
+2020-01-03,11,11,11,11,11,11,1100
+`),
+				ShouldBeNil)
+			So(testutil.WriteFile(fxFile, `
+Date,Rate
+2020-01-03,1.5
+2020-01-01,2
+`),
+				ShouldBeNil)
+			So(run(append(args, "-tickers", tickersFile)), ShouldBeNil)
+			So(run(append(args, "-prices", pricesFile, "-ticker", "A")), ShouldBeNil)
+			So(run(append(args, "-fx", fxFile, "-pair", "EURUSD")), ShouldBeNil)
+
+			reader := db.NewReader(tmpdir, dbName)
+			fx, err := reader.FX("EURUSD")
+			So(err, ShouldBeNil)
+			So(fx, ShouldResemble, []db.FXRow{
+				{Date: db.NewDate(2020, 1, 1), Rate: 2},
+				{Date: db.NewDate(2020, 1, 3), Rate: 1.5},
+			})
+			reader.Currency = "USD"
+			prices, err := reader.Prices("A")
+			So(err, ShouldBeNil)
+			So(prices, ShouldResemble, []db.PriceRow{
+				db.TestPrice(db.NewDate(2020, 1, 2), 20, 20, 20, 2000, true),
+				db.TestPrice(db.NewDate(2020, 1, 3), 16.5, 16.5, 16.5, 1650, true),
+			})
+		})
+
+		Convey("import universe", func() {
+			membersFile := filepath.Join(tmpdir, "members.csv")
+			filterFile := filepath.Join(tmpdir, "filter.json")
+			So(testutil.WriteFile(tickersFile, `
+Ticker,Exchange
+A,NYSE
+B,NYSE
+C,OTC
+`),
+				ShouldBeNil)
+			So(testutil.WriteFile(membersFile, `
+Date,Ticker
+2020-01-01,A
+2020-01-01,B
+2021-01-01,B
+2021-01-01,C
+`),
+				ShouldBeNil)
+			So(testutil.WriteFile(filterFile, `{"not": {"exchanges": ["OTC"]}}`),
+				ShouldBeNil)
+			So(run(append(args, "-tickers", tickersFile)), ShouldBeNil)
+			So(run(append(args, "-universe", "U", "-members", membersFile)), ShouldBeNil)
+			So(run(append(args, "-universe", "U", "-filter", filterFile)), ShouldBeNil)
+
+			reader := db.NewReader(tmpdir, dbName)
+			u, err := reader.UniverseByName("U")
+			So(err, ShouldBeNil)
+			So(u, ShouldResemble, db.Universe{
+				Snapshots: []db.UniverseSnapshot{
+					{Date: db.NewDate(2020, 1, 1), Tickers: []string{"A", "B"}},
+					{Date: db.NewDate(2021, 1, 1), Tickers: []string{"B", "C"}},
+				},
+				Filter: `{"not":{"exchanges":["OTC"]}}`,
+			})
+			reader.Universe = "U"
+			tickers, err := reader.Tickers(context.Background())
+			So(err, ShouldBeNil)
+			So(tickers, ShouldResemble, []string{"B"})
+
+			So(run(append(args, "-universe", "U", "-members", membersFile, "-replace")),
+				ShouldBeNil)
+			reader = db.NewReader(tmpdir, dbName)
+			u, err = reader.UniverseByName("U")
+			So(err, ShouldBeNil)
+			So(u.Filter, ShouldEqual, "")
+		})
+
+		Convey("import directory", func() {
+			dir := filepath.Join(tmpdir, "csv")
+			So(os.MkdirAll(filepath.Join(dir, "prices"), 0755), ShouldBeNil)
+			So(testutil.WriteFile(filepath.Join(dir, "tickers.csv"), `
+Ticker,Name
+A,Company A
+`),
+				ShouldBeNil)
+			So(testutil.WriteFile(filepath.Join(dir, "prices", "A.csv"), `
+Date,Open,High,Low,Close,Close split adj,Close fully adj,Cash Volume
+2020-01-02,10,10,10,10,10,10,1000
+`),
+				ShouldBeNil)
+			So(run(append(args, "-dir", dir)), ShouldBeNil)
+
+			So(testutil.WriteFile(filepath.Join(dir, "prices", "A.csv"), `
+Date,Open,High,Low,Close,Close split adj,Close fully adj,Cash Volume
+2020-01-02,10,10,10,10,10,10,1000
+2020-01-03,11,11,11,11,11,11,1100
+`),
+				ShouldBeNil)
+			So(run(append(args, "-dir", dir, "-update")), ShouldBeNil)
+
+			reader := db.NewReader(tmpdir, dbName)
+			prices, err := reader.Prices("A")
+			So(err, ShouldBeNil)
+			So(prices, ShouldResemble, []db.PriceRow{
+				db.TestPrice(db.NewDate(2020, 1, 2), 10, 10, 10, 1000, true),
+				db.TestPrice(db.NewDate(2020, 1, 3), 11, 11, 11, 1100, true),
+			})
+			meta, err := reader.Metadata()
+			So(err, ShouldBeNil)
+			So(meta.NumPrices, ShouldEqual, 2)
+			So(meta.NumMonthly, ShouldEqual, 1)
+		})
+
 		Convey("update metadata", func() {
 			So(testutil.WriteFile(tickersFile, `
 Ticker
@@ -269,18 +480,39 @@ Date,Close fully adj
 			So(run(append(args, "-tickers", tickersFile)), ShouldBeNil)
 			So(run(append(args, "-prices", pricesFile, "-ticker", "A")), ShouldBeNil)
 			So(run(append(args, "-prices", pricesFile2, "-ticker", "B")), ShouldBeNil)
+			w := db.NewWriter(tmpdir, dbName)
+			So(w.WriteActions(map[string][]db.ActionRow{
+				"A": {db.TestAction(db.NewDate(2020, 1, 2), db.ListedAction, 0, "")},
+			}), ShouldBeNil)
+			So(w.WriteFundamentals("A", []db.FundamentalsRow{
+				db.TestFundamentals(db.ARQ, db.NewDate(2020, 2, 10),
+					db.NewDate(2019, 12, 31), 100.0, 10.0),
+			}), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
 			So(run(append(args, "-update-metadata")), ShouldBeNil)
 
 			reader := db.NewReader(tmpdir, dbName)
 			m, err := reader.Metadata()
 			So(err, ShouldBeNil)
 			So(m, ShouldResemble, db.Metadata{
-				Start:      db.NewDate(2020, 1, 2),
-				End:        db.NewDate(2020, 3, 10),
-				NumTickers: 2,
-				NumPrices:  4,
-				NumMonthly: 4,
+				Start:           db.NewDate(2020, 1, 2),
+				End:             db.NewDate(2020, 3, 10),
+				NumTickers:      3, // including IGNORED without prices
+				NumPrices:       4,
+				NumWeekly:       4,
+				NumMonthly:      4,
+				NumQuarterly:    2,
+				NumYearly:       2,
+				NumActions:      1,
+				NumFundamentals: 1,
 			})
+
+			// The metadata agrees with parfait-check.
+			c, err := db.Check(context.Background(), tmpdir, dbName, false)
+			So(err, ShouldBeNil)
+			for _, i := range c.Issues {
+				So(i.Kind, ShouldNotEqual, db.MetadataMismatch)
+			}
 		})
 
 		Convey("cleanup", func() {
@@ -303,11 +535,42 @@ Date,Close fully adj
 			So(run(append(args, "-prices", pricesFile, "-ticker", "A")), ShouldBeNil)
 			So(run(append(args, "-prices", pricesFile2, "-ticker", "B")), ShouldBeNil)
 
-			bFile := filepath.Join(tmpdir, dbName, "prices", "B.gob")
+			bFile := filepath.Join(tmpdir, dbName, "current", "prices", "B.gob")
 			So(testutil.FileExists(bFile), ShouldBeTrue)
 
 			So(run(append(args, "-cleanup")), ShouldBeNil)
 			So(testutil.FileExists(bFile), ShouldBeFalse)
 		})
+
+		Convey("convert", func() {
+			So(testutil.WriteFile(tickersFile, `
+Ticker
+A
+`),
+				ShouldBeNil)
+			So(testutil.WriteFile(pricesFile, `
+Date,Close fully adj
+2020-01-02,10
+2020-02-02,11
+`),
+				ShouldBeNil)
+			So(run(append(args, "-tickers", tickersFile)), ShouldBeNil)
+			So(run(append(args, "-prices", pricesFile, "-ticker", "A")), ShouldBeNil)
+			So(run(append(args, "-update-metadata")), ShouldBeNil)
+			So(run(append(args, "-convert", "columnar")), ShouldBeNil)
+
+			aFile := filepath.Join(tmpdir, dbName, "current", "prices", "A.col")
+			So(testutil.FileExists(aFile), ShouldBeTrue)
+
+			reader := db.NewReader(tmpdir, dbName)
+			m, err := reader.Metadata()
+			So(err, ShouldBeNil)
+			So(m.Format, ShouldEqual, db.ColumnarFormat)
+			prices, err := reader.Prices("A")
+			So(err, ShouldBeNil)
+			So(len(prices), ShouldEqual, 2)
+
+			So(run(append(args, "-convert", "unknown")), ShouldNotBeNil)
+		})
 	})
 }
@@ -32,10 +32,11 @@ type Flags struct {
 	DBDir    string // default: ~/.stockparfait
 	DBName   string // required
 	LogLevel logging.Level
-	// Exactly one of tickers, prices or monthly must be present.
+	// Exactly one of tickers, prices, monthly or actions must be present.
 	Tickers bool
 	Prices  string // ticker to print prices for
 	Monthly string // ticker to print monthly data for
+	Actions string // ticker to print actions for
 	CSV     bool   // dump CSV format; default: text.
 }
 
@@ -51,6 +52,7 @@ func parseFlags(args []string) (*Flags, error) {
 	fs.BoolVar(&flags.Tickers, "tickers", false, "print all ticker rows")
 	fs.StringVar(&flags.Prices, "prices", "", "ticker to print prices for")
 	fs.StringVar(&flags.Monthly, "monthly", "", "ticker to print monthly data for")
+	fs.StringVar(&flags.Actions, "actions", "", "ticker to print actions for")
 	fs.BoolVar(&flags.CSV, "csv", false, "print table in CSV format; default: text")
 
 	err := fs.Parse(args)
@@ -70,9 +72,12 @@ func parseFlags(args []string) (*Flags, error) {
 	if flags.Monthly != "" {
 		kinds++
 	}
+	if flags.Actions != "" {
+		kinds++
+	}
 	if kinds != 1 {
 		return nil, errors.Reason(
-			"expected exactly one of -tickers, -prices or -monthly")
+			"expected exactly one of -tickers, -prices, -monthly or -actions")
 	}
 	return &flags, err
 }
@@ -124,6 +129,20 @@ func monthlyTable(ctx context.Context, reader *db.Reader, ticker string) (*table
 	return tbl, nil
 }
 
+func actionsTable(ctx context.Context, reader *db.Reader, ticker string) (*table.Table, error) {
+	actions, err := reader.Actions(ticker)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read actions for %s", ticker)
+	}
+	rows := make([]table.Row, len(actions))
+	for i, a := range actions {
+		rows[i] = a
+	}
+	tbl := table.NewTable(db.ActionRowHeader()...)
+	tbl.AddRow(rows...)
+	return tbl, nil
+}
+
 func printData(ctx context.Context, flags *Flags, w io.Writer) error {
 	var tbl *table.Table
 	var err error
@@ -144,6 +163,11 @@ func printData(ctx context.Context, flags *Flags, w io.Writer) error {
 				flags.Monthly)
 		}
 	}
+	if flags.Actions != "" {
+		if tbl, err = actionsTable(ctx, reader, flags.Actions); err != nil {
+			return errors.Annotate(err, "failed to read actions for %s", flags.Actions)
+		}
+	}
 	if tbl == nil {
 		return errors.Reason("no data")
 	}
@@ -64,11 +64,19 @@ func TestMain(t *testing.T) {
 				db.TestResampled(db.NewDate(2019, 2, 1), db.NewDate(2019, 2, 28), 10.0, 10.0, 10.0, 1000.0, false),
 			},
 		}
+		actions := map[string][]db.ActionRow{
+			"A": {
+				db.TestAction(db.NewDate(2019, 1, 2), db.SplitAction, 2.0, ""),
+				db.TestAction(db.NewDate(2019, 1, 3), db.DelistedAction, 0.0, ""),
+			},
+		}
 		w := db.NewWriter(tmpdir, dbName)
 		So(w.WriteTickers(tickers), ShouldBeNil)
 		So(w.WritePrices("A", pricesA), ShouldBeNil)
 		So(w.WriteMonthly(monthly), ShouldBeNil)
+		So(w.WriteActions(actions), ShouldBeNil)
 		So(w.WriteMetadata(w.Metadata), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
 
 		ctx := context.Background()
 
@@ -79,9 +87,9 @@ func TestMain(t *testing.T) {
 			var buf bytes.Buffer
 			So(printData(ctx, flags, &buf), ShouldBeNil)
 			So("\n"+buf.String(), ShouldEqual, `
-Ticker,Source,Exchange,Name,Category,Sector,Industry,Location,SEC Filings,Company Site,Active
-A,test,,,,,,,,,TRUE
-B,test,,,,,,,,,FALSE
+Ticker,Source,Exchange,Name,Category,Sector,Industry,Location,SEC Filings,Company Site,Active,Currency,ID
+A,test,,,,,,,,,TRUE,,
+B,test,,,,,,,,,FALSE,,
 `)
 		})
 
@@ -109,6 +117,19 @@ Date,Open,High,Low,Close,Close split adj,Close fully adj,Cash Volume,Active
 Open,Open split adj,Open fully adj,Close,Close split adj,Close fully adj,Cash Volume,Date Open,Date Close,Sum Abs Log Profits,Samples,Active
 10,10,10,10,10,10,1000,2019-01-01,2019-01-31,0.2,20,TRUE
 10,10,10,10,10,10,1000,2019-02-01,2019-02-28,0.2,20,FALSE
+`)
+		})
+
+		Convey("actions", func() {
+			flags, err := parseFlags([]string{"-cache", tmpdir, "-db", dbName,
+				"-actions", "A", "-csv"})
+			So(err, ShouldBeNil)
+			var buf bytes.Buffer
+			So(printData(ctx, flags, &buf), ShouldBeNil)
+			So("\n"+buf.String(), ShouldEqual, `
+Date,Action,Value,Contra Ticker
+2019-01-02,split,2,
+2019-01-03,delisted,0,
 `)
 		})
 	})
@@ -90,6 +90,7 @@ func TestMain(t *testing.T) {
 		So(w.WriteTickers(tickers), ShouldBeNil)
 		So(w.WritePrices("A", pricesA), ShouldBeNil)
 		So(w.WritePrices("B", pricesB), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
 
 		ctx := context.Background()
 		configFile := filepath.Join(tmpdir, "config.json")
@@ -31,6 +31,7 @@ import (
 type Flags struct {
 	DBDir    string // default: ~/.stockparfait
 	DBName   string // default: sharadar
+	Update   bool   // incremental update of an existing DB
 	LogLevel logging.Level
 }
 
@@ -41,6 +42,8 @@ func parseFlags(args []string) (*Flags, error) {
 		filepath.Join(os.Getenv("HOME"), ".stockparfait"),
 		"path to databases")
 	fs.StringVar(&flags.DBName, "db", "sharadar", "database name")
+	fs.BoolVar(&flags.Update, "update", false,
+		"download only the prices after the latest date in the existing DB")
 	flags.LogLevel = logging.Info
 	fs.Var(&flags.LogLevel, "log-level", "Log level: debug, info, warning, error")
 
@@ -51,6 +54,8 @@ func parseFlags(args []string) (*Flags, error) {
 type Config struct {
 	Key    string               `toml:"key"`    // user key for Nasdaq Data Link
 	Tables []sharadar.TableName `toml:"tables"` // which price tables to download
+	// Directory for caching bulk downloads, relative to the DB; default: none.
+	BulkCache string `toml:"bulk_cache"`
 }
 
 func parseConfig(dbdir string) (*Config, error) {
@@ -91,6 +96,18 @@ func download(ctx context.Context, flags *Flags) error {
 
 	ctx = ndl.UseClient(ctx, config.Key)
 	ds := sharadar.NewDataset()
+	if config.BulkCache != "" {
+		ds.BulkCache = config.BulkCache
+		if !filepath.IsAbs(ds.BulkCache) {
+			ds.BulkCache = filepath.Join(flags.DBDir, flags.DBName, ds.BulkCache)
+		}
+	}
+	if flags.Update {
+		if err := ds.UpdateAll(ctx, flags.DBDir, flags.DBName, config.Tables...); err != nil {
+			return errors.Annotate(err, "failed to update data")
+		}
+		return nil
+	}
 	if err := ds.DownloadAll(ctx, flags.DBDir, flags.DBName, config.Tables...); err != nil {
 		return errors.Annotate(err, "failed to download data")
 	}
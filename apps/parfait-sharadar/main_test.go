@@ -41,6 +41,11 @@ func TestMain(t *testing.T) {
 		So(flags.DBDir, ShouldEqual, "path/to/cache")
 		So(flags.DBName, ShouldEqual, "name")
 		So(flags.LogLevel, ShouldEqual, logging.Warning)
+		So(flags.Update, ShouldBeFalse)
+
+		flags, err = parseFlags([]string{"-update"})
+		So(err, ShouldBeNil)
+		So(flags.Update, ShouldBeTrue)
 	})
 
 	Convey("parseConfig", t, func() {
@@ -0,0 +1,217 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calendar
+
+import (
+	"time"
+)
+
+// Regular and early closing times of the trading session in New York time,
+// as hours since midnight.
+const (
+	RegularClose = 16
+	EarlyClose   = 13
+)
+
+// closures are the unscheduled full-day closures which cannot be derived from
+// the rules, e.g. national days of mourning and weather events.
+var closures = map[time.Time]string{
+	date(1994, time.April, 27):     "Nixon's funeral",
+	date(2001, time.September, 11): "September 11",
+	date(2001, time.September, 12): "September 11",
+	date(2001, time.September, 13): "September 11",
+	date(2001, time.September, 14): "September 11",
+	date(2004, time.June, 11):      "Reagan's funeral",
+	date(2007, time.January, 2):    "Ford's funeral",
+	date(2012, time.October, 29):   "Hurricane Sandy",
+	date(2012, time.October, 30):   "Hurricane Sandy",
+	date(2018, time.December, 5):   "G.H.W. Bush's funeral",
+	date(2025, time.January, 9):    "Carter's funeral",
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// day strips the time of day and the location from t.
+func day(t time.Time) time.Time {
+	return date(t.Year(), t.Month(), t.Day())
+}
+
+// nthWeekday returns the n'th weekday of the month, counting from 1. A negative
+// n counts from the end of the month, e.g. -1 is the last weekday.
+func nthWeekday(year int, month time.Month, weekday time.Weekday, n int) time.Time {
+	if n < 0 {
+		last := date(year, month+1, 0)
+		offset := (int(last.Weekday()) - int(weekday) + 7) % 7
+		return last.AddDate(0, 0, -offset+7*(n+1))
+	}
+	first := date(year, month, 1)
+	offset := (int(weekday) - int(first.Weekday()) + 7) % 7
+	return first.AddDate(0, 0, offset+7*(n-1))
+}
+
+// observed moves a holiday falling on Saturday to Friday, and on Sunday to
+// Monday.
+func observed(t time.Time) time.Time {
+	switch t.Weekday() {
+	case time.Saturday:
+		return t.AddDate(0, 0, -1)
+	case time.Sunday:
+		return t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// easter Sunday of the year by the anonymous Gregorian algorithm.
+func easter(year int) time.Time {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	dayOfMonth := (h+l-7*m+114)%31 + 1
+	return date(year, time.Month(month), dayOfMonth)
+}
+
+// Holidays returns the regular NYSE holidays of the year as observed by the
+// exchange, sorted by date. The rules of the recent decades are applied to all
+// years, except that Martin Luther King Jr. Day is observed since 1998 and
+// Juneteenth since 2022. Note, that New Year's Day falling on Saturday is not
+// observed on the preceding Friday. Unscheduled closures are not included.
+func Holidays(year int) []time.Time {
+	var res []time.Time
+	if newYear := date(year, time.January, 1); newYear.Weekday() != time.Saturday {
+		res = append(res, observed(newYear))
+	}
+	if year >= 1998 {
+		res = append(res, nthWeekday(year, time.January, time.Monday, 3))
+	}
+	res = append(res,
+		nthWeekday(year, time.February, time.Monday, 3), // Washington's Birthday
+		easter(year).AddDate(0, 0, -2),                  // Good Friday
+		nthWeekday(year, time.May, time.Monday, -1),     // Memorial Day
+	)
+	if year >= 2022 {
+		res = append(res, observed(date(year, time.June, 19)))
+	}
+	res = append(res,
+		observed(date(year, time.July, 4)),
+		nthWeekday(year, time.September, time.Monday, 1),  // Labor Day
+		nthWeekday(year, time.November, time.Thursday, 4), // Thanksgiving
+		observed(date(year, time.December, 25)),
+	)
+	return res
+}
+
+// IsHoliday checks if the exchange is closed on a weekday, either for a regular
+// holiday or an unscheduled closure.
+func IsHoliday(t time.Time) bool {
+	t = day(t)
+	if _, ok := closures[t]; ok {
+		return true
+	}
+	for _, h := range Holidays(t.Year()) {
+		if h.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTradingDay checks if the exchange is open on the day of t.
+func IsTradingDay(t time.Time) bool {
+	if w := t.Weekday(); w == time.Saturday || w == time.Sunday {
+		return false
+	}
+	return !IsHoliday(t)
+}
+
+// IsEarlyClose checks if the trading session closes early (at EarlyClose) on
+// the day of t: on the day before Independence Day, the day after Thanksgiving
+// and on Christmas Eve, provided these are trading days.
+func IsEarlyClose(t time.Time) bool {
+	t = day(t)
+	if !IsTradingDay(t) {
+		return false
+	}
+	year := t.Year()
+	switch {
+	case t.Equal(date(year, time.July, 3)):
+		return true
+	case t.Equal(nthWeekday(year, time.November, time.Thursday, 4).AddDate(0, 0, 1)):
+		return true
+	case t.Equal(date(year, time.December, 24)):
+		return true
+	}
+	return false
+}
+
+// CloseHour returns the closing hour of the trading session on the day of t in
+// New York time, or 0 if it is not a trading day.
+func CloseHour(t time.Time) int {
+	switch {
+	case !IsTradingDay(t):
+		return 0
+	case IsEarlyClose(t):
+		return EarlyClose
+	}
+	return RegularClose
+}
+
+// NextTradingDay returns the first trading day strictly after the day of t.
+func NextTradingDay(t time.Time) time.Time {
+	t = day(t).AddDate(0, 0, 1)
+	for !IsTradingDay(t) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// PrevTradingDay returns the last trading day strictly before the day of t.
+func PrevTradingDay(t time.Time) time.Time {
+	t = day(t).AddDate(0, 0, -1)
+	for !IsTradingDay(t) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t
+}
+
+// TradingDays returns the trading days in the inclusive range of days between
+// start and end.
+func TradingDays(start, end time.Time) []time.Time {
+	var res []time.Time
+	end = day(end)
+	for t := day(start); !t.After(end); t = t.AddDate(0, 0, 1) {
+		if IsTradingDay(t) {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// TradingDaysBetween returns the number of trading days in the inclusive range
+// of days between start and end, or 0 if start is after end.
+func TradingDaysBetween(start, end time.Time) int {
+	return len(TradingDays(start, end))
+}
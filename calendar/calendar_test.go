@@ -0,0 +1,115 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCalendar(t *testing.T) {
+	t.Parallel()
+
+	Convey("Holidays work", t, func() {
+		Convey("in 2021", func() {
+			So(Holidays(2021), ShouldResemble, []time.Time{
+				date(2021, time.January, 1),
+				date(2021, time.January, 18),
+				date(2021, time.February, 15),
+				date(2021, time.April, 2),
+				date(2021, time.May, 31),
+				date(2021, time.July, 5),
+				date(2021, time.September, 6),
+				date(2021, time.November, 25),
+				date(2021, time.December, 24),
+			})
+		})
+
+		Convey("in 2022", func() {
+			So(Holidays(2022), ShouldResemble, []time.Time{
+				// New Year's Day on Saturday is not observed.
+				date(2022, time.January, 17),
+				date(2022, time.February, 21),
+				date(2022, time.April, 15),
+				date(2022, time.May, 30),
+				date(2022, time.June, 20),
+				date(2022, time.July, 4),
+				date(2022, time.September, 5),
+				date(2022, time.November, 24),
+				date(2022, time.December, 26),
+			})
+		})
+
+		Convey("before MLK Day", func() {
+			So(len(Holidays(1997)), ShouldEqual, 8)
+			So(len(Holidays(1998)), ShouldEqual, 9)
+		})
+
+		Convey("Good Friday", func() {
+			So(easter(2019), ShouldResemble, date(2019, time.April, 21))
+			So(easter(2024), ShouldResemble, date(2024, time.March, 31))
+			So(IsHoliday(date(2024, time.March, 29)), ShouldBeTrue)
+		})
+
+		Convey("unscheduled closures", func() {
+			So(IsHoliday(date(2012, time.October, 29)), ShouldBeTrue)
+			So(IsTradingDay(date(2012, time.October, 29)), ShouldBeFalse)
+		})
+	})
+
+	Convey("IsTradingDay works", t, func() {
+		So(IsTradingDay(date(2022, time.December, 23)), ShouldBeTrue)
+		So(IsTradingDay(date(2022, time.December, 24)), ShouldBeFalse) // Saturday
+		So(IsTradingDay(date(2022, time.December, 26)), ShouldBeFalse) // Christmas
+		// Time of day and location are ignored.
+		ny, err := time.LoadLocation("America/New_York")
+		So(err, ShouldBeNil)
+		So(IsTradingDay(time.Date(2022, time.December, 26, 23, 0, 0, 0, ny)), ShouldBeFalse)
+	})
+
+	Convey("IsEarlyClose works", t, func() {
+		So(IsEarlyClose(date(2024, time.July, 3)), ShouldBeTrue)
+		So(IsEarlyClose(date(2024, time.November, 29)), ShouldBeTrue)
+		So(IsEarlyClose(date(2024, time.December, 24)), ShouldBeTrue)
+		So(IsEarlyClose(date(2024, time.December, 23)), ShouldBeFalse)
+		So(IsEarlyClose(date(2021, time.December, 24)), ShouldBeFalse) // holiday
+
+		So(CloseHour(date(2024, time.July, 3)), ShouldEqual, EarlyClose)
+		So(CloseHour(date(2024, time.July, 2)), ShouldEqual, RegularClose)
+		So(CloseHour(date(2024, time.July, 4)), ShouldEqual, 0)
+	})
+
+	Convey("NextTradingDay and PrevTradingDay work", t, func() {
+		So(NextTradingDay(date(2022, time.December, 23)), ShouldResemble,
+			date(2022, time.December, 27))
+		So(PrevTradingDay(date(2023, time.January, 3)), ShouldResemble,
+			date(2022, time.December, 30))
+	})
+
+	Convey("TradingDaysBetween works", t, func() {
+		So(TradingDaysBetween(date(2021, time.January, 1), date(2021, time.December, 31)),
+			ShouldEqual, 252)
+		So(TradingDaysBetween(date(2022, time.January, 1), date(2022, time.December, 31)),
+			ShouldEqual, 251)
+		So(TradingDaysBetween(date(2023, time.January, 1), date(2023, time.December, 31)),
+			ShouldEqual, 250)
+		So(TradingDaysBetween(date(2022, time.December, 23), date(2022, time.December, 27)),
+			ShouldEqual, 2)
+		So(TradingDaysBetween(date(2022, time.December, 27), date(2022, time.December, 23)),
+			ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,22 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package calendar implements the NYSE trading calendar: holidays and early
+// closes are generated by rules, so no network access or data files are
+// needed.
+//
+// The functions accept time.Time values and use only their calendar date,
+// ignoring the time of day and the location. The returned dates are at
+// midnight UTC, consistent with db.Date.ToTime().
+package calendar
@@ -0,0 +1,66 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+// ComputeAdjusted derives the split- and fully adjusted closing prices from the
+// unadjusted closes and the corporate actions of the ticker, both sorted by
+// date, and returns the updated copy of the prices. The adjusted prices are
+// equal to the unadjusted ones after the last action.
+//
+// An action on a date adjusts all the prices strictly before that date. A split
+// divides the earlier prices by its Value. A dividend or a spinoff multiplies
+// them by (1 - Value/close), where close is the last unadjusted closing price
+// before the action, adjusted for a split on the same date, if any, since the
+// Value is per share as of the action date. Other actions, and the actions
+// resulting in non-positive factors, are ignored.
+func ComputeAdjusted(prices []PriceRow, actions []ActionRow) []PriceRow {
+	res := make([]PriceRow, len(prices))
+	copy(res, prices)
+	splitFactor := 1.0
+	divFactor := 1.0
+	j := len(actions) // actions[j:] are already applied
+	for i := len(res) - 1; i >= 0; i-- {
+		date := res[i].Date.Date()
+		close := float64(res[i].CloseUnadjusted())
+		for j > 0 && date.Before(actions[j-1].Date) {
+			// Apply all the actions of the same date.
+			actionDate := actions[j-1].Date
+			k := j
+			split := 1.0
+			for k > 0 && actions[k-1].Date == actionDate {
+				k--
+				if a := actions[k]; a.Type == SplitAction && a.Value > 0.0 {
+					split *= float64(a.Value)
+				}
+			}
+			for _, a := range actions[k:j] {
+				if a.Type != DividendAction && a.Type != SpinoffAction {
+					continue
+				}
+				if close <= 0.0 {
+					continue
+				}
+				if f := 1.0 - float64(a.Value)*split/close; f > 0.0 {
+					divFactor *= f
+				}
+			}
+			splitFactor /= split
+			j = k
+		}
+		res[i].CloseSplitAdjusted = float32(close * splitFactor)
+		res[i].CloseFullyAdjusted = float32(close * splitFactor * divFactor)
+	}
+	return res
+}
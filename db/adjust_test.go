@@ -0,0 +1,80 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"testing"
+
+	"github.com/stockparfait/testutil"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdjust(t *testing.T) {
+	t.Parallel()
+
+	Convey("ComputeAdjusted works", t, func() {
+		adjusted := func(prices []PriceRow) (split, full []float64) {
+			for _, p := range prices {
+				split = append(split, testutil.Round(float64(p.CloseSplitAdjusted), 5))
+				full = append(full, testutil.Round(float64(p.CloseFullyAdjusted), 5))
+			}
+			return
+		}
+		raw := func(closes ...float32) []PriceRow {
+			var res []PriceRow
+			for i, c := range closes {
+				res = append(res, TestPrice(NewDate(2020, 1, uint8(i+1)), c, 0, 0, 1000.0, true))
+			}
+			return res
+		}
+
+		Convey("split and dividend on different dates", func() {
+			prices := raw(100, 100, 50, 49, 50)
+			prices[4].Close = -50 // delisted
+			actions := []ActionRow{
+				TestAction(NewDate(2020, 1, 3), SplitAction, 2.0, ""),
+				TestAction(NewDate(2020, 1, 4), DividendAction, 1.0, ""),
+				TestAction(NewDate(2020, 1, 5), DelistedAction, 0.0, ""),
+			}
+			res := ComputeAdjusted(prices, actions)
+			split, full := adjusted(res)
+			So(split, ShouldResemble, []float64{50, 50, 50, 49, 50})
+			So(full, ShouldResemble, []float64{49, 49, 49, 49, 50})
+			So(res[4].Close, ShouldEqual, -50)
+			So(prices[0].CloseSplitAdjusted, ShouldEqual, 0) // not modified
+		})
+
+		Convey("split and dividend on the same date", func() {
+			actions := []ActionRow{
+				TestAction(NewDate(2020, 1, 3), SplitAction, 2.0, ""),
+				TestAction(NewDate(2020, 1, 3), DividendAction, 1.0, ""),
+			}
+			split, full := adjusted(ComputeAdjusted(raw(100, 100, 49), actions))
+			So(split, ShouldResemble, []float64{50, 50, 49})
+			So(full, ShouldResemble, []float64{49, 49, 49})
+		})
+
+		Convey("actions outside of prices", func() {
+			actions := []ActionRow{
+				TestAction(NewDate(2019, 1, 1), SplitAction, 2.0, ""),
+				TestAction(NewDate(2020, 2, 1), SpinoffAction, 5.0, "X"),
+			}
+			split, full := adjusted(ComputeAdjusted(raw(100, 50), actions))
+			So(split, ShouldResemble, []float64{100, 50})
+			So(full, ShouldResemble, []float64{90, 45})
+		})
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"github.com/stockparfait/errors"
+)
+
+// The universe "as of" a date is reconstructed from the data available today:
+//
+//   - the listing status of a ticker on the date is determined by its latest
+//     listing, delisting or acquisition action on or before the date, or
+//     otherwise by its price history;
+//   - the other ticker attributes (exchange, sector, etc.) are taken from the
+//     ticker history table, which records the attributes replaced by updates.
+//
+// The ticker history only starts with the first update of the DB after its
+// creation, and it is not reconstructed from the actions: before the earliest
+// recorded change, the attributes are the ones of the oldest known row. In
+// particular, the exchange of a ticker which moved before that is not
+// recovered, and neither is the symbol of a renamed ticker, which is stored
+// under its current name; use Reader.Resolve to map old symbols to tickers.
+
+func (r *Reader) cacheTickerHistory() error {
+	r.historyOnce.Do(func() {
+		r.historyError = r.withLock(func() error {
+			fileName := tickerHistoryFile(r.cachePath())
+			if err := readGob(fileName, &r.history); err != nil {
+				return errors.Annotate(err, "failed to load %s", fileName)
+			}
+			return nil
+		})
+	})
+	return r.historyError
+}
+
+// HasTickerHistory checks if the DB exists and has the ticker history table.
+func (r *Reader) HasTickerHistory() bool {
+	return fileExists(tickerHistoryFile(r.cachePath()))
+}
+
+// AllTickerHistoryRows returns the entire ticker history table as a {ticker ->
+// rows} map, compatible with Writer.WriteTickerHistory() method. Note:
+// modifying the map will modify the Reader's cached copy.
+func (r *Reader) AllTickerHistoryRows() (map[string][]TickerHistoryRow, error) {
+	if err := r.cacheTickerHistory(); err != nil {
+		return nil, errors.Annotate(err, "failed to load ticker history")
+	}
+	return r.history, nil
+}
+
+// TickerRowAsOf returns the ticker's row with the attributes in effect on the
+// date, and Active set to whether the ticker was listed on that date. The
+// attributes which changed before the ticker history started are not known, and
+// the oldest known ones are returned.
+func (r *Reader) TickerRowAsOf(ticker string, date Date) (TickerRow, error) {
+	row, err := r.currentTickerRow(ticker)
+	if err != nil {
+		return TickerRow{}, err
+	}
+	return r.tickerRowAsOf(ticker, row, date)
+}
+
+func (r *Reader) tickerRowAsOf(ticker string, row TickerRow, date Date) (TickerRow, error) {
+	listed, err := r.listedOn(ticker, row.Active, date)
+	if err != nil {
+		return TickerRow{}, errors.Annotate(err, "failed to check listing of %s", ticker)
+	}
+	if r.HasTickerHistory() {
+		if err := r.cacheTickerHistory(); err != nil {
+			return TickerRow{}, errors.Annotate(err, "failed to load ticker history")
+		}
+		for _, h := range r.history[ticker] {
+			if date.Before(h.Until) {
+				row = h.Row
+				break
+			}
+		}
+	}
+	row.Active = listed
+	return row, nil
+}
+
+// priceRange returns the dates of the first and the last price of the ticker
+// from the monthly data when available, or from the prices otherwise. ok is
+// false if the ticker has no prices.
+func (r *Reader) priceRange(ticker string) (first, last Date, ok bool, err error) {
+	if r.HasMonthly() {
+		all, err := r.cacheResampled(Monthly)
+		if err != nil {
+			return Date{}, Date{}, false, errors.Annotate(err, "failed to load monthly data")
+		}
+		if rows := all[ticker]; len(rows) > 0 {
+			return rows[0].DateOpen, rows[len(rows)-1].DateClose, true, nil
+		}
+	}
+	if !r.HasPrices(ticker) {
+		return Date{}, Date{}, false, nil
+	}
+	prices, err := r.readPrices(ticker, Date{}, Date{})
+	if err != nil {
+		return Date{}, Date{}, false, errors.Annotate(err, "failed to read prices")
+	}
+	if len(prices) == 0 {
+		return Date{}, Date{}, false, nil
+	}
+	return prices[0].Date, prices[len(prices)-1].Date, true, nil
+}
+
+// listedOn checks if the ticker was listed on the date. A ticker which is
+// currently active is assumed to be still listed after its last price.
+func (r *Reader) listedOn(ticker string, active bool, date Date) (bool, error) {
+	first, last, hasPrices, err := r.priceRange(ticker)
+	if err != nil {
+		return false, err
+	}
+	trading := active || (hasPrices && !last.Before(date.Date()))
+	if r.HasActions() {
+		if err := r.cacheActions(); err != nil {
+			return false, errors.Annotate(err, "failed to load actions")
+		}
+		// The latest listing event on or before the date, and the earliest one
+		// after the date.
+		var event, next *ActionRow
+		for i, a := range r.actions[ticker] {
+			switch a.Type {
+			case ListedAction, DelistedAction, AcquiredAction:
+			default:
+				continue
+			}
+			if a.Date.After(date) {
+				if next == nil || a.Date.Before(next.Date) {
+					next = &r.actions[ticker][i]
+				}
+				continue
+			}
+			if event == nil || !a.Date.Before(event.Date) {
+				event = &r.actions[ticker][i]
+			}
+		}
+		switch {
+		case event != nil && event.Type == ListedAction:
+			return trading, nil
+		case event != nil:
+			return false, nil
+		case next != nil && next.Type == ListedAction:
+			return false, nil // not yet listed
+		}
+	}
+	return hasPrices && !first.After(date) && trading, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"os"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAsOf(t *testing.T) {
+	t.Parallel()
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testasof")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	Convey("UpdateTickerHistory works", t, func() {
+		history := map[string][]TickerHistoryRow{
+			"A": {{Until: NewDate(2018, 1, 1), Row: TickerRow{Exchange: "OTC"}}},
+		}
+		old := map[string]TickerRow{
+			"A": {Exchange: "NASDAQ", Active: true},
+			"B": {Exchange: "NYSE", Active: true},
+			"C": {Exchange: "NYSE", Active: true},
+		}
+		new := map[string]TickerRow{
+			"A": {Exchange: "NYSE", Active: true},
+			"B": {Exchange: "NYSE", Active: false}, // only Active changed
+			"D": {Exchange: "NYSE", Active: true},  // new ticker
+		}
+		date := NewDate(2019, 1, 1)
+		So(UpdateTickerHistory(history, old, new, date), ShouldEqual, 1)
+		So(history, ShouldResemble, map[string][]TickerHistoryRow{
+			"A": {
+				{Until: NewDate(2018, 1, 1), Row: TickerRow{Exchange: "OTC"}},
+				{Until: date, Row: old["A"]},
+			},
+		})
+	})
+
+	Convey("Reader as of a date works", t, func() {
+		ctx := context.Background()
+		dbName := "db"
+		tickers := map[string]TickerRow{
+			"A": {Exchange: "NYSE", Active: true},  // moved from NASDAQ
+			"B": {Exchange: "NYSE", Active: false}, // delisted, no actions
+			"C": {Exchange: "NYSE", Active: true},  // listed later
+			"D": {Exchange: "NYSE", Active: false}, // delisted and relisted
+		}
+		history := map[string][]TickerHistoryRow{
+			"A": {{Until: NewDate(2019, 2, 1), Row: TickerRow{Exchange: "NASDAQ"}}},
+		}
+		prices := func(start, end Date, active bool) []PriceRow {
+			return []PriceRow{
+				TestPrice(start, 10.0, 10.0, 10.0, 1000.0, true),
+				TestPrice(end, 10.0, 10.0, 10.0, 1000.0, active),
+			}
+		}
+		actions := map[string][]ActionRow{
+			"C": {TestAction(NewDate(2019, 2, 1), ListedAction, 0, "")},
+			"D": {
+				TestAction(NewDate(2019, 1, 20), DelistedAction, 0, ""),
+				TestAction(NewDate(2019, 2, 10), ListedAction, 0, ""),
+			},
+		}
+		w := NewWriter(tmpdir, dbName)
+		So(w.WriteTickers(tickers), ShouldBeNil)
+		So(w.WriteTickerHistory(history), ShouldBeNil)
+		So(w.WriteActions(actions), ShouldBeNil)
+		So(w.WritePrices("A", prices(NewDate(2019, 1, 2), NewDate(2019, 3, 1), true)), ShouldBeNil)
+		So(w.WritePrices("B", prices(NewDate(2019, 1, 2), NewDate(2019, 1, 31), false)), ShouldBeNil)
+		So(w.WritePrices("C", prices(NewDate(2019, 2, 1), NewDate(2019, 3, 1), true)), ShouldBeNil)
+		So(w.WritePrices("D", prices(NewDate(2019, 1, 2), NewDate(2019, 3, 1), false)), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
+
+		r := NewReader(tmpdir, dbName)
+		So(r.HasTickerHistory(), ShouldBeTrue)
+
+		universe := func(date Date, exchanges ...string) []string {
+			r.AsOf = date
+			r.Exchanges = exchanges
+			ts, err := r.Tickers(ctx)
+			So(err, ShouldBeNil)
+			sort.Strings(ts)
+			return ts
+		}
+
+		Convey("TickerRowAsOf", func() {
+			row, err := r.TickerRowAsOf("A", NewDate(2019, 1, 15))
+			So(err, ShouldBeNil)
+			So(row, ShouldResemble, TickerRow{Exchange: "NASDAQ", Active: true})
+			row, err = r.TickerRowAsOf("A", NewDate(2019, 2, 1))
+			So(err, ShouldBeNil)
+			So(row, ShouldResemble, TickerRow{Exchange: "NYSE", Active: true})
+			row, err = r.TickerRowAsOf("A", NewDate(2018, 12, 31))
+			So(err, ShouldBeNil)
+			So(row.Active, ShouldBeFalse) // before the first price
+		})
+
+		Convey("TickerRow honors AsOf", func() {
+			r.AsOf = NewDate(2019, 1, 15)
+			row, err := r.TickerRow("B")
+			So(err, ShouldBeNil)
+			So(row.Active, ShouldBeTrue)
+		})
+
+		Convey("Tickers", func() {
+			So(universe(NewDate(2019, 1, 15)), ShouldResemble, []string{"A", "B", "D"})
+			So(universe(NewDate(2019, 2, 1)), ShouldResemble, []string{"A", "C"})
+			So(universe(NewDate(2019, 2, 15)), ShouldResemble, []string{"A", "C", "D"})
+			So(universe(NewDate(2019, 1, 15), "NASDAQ"), ShouldResemble, []string{"A"})
+			So(universe(NewDate(2019, 2, 15), "NASDAQ"), ShouldResemble, []string{})
+			So(universe(Date{}), ShouldResemble, []string{"A", "B", "C", "D"})
+		})
+	})
+}
@@ -0,0 +1,363 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/stockparfait/errors"
+	"github.com/stockparfait/logging"
+)
+
+// IssueKind identifies the kind of a DB integrity issue.
+type IssueKind string
+
+const (
+	UnsortedPrices    IssueKind = "unsorted prices"
+	DuplicatePrice    IssueKind = "duplicate price"
+	InvalidPrice      IssueKind = "invalid price"
+	ActiveMismatch    IssueKind = "active mismatch"
+	ResampledMismatch IssueKind = "resampled mismatch"
+	MetadataMismatch  IssueKind = "metadata mismatch"
+	MissingPrices     IssueKind = "missing prices"
+)
+
+// Issue found in the DB by Check.
+type Issue struct {
+	Kind    IssueKind `json:"kind"`
+	Ticker  string    `json:"ticker,omitempty"`
+	Message string    `json:"message"`
+	Fixable bool      `json:"fixable"` // can be repaired automatically
+	Fixed   bool      `json:"fixed"`   // has been repaired
+}
+
+// CheckReport is the result of Check, suitable for printing as JSON.
+type CheckReport struct {
+	DB         string  `json:"db"`
+	NumTickers int     `json:"num_tickers"` // checked tickers
+	Issues     []Issue `json:"issues"`
+}
+
+// Unfixed returns the number of issues which have not been repaired.
+func (c *CheckReport) Unfixed() int {
+	n := 0
+	for _, i := range c.Issues {
+		if !i.Fixed {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *CheckReport) add(kind IssueKind, ticker string, fixable bool, format string, args ...any) {
+	c.Issues = append(c.Issues, Issue{
+		Kind:    kind,
+		Ticker:  ticker,
+		Message: fmt.Sprintf(format, args...),
+		Fixable: fixable,
+	})
+}
+
+// markFixed marks all the fixable issues starting from index i as fixed.
+func (c *CheckReport) markFixed(i int) {
+	for ; i < len(c.Issues); i++ {
+		if c.Issues[i].Fixable {
+			c.Issues[i].Fixed = true
+		}
+	}
+}
+
+func invalidFloat(x float32) bool {
+	return math.IsNaN(float64(x)) || math.IsInf(float64(x), 0)
+}
+
+// sameResampled compares the bars bit-wise, so that NaN values are considered
+// equal, and nil is the same as empty.
+func sameResampled(x, y []ResampledRow) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	same := func(a, b float32) bool {
+		return math.Float32bits(a) == math.Float32bits(b)
+	}
+	for i := range x {
+		a, b := x[i], y[i]
+		if !same(a.Open, b.Open) || !same(a.OpenSplitAdjusted, b.OpenSplitAdjusted) ||
+			!same(a.OpenFullyAdjusted, b.OpenFullyAdjusted) || !same(a.Close, b.Close) ||
+			!same(a.CloseSplitAdjusted, b.CloseSplitAdjusted) ||
+			!same(a.CloseFullyAdjusted, b.CloseFullyAdjusted) ||
+			!same(a.CashVolume, b.CashVolume) ||
+			!same(a.SumAbsLogProfits, b.SumAbsLogProfits) ||
+			a.DateOpen != b.DateOpen || a.DateClose != b.DateClose ||
+			a.NumSamples != b.NumSamples || a.Active != b.Active {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPrices verifies a single ticker's prices, adds the issues to the report,
+// and returns the repaired prices and whether any repair was needed.
+func checkPrices(c *CheckReport, ticker string, row TickerRow, prices []PriceRow) ([]PriceRow, bool) {
+	changed := false
+	for i := 1; i < len(prices); i++ {
+		if prices[i].Date.Before(prices[i-1].Date) {
+			c.add(UnsortedPrices, ticker, true, "price on %s follows %s",
+				prices[i].Date, prices[i-1].Date)
+			changed = true
+			break
+		}
+	}
+	fixed := make([]PriceRow, len(prices))
+	copy(fixed, prices)
+	sort.SliceStable(fixed, func(i, j int) bool {
+		return fixed[i].Date.Before(fixed[j].Date)
+	})
+	// Deduplicate, keeping the last of the equal dates.
+	dedup := []PriceRow{}
+	for i, p := range fixed {
+		if i+1 < len(fixed) && fixed[i+1].Date == p.Date {
+			c.add(DuplicatePrice, ticker, true, "duplicate price on %s", p.Date)
+			changed = true
+			continue
+		}
+		dedup = append(dedup, p)
+	}
+	valid := []PriceRow{}
+	for _, p := range dedup {
+		if invalidFloat(p.Open) || invalidFloat(p.High) || invalidFloat(p.Low) ||
+			invalidFloat(p.Close) || invalidFloat(p.CloseSplitAdjusted) ||
+			invalidFloat(p.CloseFullyAdjusted) || invalidFloat(p.CashVolume) ||
+			p.CloseSplitAdjusted < 0 || p.CloseFullyAdjusted < 0 {
+			c.add(InvalidPrice, ticker, true, "invalid price on %s: %+v", p.Date, p)
+			changed = true
+			continue
+		}
+		valid = append(valid, p)
+	}
+	if len(valid) > 0 && valid[len(valid)-1].Active() != row.Active {
+		last := &valid[len(valid)-1]
+		c.add(ActiveMismatch, ticker, true,
+			"last price on %s has active=%v, ticker has active=%v",
+			last.Date, last.Active(), row.Active)
+		last.SetActive(row.Active)
+		changed = true
+	}
+	return valid, changed
+}
+
+// Check verifies the integrity of the DB and returns the report of all the
+// found issues. Specifically, it verifies that:
+//
+//   - prices are sorted and unique by date;
+//   - prices have no NaN or infinite values, and adjusted closes are not
+//     negative;
+//   - the sign of the last Close is consistent with TickerRow.Active;
+//   - the existing resampled tables are consistent with ComputeResampled;
+//   - metadata date range and counts of tickers, prices, resampled bars,
+//     actions, fundamentals and FX rates match the actual data;
+//   - every ticker has prices.
+//
+// When repair is true, the fixable issues are repaired and committed as a new
+// snapshot: prices are sorted, deduplicated, invalid rows removed and the
+// active bit is set from TickerRow, after which the resampled tables and the
+// metadata are recomputed.
+// The tickers table must exist, or it is an error.
+func Check(ctx context.Context, dbPath, db string, repair bool) (*CheckReport, error) {
+	r := NewReader(dbPath, db)
+	w := NewWriter(dbPath, db)
+	if repair {
+		var err error
+		if r, err = w.Begin(); err != nil {
+			return nil, errors.Annotate(err, "failed to lock DB for repairs")
+		}
+		defer w.Rollback()
+	}
+	tickers, err := r.AllTickerRows()
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read tickers")
+	}
+	var meta Metadata
+	hasMeta := r.HasMetadata()
+	if hasMeta {
+		if meta, err = r.Metadata(); err != nil {
+			return nil, errors.Annotate(err, "failed to read metadata")
+		}
+	}
+	freqs := []Frequency{}
+	stored := make(map[Frequency]map[string][]ResampledRow)
+	for _, f := range Frequencies {
+		if !r.HasResampled(f) {
+			continue
+		}
+		rows, err := r.AllResampledRows(f)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read %s data", f)
+		}
+		freqs = append(freqs, f)
+		stored[f] = rows
+	}
+
+	names := make([]string, 0, len(tickers))
+	for t := range tickers {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+
+	c := &CheckReport{DB: db, NumTickers: len(names)}
+	expected := make(map[Frequency]map[string][]ResampledRow)
+	for _, f := range freqs {
+		expected[f] = make(map[string][]ResampledRow)
+	}
+	actual := Metadata{} // recomputed from the prices
+	for _, t := range names {
+		if !r.HasPrices(t) {
+			c.add(MissingPrices, t, false, "no prices for %s", t)
+			continue
+		}
+		prices, err := r.Prices(t)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read prices for %s", t)
+		}
+		i := len(c.Issues)
+		fixed, changed := checkPrices(c, t, tickers[t], prices)
+		if repair {
+			if changed {
+				if err := w.WritePrices(t, fixed); err != nil {
+					return nil, errors.Annotate(err, "failed to repair prices for %s", t)
+				}
+				logging.Infof(ctx, "repaired prices for %s", t)
+				c.markFixed(i)
+			}
+			prices = fixed
+		}
+		actual.UpdatePrices(prices)
+		for _, f := range freqs {
+			if len(prices) > 0 {
+				expected[f][t] = ComputeResampled(prices, f.Period)
+			}
+		}
+	}
+
+	for _, f := range freqs {
+		i := len(c.Issues)
+		for _, t := range names {
+			if !sameResampled(stored[f][t], expected[f][t]) {
+				c.add(ResampledMismatch, t, true, "%s data for %s differs from prices", f, t)
+			}
+		}
+		unknown := []string{}
+		for t := range stored[f] {
+			if _, ok := tickers[t]; !ok {
+				unknown = append(unknown, t)
+			}
+		}
+		sort.Strings(unknown)
+		for _, t := range unknown {
+			c.add(ResampledMismatch, t, true, "%s data for unknown ticker %s", f, t)
+		}
+		if repair && len(c.Issues) > i {
+			if err := w.WriteResampled(f, expected[f]); err != nil {
+				return nil, errors.Annotate(err, "failed to repair %s data", f)
+			}
+			logging.Infof(ctx, "repaired %s data", f)
+			c.markFixed(i)
+		}
+		actual.UpdateResampled(f, expected[f])
+	}
+
+	actual.UpdateTickers(tickers)
+	if r.HasActions() {
+		actions, err := r.AllActionRows()
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read actions")
+		}
+		actual.UpdateActions(actions)
+	}
+	for _, t := range names {
+		if !r.HasFundamentals(t) {
+			continue
+		}
+		rows, err := r.AllFundamentalsRows(t)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read fundamentals for %s", t)
+		}
+		actual.UpdateFundamentals(rows)
+	}
+	if r.HasFX() {
+		rates, err := r.AllFXRows()
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read FX rates")
+		}
+		actual.UpdateFX(rates)
+	}
+
+	i := len(c.Issues)
+	if !hasMeta {
+		c.add(MetadataMismatch, "", true, "metadata is missing")
+	} else {
+		for _, m := range []struct {
+			name         string
+			stored, real any
+		}{
+			{"start", meta.Start, actual.Start},
+			{"end", meta.End, actual.End},
+			{"num prices", meta.NumPrices, actual.NumPrices},
+			{"num weekly", meta.NumWeekly, actual.NumWeekly},
+			{"num monthly", meta.NumMonthly, actual.NumMonthly},
+			{"num quarterly", meta.NumQuarterly, actual.NumQuarterly},
+			{"num yearly", meta.NumYearly, actual.NumYearly},
+			{"intraday", meta.Intraday, actual.Intraday},
+			{"num tickers", meta.NumTickers, actual.NumTickers},
+			{"num actions", meta.NumActions, actual.NumActions},
+			{"num fundamentals", meta.NumFundamentals, actual.NumFundamentals},
+			{"num fx", meta.NumFX, actual.NumFX},
+		} {
+			if m.stored != m.real {
+				c.add(MetadataMismatch, "", true, "metadata %s is %v, actual is %v",
+					m.name, m.stored, m.real)
+			}
+		}
+	}
+	if repair && len(c.Issues) > i {
+		meta.Start = actual.Start
+		meta.End = actual.End
+		meta.NumPrices = actual.NumPrices
+		meta.NumWeekly = actual.NumWeekly
+		meta.NumMonthly = actual.NumMonthly
+		meta.NumQuarterly = actual.NumQuarterly
+		meta.NumYearly = actual.NumYearly
+		meta.Intraday = actual.Intraday
+		meta.NumTickers = actual.NumTickers
+		meta.NumActions = actual.NumActions
+		meta.NumFundamentals = actual.NumFundamentals
+		meta.NumFX = actual.NumFX
+		if !hasMeta {
+			meta.Format = r.format()
+		}
+		if err := w.WriteMetadata(meta); err != nil {
+			return nil, errors.Annotate(err, "failed to repair metadata")
+		}
+		logging.Infof(ctx, "repaired metadata")
+		c.markFixed(i)
+	}
+	if err := w.Commit(); err != nil {
+		return nil, errors.Annotate(err, "failed to commit repairs")
+	}
+	return c, nil
+}
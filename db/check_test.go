@@ -0,0 +1,171 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"math"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testcheck")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	Convey("Check works", t, func() {
+		ctx := context.Background()
+		tickers := map[string]TickerRow{
+			"A": {Active: true},
+			"B": {Active: false},
+			"C": {Active: true},
+		}
+		pricesA := []PriceRow{
+			TestPrice(NewDate(2019, 1, 1), 10.0, 10.0, 10.0, 1000.0, true),
+			TestPrice(NewDate(2019, 1, 2), 11.0, 11.0, 11.0, 1100.0, true),
+			TestPrice(NewDate(2019, 2, 1), 12.0, 12.0, 12.0, 1200.0, true),
+		}
+		pricesB := []PriceRow{
+			TestPrice(NewDate(2019, 1, 1), 10.0, 10.0, 10.0, 1000.0, true),
+			TestPrice(NewDate(2019, 1, 3), 11.0, 11.0, 11.0, 1100.0, false),
+		}
+
+		Convey("on a consistent DB", func() {
+			dbName := "good"
+			w := NewWriter(tmpdir, dbName)
+			So(w.WriteTickers(tickers), ShouldBeNil)
+			So(w.WritePrices("A", pricesA), ShouldBeNil)
+			So(w.WritePrices("B", pricesB), ShouldBeNil)
+			So(w.WriteMonthly(map[string][]ResampledRow{
+				"A": ComputeMonthly(pricesA),
+				"B": ComputeMonthly(pricesB),
+			}), ShouldBeNil)
+			So(w.WriteMetadata(w.Metadata), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+
+			c, err := Check(ctx, tmpdir, dbName, false)
+			So(err, ShouldBeNil)
+			So(c, ShouldResemble, &CheckReport{
+				DB:         dbName,
+				NumTickers: 3,
+				Issues: []Issue{{
+					Kind:    MissingPrices,
+					Ticker:  "C",
+					Message: "no prices for C",
+				}},
+			})
+			So(c.Unfixed(), ShouldEqual, 1)
+		})
+
+		Convey("on a broken DB", func() {
+			dbName := "bad"
+			badA := []PriceRow{
+				pricesA[1],
+				pricesA[0],
+				pricesA[2],
+				pricesA[2],
+				TestPrice(NewDate(2019, 2, 2), 13.0, float32(math.NaN()), 13.0, 1300.0, true),
+			}
+			w := NewWriter(tmpdir, dbName)
+			So(w.WriteTickers(tickers), ShouldBeNil)
+			So(w.WritePrices("A", badA), ShouldBeNil)
+			So(w.WritePrices("B", pricesB[:1]), ShouldBeNil)
+			So(w.WritePrices("C", pricesA), ShouldBeNil)
+			So(w.WriteMonthly(map[string][]ResampledRow{
+				"A": ComputeMonthly(pricesA),
+				"B": ComputeMonthly(pricesB[:1]),
+				"D": ComputeMonthly(pricesA),
+			}), ShouldBeNil)
+			So(w.WriteActions(map[string][]ActionRow{
+				"A": {TestAction(NewDate(2019, 1, 1), ListedAction, 0, "")},
+			}), ShouldBeNil)
+			So(w.WriteFundamentals("A", []FundamentalsRow{
+				TestFundamentals(ARQ, NewDate(2019, 2, 10), NewDate(2018, 12, 31), 100.0, 10.0),
+			}), ShouldBeNil)
+			So(w.WriteFX(map[string][]FXRow{
+				"EURUSD": {{Date: NewDate(2019, 1, 1), Rate: 1.1}},
+			}), ShouldBeNil)
+			w.Metadata.NumPrices++
+			w.Metadata.NumTickers = 0
+			w.Metadata.NumActions = 0
+			w.Metadata.NumFundamentals = 0
+			w.Metadata.NumFX = 0
+			So(w.WriteMetadata(w.Metadata), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+
+			c, err := Check(ctx, tmpdir, dbName, false)
+			So(err, ShouldBeNil)
+			kinds := []IssueKind{}
+			for _, i := range c.Issues {
+				So(i.Fixed, ShouldBeFalse)
+				kinds = append(kinds, i.Kind)
+			}
+			So(kinds, ShouldResemble, []IssueKind{
+				UnsortedPrices,
+				DuplicatePrice,
+				InvalidPrice,
+				ActiveMismatch,    // B
+				ResampledMismatch, // A
+				ResampledMismatch, // C
+				ResampledMismatch, // D
+				MetadataMismatch,  // num prices
+				MetadataMismatch,  // num tickers
+				MetadataMismatch,  // num actions
+				MetadataMismatch,  // num fundamentals
+				MetadataMismatch,  // num fx
+			})
+
+			c, err = Check(ctx, tmpdir, dbName, true)
+			So(err, ShouldBeNil)
+			So(c.Unfixed(), ShouldEqual, 0)
+
+			r := NewReader(tmpdir, dbName)
+			p, err := r.Prices("A")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, pricesA)
+			p, err = r.Prices("B")
+			So(err, ShouldBeNil)
+			So(p[0].Active(), ShouldBeFalse)
+			m, err := r.AllMonthlyRows()
+			So(err, ShouldBeNil)
+			So(len(m), ShouldEqual, 3)
+			So(m["B"][0].Active, ShouldBeFalse)
+			meta, err := r.Metadata()
+			So(err, ShouldBeNil)
+			So(meta.NumPrices, ShouldEqual, 7)
+			So(meta.End, ShouldResemble, NewDate(2019, 2, 1))
+			So(meta.NumTickers, ShouldEqual, 3)
+			So(meta.NumActions, ShouldEqual, 1)
+			So(meta.NumFundamentals, ShouldEqual, 1)
+			So(meta.NumFX, ShouldEqual, 1)
+
+			c, err = Check(ctx, tmpdir, dbName, false)
+			So(err, ShouldBeNil)
+			So(len(c.Issues), ShouldEqual, 0)
+		})
+
+		Convey("without tickers", func() {
+			_, err := Check(ctx, tmpdir, "missing", false)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
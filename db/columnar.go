@@ -0,0 +1,405 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/stockparfait/errors"
+)
+
+// Columnar files store a table of fixed-width values column by column, after a
+// 16-byte header: an 8-byte magic string identifying the table type, followed
+// by the number of rows as a little-endian uint64. Rows are sorted by the date
+// in the first column, which serves as the index for binary search. Dates are
+// stored as packed uint64 values preserving their order.
+const columnarHeaderSize = 16
+
+const (
+	pricesMagic    = "SPPRICE1"
+	resampledMagic = "SPRESMP1"
+)
+
+// Columns of the price table.
+const (
+	priceDateCol = iota
+	priceOpenCol
+	priceHighCol
+	priceLowCol
+	priceCloseCol
+	priceCloseSplitAdjustedCol
+	priceCloseFullyAdjustedCol
+	priceCashVolumeCol
+)
+
+var priceColumnWidths = []int{8, 4, 4, 4, 4, 4, 4, 4}
+
+// Columns of the resampled table.
+const (
+	resampledDateOpenCol = iota
+	resampledDateCloseCol
+	resampledOpenCol
+	resampledOpenSplitAdjustedCol
+	resampledOpenFullyAdjustedCol
+	resampledCloseCol
+	resampledCloseSplitAdjustedCol
+	resampledCloseFullyAdjustedCol
+	resampledCashVolumeCol
+	resampledSumAbsLogProfitsCol
+	resampledNumSamplesCol
+	resampledActiveCol
+)
+
+var resampledColumnWidths = []int{8, 8, 4, 4, 4, 4, 4, 4, 4, 4, 2, 1}
+
+// packDate into an integer preserving the order of dates.
+func packDate(d Date) uint64 {
+	return uint64(d.YearVal)<<48 | uint64(d.MonthVal)<<40 |
+		uint64(d.DayVal)<<32 | uint64(d.Time)
+}
+
+func unpackDate(x uint64) Date {
+	return Date{
+		YearVal:  uint16(x >> 48),
+		MonthVal: uint8(x >> 40),
+		DayVal:   uint8(x >> 32),
+		Time:     TimeOfDay(uint32(x)),
+	}
+}
+
+// columnarTable is a view of a columnar file's content.
+type columnarTable struct {
+	data    []byte
+	rows    int
+	widths  []int
+	offsets []int // the start of each column in data
+}
+
+func newColumnarTable(data []byte, widths []int, rows int) *columnarTable {
+	t := &columnarTable{data: data, rows: rows, widths: widths}
+	offset := columnarHeaderSize
+	for _, w := range widths {
+		t.offsets = append(t.offsets, offset)
+		offset += w * rows
+	}
+	return t
+}
+
+// allocColumnarTable creates a table with the header set, ready for writing
+// the values.
+func allocColumnarTable(magic string, widths []int, rows int) *columnarTable {
+	size := columnarHeaderSize
+	for _, w := range widths {
+		size += w * rows
+	}
+	data := make([]byte, size)
+	copy(data, magic)
+	binary.LittleEndian.PutUint64(data[8:], uint64(rows))
+	return newColumnarTable(data, widths, rows)
+}
+
+// parseColumnarTable validates the header and the size of the data.
+func parseColumnarTable(data []byte, magic string, widths []int) (*columnarTable, error) {
+	if len(data) < columnarHeaderSize {
+		return nil, errors.Reason("file is too short: %d bytes", len(data))
+	}
+	if string(data[:8]) != magic {
+		return nil, errors.Reason("unexpected magic %q, expected %q",
+			string(data[:8]), magic)
+	}
+	rows := int(binary.LittleEndian.Uint64(data[8:]))
+	rowSize := 0
+	for _, w := range widths {
+		rowSize += w
+	}
+	if expected := columnarHeaderSize + rowSize*rows; len(data) != expected {
+		return nil, errors.Reason("size mismatch for %d rows: expected %d, got %d",
+			rows, expected, len(data))
+	}
+	return newColumnarTable(data, widths, rows), nil
+}
+
+func (t *columnarTable) at(col, i int) []byte {
+	return t.data[t.offsets[col]+i*t.widths[col]:]
+}
+
+func (t *columnarTable) date(col, i int) Date {
+	return unpackDate(binary.LittleEndian.Uint64(t.at(col, i)))
+}
+
+func (t *columnarTable) float32(col, i int) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(t.at(col, i)))
+}
+
+func (t *columnarTable) uint16(col, i int) uint16 {
+	return binary.LittleEndian.Uint16(t.at(col, i))
+}
+
+func (t *columnarTable) bool(col, i int) bool {
+	return t.at(col, i)[0] != 0
+}
+
+func (t *columnarTable) putDate(col, i int, d Date) {
+	binary.LittleEndian.PutUint64(t.at(col, i), packDate(d))
+}
+
+func (t *columnarTable) putFloat32(col, i int, x float32) {
+	binary.LittleEndian.PutUint32(t.at(col, i), math.Float32bits(x))
+}
+
+func (t *columnarTable) putUint16(col, i int, x uint16) {
+	binary.LittleEndian.PutUint16(t.at(col, i), x)
+}
+
+func (t *columnarTable) putBool(col, i int, x bool) {
+	var b byte
+	if x {
+		b = 1
+	}
+	t.at(col, i)[0] = b
+}
+
+// search for the first row whose date in col is not before d.
+func (t *columnarTable) search(col int, d Date) int {
+	packed := packDate(d)
+	return sort.Search(t.rows, func(i int) bool {
+		return binary.LittleEndian.Uint64(t.at(col, i)) >= packed
+	})
+}
+
+// dateRange returns the [begin, end) range of rows whose date in col is within
+// the inclusive [start, end] date range. Zero bounds are ignored.
+func (t *columnarTable) dateRange(col int, start, end Date) (int, int) {
+	b := 0
+	if !start.IsZero() {
+		b = t.search(col, start)
+	}
+	e := t.rows
+	if !end.IsZero() {
+		e = b + sort.Search(t.rows-b, func(i int) bool {
+			return t.date(col, b+i).After(end)
+		})
+	}
+	return b, e
+}
+
+func (t *columnarTable) price(i int) PriceRow {
+	return PriceRow{
+		Date:               t.date(priceDateCol, i),
+		Open:               t.float32(priceOpenCol, i),
+		High:               t.float32(priceHighCol, i),
+		Low:                t.float32(priceLowCol, i),
+		Close:              t.float32(priceCloseCol, i),
+		CloseSplitAdjusted: t.float32(priceCloseSplitAdjustedCol, i),
+		CloseFullyAdjusted: t.float32(priceCloseFullyAdjustedCol, i),
+		CashVolume:         t.float32(priceCashVolumeCol, i),
+	}
+}
+
+func (t *columnarTable) putPrice(i int, p PriceRow) {
+	t.putDate(priceDateCol, i, p.Date)
+	t.putFloat32(priceOpenCol, i, p.Open)
+	t.putFloat32(priceHighCol, i, p.High)
+	t.putFloat32(priceLowCol, i, p.Low)
+	t.putFloat32(priceCloseCol, i, p.Close)
+	t.putFloat32(priceCloseSplitAdjustedCol, i, p.CloseSplitAdjusted)
+	t.putFloat32(priceCloseFullyAdjustedCol, i, p.CloseFullyAdjusted)
+	t.putFloat32(priceCashVolumeCol, i, p.CashVolume)
+}
+
+func (t *columnarTable) resampled(i int) ResampledRow {
+	return ResampledRow{
+		Open:               t.float32(resampledOpenCol, i),
+		OpenSplitAdjusted:  t.float32(resampledOpenSplitAdjustedCol, i),
+		OpenFullyAdjusted:  t.float32(resampledOpenFullyAdjustedCol, i),
+		Close:              t.float32(resampledCloseCol, i),
+		CloseSplitAdjusted: t.float32(resampledCloseSplitAdjustedCol, i),
+		CloseFullyAdjusted: t.float32(resampledCloseFullyAdjustedCol, i),
+		CashVolume:         t.float32(resampledCashVolumeCol, i),
+		DateOpen:           t.date(resampledDateOpenCol, i),
+		DateClose:          t.date(resampledDateCloseCol, i),
+		SumAbsLogProfits:   t.float32(resampledSumAbsLogProfitsCol, i),
+		NumSamples:         t.uint16(resampledNumSamplesCol, i),
+		Active:             t.bool(resampledActiveCol, i),
+	}
+}
+
+func (t *columnarTable) putResampled(i int, r ResampledRow) {
+	t.putFloat32(resampledOpenCol, i, r.Open)
+	t.putFloat32(resampledOpenSplitAdjustedCol, i, r.OpenSplitAdjusted)
+	t.putFloat32(resampledOpenFullyAdjustedCol, i, r.OpenFullyAdjusted)
+	t.putFloat32(resampledCloseCol, i, r.Close)
+	t.putFloat32(resampledCloseSplitAdjustedCol, i, r.CloseSplitAdjusted)
+	t.putFloat32(resampledCloseFullyAdjustedCol, i, r.CloseFullyAdjusted)
+	t.putFloat32(resampledCashVolumeCol, i, r.CashVolume)
+	t.putDate(resampledDateOpenCol, i, r.DateOpen)
+	t.putDate(resampledDateCloseCol, i, r.DateClose)
+	t.putFloat32(resampledSumAbsLogProfitsCol, i, r.SumAbsLogProfits)
+	t.putUint16(resampledNumSamplesCol, i, r.NumSamples)
+	t.putBool(resampledActiveCol, i, r.Active)
+}
+
+// writeColumnarPrices saves prices sorted by date in the columnar format.
+func writeColumnarPrices(fileName string, prices []PriceRow) error {
+	t := allocColumnarTable(pricesMagic, priceColumnWidths, len(prices))
+	for i, p := range prices {
+		t.putPrice(i, p)
+	}
+	err := writeFileAtomic(fileName, func(w io.Writer) error {
+		_, err := w.Write(t.data)
+		return err
+	})
+	if err != nil {
+		return errors.Annotate(err, "failed to write '%s'", fileName)
+	}
+	return nil
+}
+
+// writeColumnarResampled saves resampled rows sorted by date in the columnar
+// format.
+func writeColumnarResampled(fileName string, rows []ResampledRow) error {
+	t := allocColumnarTable(resampledMagic, resampledColumnWidths, len(rows))
+	for i, r := range rows {
+		t.putResampled(i, r)
+	}
+	err := writeFileAtomic(fileName, func(w io.Writer) error {
+		_, err := w.Write(t.data)
+		return err
+	})
+	if err != nil {
+		return errors.Annotate(err, "failed to write '%s'", fileName)
+	}
+	return nil
+}
+
+// readColumnarPrices memory-maps the price file and decodes only the rows
+// within the inclusive date range. Zero bounds are ignored.
+func readColumnarPrices(fileName string, start, end Date) (res []PriceRow, err error) {
+	data, unmap, err := mmapFile(fileName)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to map prices")
+	}
+	defer func() {
+		if e := unmap(); e != nil && err == nil {
+			err = errors.Annotate(e, "failed to unmap '%s'", fileName)
+		}
+	}()
+	t, err := parseColumnarTable(data, pricesMagic, priceColumnWidths)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid price file '%s'", fileName)
+	}
+	b, e := t.dateRange(priceDateCol, start, end)
+	res = make([]PriceRow, 0, e-b)
+	for i := b; i < e; i++ {
+		res = append(res, t.price(i))
+	}
+	return res, nil
+}
+
+// readColumnarPriceAt memory-maps the price file and decodes only the latest
+// price within the inclusive date range. Zero bounds are ignored.
+func readColumnarPriceAt(fileName string, start, end Date) (p PriceRow, ok bool, err error) {
+	data, unmap, err := mmapFile(fileName)
+	if err != nil {
+		return PriceRow{}, false, errors.Annotate(err, "failed to map prices")
+	}
+	defer func() {
+		if e := unmap(); e != nil && err == nil {
+			err = errors.Annotate(e, "failed to unmap '%s'", fileName)
+		}
+	}()
+	t, err := parseColumnarTable(data, pricesMagic, priceColumnWidths)
+	if err != nil {
+		return PriceRow{}, false, errors.Annotate(err, "invalid price file '%s'", fileName)
+	}
+	b, e := t.dateRange(priceDateCol, start, end)
+	if b >= e {
+		return PriceRow{}, false, nil
+	}
+	return t.price(e - 1), true, nil
+}
+
+// readColumnarResampled memory-maps the resampled file and decodes only the
+// bars opening and closing within the inclusive date range. Zero bounds are
+// ignored.
+func readColumnarResampled(fileName string, start, end Date) (res []ResampledRow, err error) {
+	data, unmap, err := mmapFile(fileName)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to map resampled data")
+	}
+	defer func() {
+		if e := unmap(); e != nil && err == nil {
+			err = errors.Annotate(e, "failed to unmap '%s'", fileName)
+		}
+	}()
+	t, err := parseColumnarTable(data, resampledMagic, resampledColumnWidths)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid resampled file '%s'", fileName)
+	}
+	b, e := t.dateRange(resampledDateOpenCol, start, end)
+	res = []ResampledRow{}
+	for i := b; i < e; i++ {
+		r := t.resampled(i)
+		if r.DateOpen.InRange(start, end) && r.DateClose.InRange(start, end) {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+// writeColumnarResampledDir replaces the content of dir with the per-ticker
+// resampled files.
+func writeColumnarResampledDir(dir string, rows map[string][]ResampledRow) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Annotate(err, "failed to remove '%s'", dir)
+	}
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return errors.Annotate(err, "failed to create '%s'", dir)
+	}
+	for ticker, rs := range rows {
+		fileName := filepath.Join(dir, ticker+fileExt(ColumnarFormat))
+		if err := writeColumnarResampled(fileName, rs); err != nil {
+			return errors.Annotate(err, "failed to write data for %s", ticker)
+		}
+	}
+	return nil
+}
+
+// readColumnarResampledDir reads all the per-ticker resampled files in dir.
+func readColumnarResampledDir(dir string) (map[string][]ResampledRow, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read '%s'", dir)
+	}
+	res := make(map[string][]ResampledRow)
+	for _, e := range entries {
+		name := e.Name()
+		ext := filepath.Ext(name)
+		if ext != fileExt(ColumnarFormat) {
+			continue
+		}
+		rows, err := readColumnarResampled(filepath.Join(dir, name), Date{}, Date{})
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read '%s'", name)
+		}
+		res[name[:len(name)-len(ext)]] = rows
+	}
+	return res, nil
+}
@@ -0,0 +1,231 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestColumnar(t *testing.T) {
+	t.Parallel()
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testcolumnar")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	Convey("packDate preserves order and value", t, func() {
+		d1 := NewDatetime(2019, 12, 31, 23, 59, 59, 999)
+		d2 := NewDate(2020, 1, 1)
+		d3 := NewDatetime(2020, 1, 1, 0, 0, 0, 1)
+		So(packDate(d1), ShouldBeLessThan, packDate(d2))
+		So(packDate(d2), ShouldBeLessThan, packDate(d3))
+		So(unpackDate(packDate(d1)), ShouldResemble, d1)
+		So(unpackDate(packDate(d3)), ShouldResemble, d3)
+	})
+
+	Convey("Prices round trip", t, func() {
+		fileName := filepath.Join(tmpdir, "prices.col")
+		prices := []PriceRow{
+			TestPrice(NewDate(2020, 1, 2), 10.0, 5.0, 4.0, 1000.0, true),
+			TestPrice(NewDate(2020, 1, 3), 11.0, 5.5, 4.5, 1100.0, true),
+			TestPrice(NewDatetime(2020, 1, 6, 10, 0, 0, 0), 12.0, 6.0, 5.0, 1200.0, true),
+			TestPrice(NewDate(2020, 1, 7), 13.0, 6.5, 5.5, 1300.0, false),
+		}
+		prices[0].Open = 9.0
+		prices[0].High = 10.5
+		prices[0].Low = 8.5
+		So(writeColumnarPrices(fileName, prices), ShouldBeNil)
+
+		res, err := readColumnarPrices(fileName, Date{}, Date{})
+		So(err, ShouldBeNil)
+		So(res, ShouldResemble, prices)
+
+		res, err = readColumnarPrices(fileName, NewDate(2020, 1, 3), NewDate(2020, 1, 6))
+		So(err, ShouldBeNil)
+		So(res, ShouldResemble, prices[1:2]) // 2020-01-06 10:00 is after the end
+
+		res, err = readColumnarPrices(fileName, NewDate(2020, 1, 4), Date{})
+		So(err, ShouldBeNil)
+		So(res, ShouldResemble, prices[2:])
+
+		res, err = readColumnarPrices(fileName, NewDate(2021, 1, 1), Date{})
+		So(err, ShouldBeNil)
+		So(len(res), ShouldEqual, 0)
+
+		So(writeColumnarPrices(fileName, nil), ShouldBeNil)
+		res, err = readColumnarPrices(fileName, Date{}, Date{})
+		So(err, ShouldBeNil)
+		So(len(res), ShouldEqual, 0)
+	})
+
+	Convey("Resampled round trip", t, func() {
+		fileName := filepath.Join(tmpdir, "resampled.col")
+		rows := []ResampledRow{
+			TestResampled(NewDate(2020, 1, 2), NewDate(2020, 1, 31), 10.0, 11.0, 12.0, 1000.0, true),
+			TestResampled(NewDate(2020, 2, 3), NewDate(2020, 2, 28), 12.0, 13.0, 14.0, 2000.0, false),
+			TestResampled(NewDate(2020, 3, 2), NewDate(2020, 3, 31), 14.0, 15.0, 16.0, 3000.0, true),
+		}
+		rows[0].SumAbsLogProfits = 0.25
+		rows[0].NumSamples = 20
+		So(writeColumnarResampled(fileName, rows), ShouldBeNil)
+
+		res, err := readColumnarResampled(fileName, Date{}, Date{})
+		So(err, ShouldBeNil)
+		So(res, ShouldResemble, rows)
+
+		res, err = readColumnarResampled(fileName, NewDate(2020, 1, 15), NewDate(2020, 3, 15))
+		So(err, ShouldBeNil)
+		So(res, ShouldResemble, rows[1:2])
+	})
+
+	Convey("invalid files are rejected", t, func() {
+		fileName := filepath.Join(tmpdir, "invalid.col")
+		So(writeColumnarPrices(fileName, []PriceRow{
+			TestPrice(NewDate(2020, 1, 2), 10.0, 5.0, 4.0, 1000.0, true),
+		}), ShouldBeNil)
+
+		_, err := readColumnarResampled(fileName, Date{}, Date{})
+		So(err, ShouldNotBeNil)
+
+		So(os.Truncate(fileName, 20), ShouldBeNil)
+		_, err = readColumnarPrices(fileName, Date{}, Date{})
+		So(err, ShouldNotBeNil)
+
+		So(os.Truncate(fileName, 4), ShouldBeNil)
+		_, err = readColumnarPrices(fileName, Date{}, Date{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("DB in columnar format", t, func() {
+		ctx := context.Background()
+		dbName := "columnar"
+		tickers := map[string]TickerRow{"A": {}, "B": {}}
+		pricesA := []PriceRow{
+			TestPrice(NewDate(2019, 1, 1), 10.0, 10.0, 10.0, 1000.0, true),
+			TestPrice(NewDate(2019, 1, 2), 11.0, 11.0, 11.0, 1100.0, true),
+			TestPrice(NewDate(2019, 2, 1), 12.0, 12.0, 12.0, 1200.0, true),
+		}
+		monthly := map[string][]ResampledRow{
+			"A": ComputeMonthly(pricesA),
+			"B": ComputeMonthly(pricesA[:1]),
+		}
+
+		w := NewWriter(tmpdir, dbName)
+		w.Metadata.Format = ColumnarFormat
+		So(w.WriteTickers(tickers), ShouldBeNil)
+		So(w.WritePrices("A", pricesA), ShouldBeNil)
+		So(w.WritePrices("B", pricesA[:1]), ShouldBeNil)
+		So(w.WriteMonthly(monthly), ShouldBeNil)
+		So(w.WriteMetadata(w.Metadata), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
+
+		Convey("reads data", func() {
+			r := NewReader(tmpdir, dbName)
+			So(r.HasPrices("A"), ShouldBeTrue)
+			So(r.HasPrices("C"), ShouldBeFalse)
+			So(r.HasMonthly(), ShouldBeTrue)
+			So(r.HasResampled(Weekly), ShouldBeFalse)
+
+			r.Start = NewDate(2019, 1, 2)
+			p, err := r.Prices("A")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, pricesA[1:])
+
+			m, err := r.Monthly("A", Date{}, Date{})
+			So(err, ShouldBeNil)
+			So(m, ShouldResemble, monthly["A"][1:])
+
+			pa, ok, err := r.PriceAt("A", NewDate(2019, 1, 31))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(pa, ShouldResemble, pricesA[1])
+
+			_, ok, err = r.PriceAt("A", NewDate(2019, 1, 1)) // before r.Start
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			p, err = r.PricesRange("A", Date{}, NewDate(2019, 1, 31))
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, pricesA[1:2])
+
+			_, err = r.Monthly("C", Date{}, Date{})
+			So(err, ShouldNotBeNil)
+
+			all, err := r.AllMonthlyRows()
+			So(err, ShouldBeNil)
+			So(all, ShouldResemble, monthly)
+		})
+
+		Convey("new Writer keeps the format", func() {
+			So(NewWriter(tmpdir, dbName).Metadata.Format, ShouldEqual, ColumnarFormat)
+		})
+
+		Convey("Cleanup removes stale columnar files", func() {
+			w := NewWriter(tmpdir, dbName)
+			So(w.WritePrices("C", pricesA), ShouldBeNil)
+			So(writeColumnarResampled(columnarResampledFile(
+				w.cachePath(), Monthly, "C"), monthly["A"]), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+			So(Cleanup(ctx, tmpdir, dbName), ShouldBeNil)
+			r := NewReader(tmpdir, dbName)
+			So(fileExists(pricesFile(r.cachePath(), "C", ColumnarFormat)), ShouldBeFalse)
+			So(fileExists(columnarResampledFile(r.cachePath(), Monthly, "C")), ShouldBeFalse)
+			So(fileExists(pricesFile(r.cachePath(), "A", ColumnarFormat)), ShouldBeTrue)
+			So(fileExists(columnarResampledFile(r.cachePath(), Monthly, "A")), ShouldBeTrue)
+		})
+
+		Convey("Convert works both ways", func() {
+			So(Convert(ctx, tmpdir, dbName, GobFormat), ShouldBeNil)
+			r := NewReader(tmpdir, dbName)
+			meta, err := r.Metadata()
+			So(err, ShouldBeNil)
+			So(meta.Format, ShouldEqual, GobFormat)
+			So(meta.NumPrices, ShouldEqual, 4)
+			So(fileExists(pricesFile(r.cachePath(), "A", GobFormat)), ShouldBeTrue)
+			So(fileExists(pricesFile(r.cachePath(), "A", ColumnarFormat)), ShouldBeFalse)
+			So(dirExists(resampledDir(r.cachePath(), Monthly)), ShouldBeFalse)
+			p, err := r.Prices("A")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, pricesA)
+			all, err := r.AllMonthlyRows()
+			So(err, ShouldBeNil)
+			So(all, ShouldResemble, monthly)
+
+			So(Convert(ctx, tmpdir, dbName, ColumnarFormat), ShouldBeNil)
+			r = NewReader(tmpdir, dbName)
+			meta, err = r.Metadata()
+			So(err, ShouldBeNil)
+			So(meta.Format, ShouldEqual, ColumnarFormat)
+			So(fileExists(pricesFile(r.cachePath(), "A", GobFormat)), ShouldBeFalse)
+			So(fileExists(resampledFile(r.cachePath(), Monthly)), ShouldBeFalse)
+			p, err = r.Prices("A")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, pricesA)
+			m, err := r.Monthly("B", Date{}, Date{})
+			So(err, ShouldBeNil)
+			So(m, ShouldResemble, monthly["B"])
+
+			// Converting to the same format is a no-op.
+			So(Convert(ctx, tmpdir, dbName, ColumnarFormat), ShouldBeNil)
+		})
+	})
+}
@@ -14,6 +14,16 @@
 
 package db
 
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/stockparfait/errors"
+	"github.com/stockparfait/stockparfait/message"
+)
+
 // Constraints to filter the tickers and their time series.  Zero value means no
 // constraints.
 type Constraints struct {
@@ -26,6 +36,7 @@ type Constraints struct {
 	Sectors        map[string]struct{}
 	Industries     map[string]struct{}
 	Active         *bool // optional constraint on whether ticker is active
+	Expr           *Expr // optional constraint expression
 }
 
 // NewConstraints creates a new Constraints with no constraints.
@@ -112,6 +123,20 @@ func (c *Constraints) SetActive(active bool) *Constraints {
 	return c
 }
 
+// Where adds a constraint expression, which must hold in addition to the other
+// constraints. A nil expression is a no-op.
+func (c *Constraints) Where(e *Expr) *Constraints {
+	if e == nil {
+		return c
+	}
+	if c.Expr == nil {
+		c.Expr = e
+		return c
+	}
+	c.Expr = &Expr{And: []Expr{*c.Expr, *e}}
+	return c
+}
+
 // CheckTicker whether it satisfies the constraints.
 func (c *Constraints) CheckTicker(ticker string) bool {
 	if len(c.ExcludeTickers) > 0 {
@@ -166,3 +191,166 @@ func (c *Constraints) CheckTickerRow(r TickerRow) bool {
 	}
 	return true
 }
+
+// Check whether the ticker and its row satisfy all the constraints, including
+// the constraint expression.
+func (c *Constraints) Check(ticker string, r TickerRow) bool {
+	if !c.CheckTicker(ticker) || !c.CheckTickerRow(r) {
+		return false
+	}
+	return c.Expr == nil || c.Expr.Check(ticker, r)
+}
+
+// Expr is a constraint expression tree on the tickers. A node holds if all of
+// its set fields hold, and an empty node always holds. For example, "Technology
+// or Communication Services, not on OTC, excluding the tickers in a file" is:
+//
+//	{
+//	  "or": [{"sectors": ["Technology"]}, {"sectors": ["Communication Services"]}],
+//	  "not": {"or": [{"exchanges": ["OTC"]}, {"ticker file": "blacklist.txt"}]}
+//	}
+type Expr struct {
+	And            []Expr              `json:"and"` // all of the expressions hold
+	Or             []Expr              `json:"or"`  // at least one expression holds
+	Not            *Expr               `json:"not"` // the expression does not hold
+	Tickers        []string            `json:"tickers"`
+	TickerFile     string              `json:"ticker file"` // one ticker per line, # comments
+	Sources        []string            `json:"sources"`
+	Exchanges      []string            `json:"exchanges"`
+	Categories     []string            `json:"categories"`
+	Sectors        []string            `json:"sectors"`
+	Industries     []string            `json:"industries"`
+	IndustryPrefix []string            `json:"industry prefix"` // industry starts with any
+	NameRegex      string              `json:"name regex"`      // RE2 syntax, partial match
+	Active         *bool               `json:"active"`
+	tickerSet      map[string]struct{} // Tickers and the TickerFile content
+	nameRegex      *regexp.Regexp
+}
+
+var _ message.Message = &Expr{}
+
+// InitMessage implements message.Message. It reads the ticker file and compiles
+// the name regex.
+func (e *Expr) InitMessage(js any) error {
+	if err := message.Init(e, js); err != nil {
+		return errors.Annotate(err, "failed to init Expr")
+	}
+	if e.NameRegex != "" {
+		var err error
+		if e.nameRegex, err = regexp.Compile(e.NameRegex); err != nil {
+			return errors.Annotate(err, "invalid name regex")
+		}
+	}
+	if len(e.Tickers) > 0 || e.TickerFile != "" {
+		e.tickerSet = make(map[string]struct{})
+		for _, t := range e.Tickers {
+			e.tickerSet[t] = struct{}{}
+		}
+	}
+	if e.TickerFile != "" {
+		tickers, err := readTickerFile(e.TickerFile)
+		if err != nil {
+			return errors.Annotate(err, "failed to read ticker file")
+		}
+		for _, t := range tickers {
+			e.tickerSet[t] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// readTickerFile reads the tickers one per line, ignoring the empty lines and
+// the lines starting with '#'.
+func readTickerFile(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to open '%s'", fileName)
+	}
+	defer f.Close()
+	var res []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		res = append(res, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Annotate(err, "failed to read '%s'", fileName)
+	}
+	return res, nil
+}
+
+func inList(s string, list []string) bool {
+	for _, x := range list {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether the ticker and its row satisfy the expression. The expression
+// must be initialized by InitMessage.
+func (e *Expr) Check(ticker string, r TickerRow) bool {
+	for i := range e.And {
+		if !e.And[i].Check(ticker, r) {
+			return false
+		}
+	}
+	if len(e.Or) > 0 {
+		found := false
+		for i := range e.Or {
+			if e.Or[i].Check(ticker, r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if e.Not != nil && e.Not.Check(ticker, r) {
+		return false
+	}
+	if e.tickerSet != nil {
+		if _, ok := e.tickerSet[ticker]; !ok {
+			return false
+		}
+	}
+	if len(e.Sources) > 0 && !inList(r.Source, e.Sources) {
+		return false
+	}
+	if len(e.Exchanges) > 0 && !inList(r.Exchange, e.Exchanges) {
+		return false
+	}
+	if len(e.Categories) > 0 && !inList(r.Category, e.Categories) {
+		return false
+	}
+	if len(e.Sectors) > 0 && !inList(r.Sector, e.Sectors) {
+		return false
+	}
+	if len(e.Industries) > 0 && !inList(r.Industry, e.Industries) {
+		return false
+	}
+	if len(e.IndustryPrefix) > 0 {
+		found := false
+		for _, p := range e.IndustryPrefix {
+			if strings.HasPrefix(r.Industry, p) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if e.nameRegex != nil && !e.nameRegex.MatchString(r.Name) {
+		return false
+	}
+	if e.Active != nil && *e.Active != r.Active {
+		return false
+	}
+	return true
+}
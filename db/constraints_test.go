@@ -15,8 +15,15 @@
 package db
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
+	"github.com/stockparfait/testutil"
+
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -84,4 +91,71 @@ func TestConstraints(t *testing.T) {
 			So(tc.CheckTickerRow(ticker), ShouldBeTrue)
 		})
 	})
+	Convey("Expr works", t, func() {
+		tmpdir, err := os.MkdirTemp("", "testexpr")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(tmpdir)
+
+		blacklist := filepath.Join(tmpdir, "blacklist.txt")
+		So(testutil.WriteFile(blacklist, "# bad ones\nBAD\n\n  WORSE  \n"), ShouldBeNil)
+
+		tickers := map[string]TickerRow{
+			"A":     {Exchange: "NYSE", Sector: "Technology", Industry: "Software - Application", Name: "Alpha Inc.", Active: true},
+			"B":     {Exchange: "NASDAQ", Sector: "Communication Services", Industry: "Telecom", Name: "Beta Corp", Active: true},
+			"C":     {Exchange: "OTC", Sector: "Technology", Industry: "Software - Infrastructure", Name: "Gamma Inc.", Active: true},
+			"D":     {Exchange: "NYSE", Sector: "Energy", Industry: "Oil & Gas", Name: "Delta Inc.", Active: false},
+			"BAD":   {Exchange: "NYSE", Sector: "Technology", Industry: "Hardware", Name: "Bad Inc.", Active: true},
+			"WORSE": {Exchange: "NYSE", Sector: "Technology", Industry: "Hardware", Name: "Worse Inc.", Active: true},
+		}
+		check := func(js string) []string {
+			var e Expr
+			So(e.InitMessage(testutil.JSON(js)), ShouldBeNil)
+			res := []string{}
+			for t, row := range tickers {
+				if e.Check(t, row) {
+					res = append(res, t)
+				}
+			}
+			sort.Strings(res)
+			return res
+		}
+
+		So(check(`{}`), ShouldResemble, []string{"A", "B", "BAD", "C", "D", "WORSE"})
+		So(check(fmt.Sprintf(`{
+  "or": [{"sectors": ["Technology"]}, {"sectors": ["Communication Services"]}],
+  "not": {"or": [{"exchanges": ["OTC"]}, {"ticker file": "%s"}]}
+}`, blacklist)), ShouldResemble, []string{"A", "B"})
+		So(check(`{"and": [{"industry prefix": ["Software"]}, {"not": {"tickers": ["A"]}}]}`),
+			ShouldResemble, []string{"C"})
+		So(check(`{"name regex": "^[A-D].* Inc\\.$"}`),
+			ShouldResemble, []string{"A", "BAD", "D"})
+		So(check(`{"active": false, "exchanges": ["NYSE", "OTC"]}`),
+			ShouldResemble, []string{"D"})
+		So(check(fmt.Sprintf(`{"ticker file": "%s", "tickers": ["A"]}`, blacklist)),
+			ShouldResemble, []string{"A", "BAD", "WORSE"})
+
+		var e Expr
+		So(e.InitMessage(testutil.JSON(`{"name regex": "("}`)), ShouldNotBeNil)
+		So(e.InitMessage(testutil.JSON(`{"ticker file": "/no/such/file"}`)), ShouldNotBeNil)
+		So(e.InitMessage(testutil.JSON(`{"nand": []}`)), ShouldNotBeNil)
+
+		Convey("in Reader", func() {
+			dbName := "db"
+			w := NewWriter(tmpdir, dbName)
+			So(w.WriteTickers(tickers), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+
+			var r Reader
+			So(r.InitMessage(testutil.JSON(fmt.Sprintf(`{
+  "DB path": "%s",
+  "DB": "%s",
+  "exchanges": ["NYSE", "NASDAQ"],
+  "filter": {"not": {"ticker file": "%s"}}
+}`, tmpdir, dbName, blacklist))), ShouldBeNil)
+			ts, err := r.Tickers(context.Background())
+			So(err, ShouldBeNil)
+			sort.Strings(ts)
+			So(ts, ShouldResemble, []string{"A", "B", "D"})
+		})
+	})
 }
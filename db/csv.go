@@ -29,12 +29,14 @@ import (
 type TickerRowConfig struct {
 	Ticker      string   `json:"Ticker" default:"Ticker"`
 	Source      string   `json:"Source" default:"Source"`
+	ID          string   `json:"ID" default:"ID"`
 	Exchange    string   `json:"Exchange" default:"Exchange"`
 	Name        string   `json:"Name" default:"Name"`
 	Category    string   `json:"Category" default:"Category"`
 	Sector      string   `json:"Sector" default:"Sector"`
 	Industry    string   `json:"Industry" default:"Industry"`
 	Location    string   `json:"Location" default:"Location"`
+	Currency    string   `json:"Currency" default:"Currency"`
 	SECFilings  string   `json:"SEC Filings" default:"SEC Filings"`
 	CompanySite string   `json:"Company Site" default:"Company Site"`
 	Active      string   `json:"Active" default:"Active"`
@@ -71,12 +73,14 @@ type tickerField int
 const (
 	tickerTicker tickerField = iota
 	tickerSource
+	tickerID
 	tickerExchange
 	tickerName
 	tickerCategory
 	tickerSector
 	tickerIndustry
 	tickerLocation
+	tickerCurrency
 	tickerSECFilings
 	tickerCompanySite
 	tickerActive
@@ -90,12 +94,14 @@ func (c *TickerRowConfig) MapColumns(header []string) []tickerField {
 	cols := make([]string, tickerLast)
 	cols[tickerTicker] = c.Ticker
 	cols[tickerSource] = c.Source
+	cols[tickerID] = c.ID
 	cols[tickerExchange] = c.Exchange
 	cols[tickerName] = c.Name
 	cols[tickerCategory] = c.Category
 	cols[tickerSector] = c.Sector
 	cols[tickerIndustry] = c.Industry
 	cols[tickerLocation] = c.Location
+	cols[tickerCurrency] = c.Currency
 	cols[tickerSECFilings] = c.SECFilings
 	cols[tickerCompanySite] = c.CompanySite
 	cols[tickerActive] = c.Active
@@ -131,6 +137,8 @@ func (c *TickerRowConfig) Parse(row []string, colMap []tickerField) (ticker stri
 			ticker = r
 		case tickerSource:
 			tr.Source = r
+		case tickerID:
+			tr.ID = r
 		case tickerExchange:
 			tr.Exchange = r
 		case tickerName:
@@ -143,6 +151,8 @@ func (c *TickerRowConfig) Parse(row []string, colMap []tickerField) (ticker stri
 			tr.Industry = r
 		case tickerLocation:
 			tr.Location = r
+		case tickerCurrency:
+			tr.Currency = r
 		case tickerSECFilings:
 			tr.SECFilings = r
 		case tickerCompanySite:
@@ -244,6 +254,7 @@ func (p *priceRaw) ToPriceRow() PriceRow {
 type PriceRowConfig struct {
 	// Unadjusted
 	Date   string `json:"Date" default:"Date"`
+	Time   string `json:"Time" default:"Time"` // optional time of day for intraday bars
 	Open   string `json:"Open" default:"Open"`
 	High   string `json:"High" default:"High"`
 	Low    string `json:"Low" default:"Low"`
@@ -312,6 +323,7 @@ const (
 	priceVolumeFullyAdjusted
 	priceCashVolume
 	priceActive
+	priceTime
 	priceLast // keep it last; not a real value.
 )
 
@@ -339,6 +351,7 @@ func (c *PriceRowConfig) MapColumns(header []string) [][]priceField {
 	cols[priceVolumeFullyAdjusted] = c.VolumeFullyAdjusted
 	cols[priceCashVolume] = c.CashVolume
 	cols[priceActive] = c.Active
+	cols[priceTime] = c.Time
 	for i, h := range header {
 		for j, n := range cols {
 			if h == n {
@@ -349,8 +362,27 @@ func (c *PriceRowConfig) MapColumns(header []string) [][]priceField {
 	return m
 }
 
+// HasAdjusted checks whether the header contains the split and the fully
+// adjusted closing price columns.
+func (c *PriceRowConfig) HasAdjusted(header []string) (split, fully bool) {
+	for _, fields := range c.MapColumns(header) {
+		for _, f := range fields {
+			switch f {
+			case priceCloseSplitAdjusted:
+				split = true
+			case priceCloseFullyAdjusted:
+				fully = true
+			}
+		}
+	}
+	return
+}
+
+// Parse a CSV row into a PriceRow. When a separate Time column is present and
+// not empty, it sets the time of day of the Date.
 func (c *PriceRowConfig) Parse(row []string, colMap [][]priceField) (pr PriceRow, err error) {
 	p := priceRaw{Active: true}
+	var tod *TimeOfDay
 	for i, r := range row {
 		if i >= len(colMap) {
 			break
@@ -478,9 +510,23 @@ func (c *PriceRowConfig) Parse(row []string, colMap [][]priceField) (pr PriceRow
 				p.CashVolume = float32(v)
 			case priceActive:
 				p.Active = str2bool(r)
+			case priceTime:
+				if r == "" { // the time may be a part of the Date
+					continue
+				}
+				var t TimeOfDay
+				t, err = NewTimeOfDayFromString(r)
+				if err != nil {
+					err = errors.Annotate(err, "failed to parse time: %s", r)
+					return
+				}
+				tod = &t
 			}
 		}
 	}
+	if tod != nil && !p.Date.IsZero() {
+		p.Date.Time = *tod
+	}
 	pr = p.ToPriceRow()
 	return
 }
@@ -555,3 +601,155 @@ func ReadCSVPrices(r io.Reader, c *PriceRowConfig) ([]PriceRow, error) {
 	sort.Slice(prices, func(i, j int) bool { return prices[i].Date.Before(prices[j].Date) })
 	return prices, nil
 }
+
+// FXRowConfig sets the custom headers of input CSV file for FX rate rows.
+type FXRowConfig struct {
+	Date   string   `json:"Date" default:"Date"`
+	Rate   string   `json:"Rate" default:"Rate"`
+	Header []string `json:"header"` // for headless CSV
+}
+
+var _ message.Message = &FXRowConfig{}
+
+// InitMessage implements message.Message.
+func (c *FXRowConfig) InitMessage(js any) error {
+	return errors.Annotate(message.Init(c, js), "failed to init from JSON")
+}
+
+func NewFXRowConfig() *FXRowConfig {
+	var c FXRowConfig
+	if err := c.InitMessage(map[string]any{}); err != nil {
+		panic(errors.Annotate(err, "failed to init default FXRowConfig"))
+	}
+	return &c
+}
+
+// ReadCSVFX reads raw CSV of a single currency pair's FX rates and returns the
+// rows sorted by date. The CSV must have the Date and the Rate columns, and the
+// other columns are ignored. When config defines a header, CSV is assumed to be
+// headless.
+func ReadCSVFX(r io.Reader, c *FXRowConfig) ([]FXRow, error) {
+	csvReader := csv.NewReader(r)
+	rows, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read FX rates from CSV")
+	}
+	header := c.Header
+	if len(header) == 0 {
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		header = rows[0]
+		rows = rows[1:]
+	}
+	dateCol, rateCol := -1, -1
+	for i, h := range header {
+		switch h {
+		case c.Date:
+			dateCol = i
+		case c.Rate:
+			rateCol = i
+		}
+	}
+	if dateCol < 0 || rateCol < 0 {
+		return nil, errors.Reason("FX CSV requires %s and %s columns", c.Date, c.Rate)
+	}
+	res := []FXRow{}
+	for i, row := range rows {
+		if dateCol >= len(row) || rateCol >= len(row) {
+			return nil, errors.Reason("row %d is too short", i)
+		}
+		var f FXRow
+		if f.Date, err = NewDateFromString(row[dateCol]); err != nil {
+			return nil, errors.Annotate(err, "failed to parse date in row %d", i)
+		}
+		v, err := strconv.ParseFloat(row[rateCol], 32)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to parse rate in row %d", i)
+		}
+		f.Rate = float32(v)
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Date.Before(res[j].Date) })
+	return res, nil
+}
+
+// UniverseRowConfig sets the custom headers of input CSV file for universe
+// membership rows.
+type UniverseRowConfig struct {
+	Date   string   `json:"Date" default:"Date"`
+	Ticker string   `json:"Ticker" default:"Ticker"`
+	Header []string `json:"header"` // for headless CSV
+}
+
+var _ message.Message = &UniverseRowConfig{}
+
+// InitMessage implements message.Message.
+func (c *UniverseRowConfig) InitMessage(js any) error {
+	return errors.Annotate(message.Init(c, js), "failed to init from JSON")
+}
+
+func NewUniverseRowConfig() *UniverseRowConfig {
+	var c UniverseRowConfig
+	if err := c.InitMessage(map[string]any{}); err != nil {
+		panic(errors.Annotate(err, "failed to init default UniverseRowConfig"))
+	}
+	return &c
+}
+
+// ReadCSVUniverse reads raw CSV of a universe membership, one ticker per row,
+// and returns the membership snapshots sorted by date. The CSV must have the
+// Ticker column, and the optional Date column is the date of the snapshot the
+// ticker belongs to; a missing or empty date means since the beginning. The
+// other columns are ignored. When config defines a header, CSV is assumed to be
+// headless.
+func ReadCSVUniverse(r io.Reader, c *UniverseRowConfig) ([]UniverseSnapshot, error) {
+	csvReader := csv.NewReader(r)
+	rows, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read universe from CSV")
+	}
+	header := c.Header
+	if len(header) == 0 {
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		header = rows[0]
+		rows = rows[1:]
+	}
+	dateCol, tickerCol := -1, -1
+	for i, h := range header {
+		switch h {
+		case c.Date:
+			dateCol = i
+		case c.Ticker:
+			tickerCol = i
+		}
+	}
+	if tickerCol < 0 {
+		return nil, errors.Reason("universe CSV requires %s column", c.Ticker)
+	}
+	var u Universe
+	snapshots := make(map[Date]*UniverseSnapshot)
+	for i, row := range rows {
+		if tickerCol >= len(row) || dateCol >= len(row) {
+			return nil, errors.Reason("row %d is too short", i)
+		}
+		var date Date
+		if dateCol >= 0 && row[dateCol] != "" {
+			if date, err = NewDateFromString(row[dateCol]); err != nil {
+				return nil, errors.Annotate(err, "failed to parse date in row %d", i)
+			}
+		}
+		s, ok := snapshots[date]
+		if !ok {
+			s = &UniverseSnapshot{Date: date}
+			snapshots[date] = s
+		}
+		s.Tickers = append(s.Tickers, row[tickerCol])
+	}
+	for _, s := range snapshots {
+		u.AddSnapshots(*s)
+	}
+	return u.Snapshots, nil
+}
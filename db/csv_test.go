@@ -30,32 +30,36 @@ func TestCSV(t *testing.T) {
 		Convey("with default schema", func() {
 			c := NewTickerRowConfig()
 			csvRows := strings.NewReader(strings.Join(TickerRowHeader(), ",") + `
-A,test,exch,A Co.,cat,sec,ind,loc,secf,www,FALSE
-B,test,exch2,B Co.,cat2,sec2,ind2,loc2,secf2,www2,TRUE
+A,test,exch,A Co.,cat,sec,ind,loc,secf,www,FALSE,USD,1
+B,test,exch2,B Co.,cat2,sec2,ind2,loc2,secf2,www2,TRUE,EUR,2
 `)
 			tickers := make(map[string]TickerRow)
 			So(ReadCSVTickers(csvRows, c, tickers), ShouldBeNil)
 			So(tickers, ShouldResemble, map[string]TickerRow{
 				"A": {
 					Source:      "test",
+					ID:          "1",
 					Exchange:    "exch",
 					Name:        "A Co.",
 					Category:    "cat",
 					Sector:      "sec",
 					Industry:    "ind",
 					Location:    "loc",
+					Currency:    "USD",
 					SECFilings:  "secf",
 					CompanySite: "www",
 					Active:      false,
 				},
 				"B": {
 					Source:      "test",
+					ID:          "2",
 					Exchange:    "exch2",
 					Name:        "B Co.",
 					Category:    "cat2",
 					Sector:      "sec2",
 					Industry:    "ind2",
 					Location:    "loc2",
+					Currency:    "EUR",
 					SECFilings:  "secf2",
 					CompanySite: "www2",
 					Active:      true,
@@ -97,6 +101,43 @@ TRUE,B Co.,blah,B,cat2,more blah
 		})
 	})
 
+	Convey("ReadCSVFX works", t, func() {
+		Convey("with default schema", func() {
+			csvRows := strings.NewReader(strings.Join(FXRowHeader(), ",") + `
+2020-01-02,1.5
+2020-01-01,1.25
+`)
+			rates, err := ReadCSVFX(csvRows, NewFXRowConfig())
+			So(err, ShouldBeNil)
+			So(rates, ShouldResemble, []FXRow{
+				{Date: NewDate(2020, 1, 1), Rate: 1.25},
+				{Date: NewDate(2020, 1, 2), Rate: 1.5},
+			})
+			So(rates[0].CSV(), ShouldResemble, []string{"2020-01-01", "1.25"})
+		})
+
+		Convey("headless with custom schema", func() {
+			var c FXRowConfig
+			So(c.InitMessage(testutil.JSON(`
+{
+  "Date": "time",
+  "Rate": "close",
+  "header": ["time", "open", "close"]
+}`)), ShouldBeNil)
+			csvRows := strings.NewReader(`
+2020-01-01,1.2,1.25
+`[1:])
+			rates, err := ReadCSVFX(csvRows, &c)
+			So(err, ShouldBeNil)
+			So(rates, ShouldResemble, []FXRow{{Date: NewDate(2020, 1, 1), Rate: 1.25}})
+		})
+
+		Convey("missing column", func() {
+			_, err := ReadCSVFX(strings.NewReader("Date,Close\n"), NewFXRowConfig())
+			So(err, ShouldNotBeNil)
+		})
+	})
+
 	Convey("ReadCSVPrices works", t, func() {
 		Convey("with default schema", func() {
 			c := NewPriceRowConfig()
@@ -195,5 +236,80 @@ TRUE,B Co.,blah,B,cat2,more blah
 				TestPrice(NewDate(2020, 1, 2), 11.2, 5.6, 5.6, 1000*11.2, true),
 			})
 		})
+
+		Convey("intraday bars", func() {
+			cfgJSON := testutil.JSON(`
+{
+  "Date":            "date",
+  "Time":            "time",
+  "Close":           "close",
+  "Close split adj": "close",
+  "Close fully adj": "close",
+  "Open":            "close",
+  "High":            "close",
+  "Low":             "close",
+  "Cash Volume":     "volume"
+}`)
+			var c PriceRowConfig
+			So(c.InitMessage(cfgJSON), ShouldBeNil)
+			csvRows := strings.NewReader(`
+date,time,close,volume
+2020-01-02,09:30:00,11,1100
+2020-01-02,09:31:00,12,1200
+2020-01-03T09:30:00-05:00,,13,1300
+`[1:])
+			prices, err := ReadCSVPrices(csvRows, &c)
+			So(err, ShouldBeNil)
+			So(prices, ShouldResemble, []PriceRow{
+				TestPrice(NewDatetime(2020, 1, 2, 9, 30, 0, 0), 11, 11, 11, 1100, true),
+				TestPrice(NewDatetime(2020, 1, 2, 9, 31, 0, 0), 12, 12, 12, 1200, true),
+				TestPrice(NewDatetime(2020, 1, 3, 9, 30, 0, 0), 13, 13, 13, 1300, true),
+			})
+		})
+
+		Convey("HasAdjusted", func() {
+			c := NewPriceRowConfig()
+			split, fully := c.HasAdjusted([]string{"Date", "Close", "Close split adj"})
+			So(split, ShouldBeTrue)
+			So(fully, ShouldBeFalse)
+
+			c.CloseSplitAdjusted = "close"
+			c.CloseFullyAdjusted = "close"
+			split, fully = c.HasAdjusted([]string{"Date", "close"})
+			So(split, ShouldBeTrue)
+			So(fully, ShouldBeTrue)
+		})
+	})
+
+	Convey("ReadCSVUniverse works", t, func() {
+		Convey("with dates", func() {
+			csvRows := strings.NewReader(`Ticker,Date,Weight
+B,2021-01-01,1
+A,,1
+C,2021-01-01,1
+B,,1
+`)
+			snapshots, err := ReadCSVUniverse(csvRows, NewUniverseRowConfig())
+			So(err, ShouldBeNil)
+			So(snapshots, ShouldResemble, []UniverseSnapshot{
+				{Tickers: []string{"A", "B"}},
+				{Date: NewDate(2021, 1, 1), Tickers: []string{"B", "C"}},
+			})
+		})
+
+		Convey("headless without dates", func() {
+			var c UniverseRowConfig
+			So(c.InitMessage(testutil.JSON(`{"Ticker": "sym", "header": ["sym"]}`)),
+				ShouldBeNil)
+			snapshots, err := ReadCSVUniverse(strings.NewReader("A\nB\n"), &c)
+			So(err, ShouldBeNil)
+			So(snapshots, ShouldResemble, []UniverseSnapshot{{Tickers: []string{"A", "B"}}})
+		})
+
+		Convey("without tickers", func() {
+			_, err := ReadCSVUniverse(strings.NewReader("Date\n2020-01-01\n"),
+				NewUniverseRowConfig())
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
@@ -18,24 +18,25 @@ import (
 	"context"
 	"encoding/gob"
 	"encoding/json"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/stockparfait/errors"
 	"github.com/stockparfait/logging"
+	"github.com/stockparfait/stockparfait/calendar"
 	"github.com/stockparfait/stockparfait/message"
 )
 
 func writeGob(fileName string, v any) error {
-	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	err := writeFileAtomic(fileName, func(w io.Writer) error {
+		return gob.NewEncoder(w).Encode(v)
+	})
 	if err != nil {
-		return errors.Annotate(err, "failed to open file for writing: '%s'", fileName)
-	}
-	defer f.Close()
-	enc := gob.NewEncoder(f)
-	if err = enc.Encode(v); err != nil {
 		return errors.Annotate(err, "failed to write to '%s'", fileName)
 	}
 	return nil
@@ -55,12 +56,14 @@ func readGob(fileName string, v any) error {
 }
 
 // Interval configures a set of constraints for a value over an optional time
-// range. All interval ranges are inclusive, both value and time.
+// range. All interval ranges are inclusive, both value and time. The value is
+// computed from the resampled bars of the given frequency (default: monthly).
 type Interval struct {
-	Min   *float64 `json:"min"`
-	Max   *float64 `json:"max"`
-	Start Date     `json:"start"`
-	End   Date     `json:"end"`
+	Min       *float64  `json:"min"`
+	Max       *float64  `json:"max"`
+	Start     Date      `json:"start"`
+	End       Date      `json:"end"`
+	Frequency Frequency `json:"frequency"`
 }
 
 var _ message.Message = &Interval{}
@@ -118,33 +121,73 @@ type Reader struct {
 	Categories     []string       `json:"categories"`
 	Sectors        []string       `json:"sectors"`
 	Industries     []string       `json:"industries"`
+	Filter         *Expr          `json:"filter"`   // in addition to the above
+	Universe       string         `json:"universe"` // named universe in the DB
 	Start          Date           `json:"start"`
 	End            Date           `json:"end"`
+	AsOf           Date           `json:"as of"` // the universe as of the date
 	Active         *bool          `json:"active"`
 	YearlyGrowth   *Interval      `json:"yearly growth"`
 	CashVolume     *Interval      `json:"cash volume"`
 	Volatility     *Interval      `json:"volatility"`
+	MinPrice       *Interval      `json:"min price"`
+	MaxDrawdown    *Interval      `json:"max drawdown"`
+	TradingDays    *Interval      `json:"trading days"`
+	ZeroVolume     *Interval      `json:"zero volume"` // % of days; no frequency
+	Correlation    *Interval      `json:"correlation"` // to Benchmark
+	Benchmark      string         `json:"benchmark"`
 	Intraday       *IntradayRange `json:"intraday"`
+	Currency       string         `json:"currency"`                  // default: native
+	Snapshot       string         `json:"snapshot"`                  // default: current
+	LockTimeout    float64        `json:"lock timeout" default:"10"` // seconds
 	constraints    *Constraints
 	tickers        map[string]TickerRow
-	monthly        map[string][]ResampledRow
+	resampled      [numFrequencies]resampledCache
+	actions        map[string][]ActionRow
 	metadata       Metadata
 	tickersOnce    sync.Once
 	tickersError   error
-	monthlyOnce    sync.Once
-	monthlyError   error
+	actionsOnce    sync.Once
+	actionsError   error
+	fx             map[string][]FXRow
+	fxOnce         sync.Once
+	fxError        error
+	history        map[string][]TickerHistoryRow
+	historyOnce    sync.Once
+	historyError   error
+	symbols        map[string][]SymbolRow
+	ids            map[string]string // permanent ID -> current ticker
+	symbolsOnce    sync.Once
+	symbolsError   error
+	universes      map[string]Universe
+	universesOnce  sync.Once
+	universesError error
+	universeExprs  map[universeKey]*Expr
+	universeExprMu sync.Mutex
+	fundamentals   map[string]*fundamentalsCache
+	fundamentalsMu sync.Mutex
 	metadataOnce   sync.Once
 	metadataError  error
+	snapshotOnce   sync.Once
+	snapshotPath   string
+	snapshotName   string
 }
 
 var _ message.Message = &Reader{}
 
 func NewReader(dbPath, db string) *Reader {
 	return &Reader{
-		DBPath:  dbPath,
-		DB:      db,
-		tickers: make(map[string]TickerRow),
-		monthly: make(map[string][]ResampledRow),
+		DBPath:        dbPath,
+		DB:            db,
+		LockTimeout:   DefaultLockTimeout.Seconds(),
+		tickers:       make(map[string]TickerRow),
+		actions:       make(map[string][]ActionRow),
+		fx:            make(map[string][]FXRow),
+		history:       make(map[string][]TickerHistoryRow),
+		symbols:       make(map[string][]SymbolRow),
+		universes:     make(map[string]Universe),
+		universeExprs: make(map[universeKey]*Expr),
+		fundamentals:  make(map[string]*fundamentalsCache),
 	}
 }
 
@@ -157,7 +200,8 @@ func (r *Reader) initConstraints() {
 		Name(r.Names...).
 		Category(r.Categories...).
 		Sector(r.Sectors...).
-		Industry(r.Industries...)
+		Industry(r.Industries...).
+		Where(r.Filter)
 	if r.Active != nil {
 		r.constraints.SetActive(*r.Active)
 	}
@@ -171,8 +215,21 @@ func (r *Reader) InitMessage(js any) error {
 	if r.DBPath == "" {
 		r.DBPath = filepath.Join(os.Getenv("HOME"), ".stockparfait")
 	}
+	if r.Correlation != nil && r.Benchmark == "" {
+		return errors.Reason("correlation requires a benchmark")
+	}
+	if r.ZeroVolume != nil && r.ZeroVolume.Frequency != Monthly {
+		return errors.Reason("zero volume does not support frequency %s",
+			r.ZeroVolume.Frequency)
+	}
 	r.tickers = make(map[string]TickerRow)
-	r.monthly = make(map[string][]ResampledRow)
+	r.actions = make(map[string][]ActionRow)
+	r.fx = make(map[string][]FXRow)
+	r.history = make(map[string][]TickerHistoryRow)
+	r.symbols = make(map[string][]SymbolRow)
+	r.universes = make(map[string]Universe)
+	r.universeExprs = make(map[universeKey]*Expr)
+	r.fundamentals = make(map[string]*fundamentalsCache)
 	return nil
 }
 
@@ -180,62 +237,237 @@ func tickersFile(cachePath string) string {
 	return filepath.Join(cachePath, "tickers.gob")
 }
 
+func tickerHistoryFile(cachePath string) string {
+	return filepath.Join(cachePath, "ticker-history.gob")
+}
+
 func pricesDir(cachePath string) string {
 	return filepath.Join(cachePath, "prices")
 }
 
-func pricesFile(cachePath, ticker string) string {
-	return filepath.Join(pricesDir(cachePath), ticker+".gob")
+// fileExt for the per-ticker files of the storage format.
+func fileExt(format StorageFormat) string {
+	if format == ColumnarFormat {
+		return ".col"
+	}
+	return ".gob"
+}
+
+func pricesFile(cachePath, ticker string, format StorageFormat) string {
+	return filepath.Join(pricesDir(cachePath), ticker+fileExt(format))
+}
+
+func fundamentalsDir(cachePath string) string {
+	return filepath.Join(cachePath, "fundamentals")
+}
+
+func fundamentalsFile(cachePath, ticker string) string {
+	return filepath.Join(fundamentalsDir(cachePath), ticker+".gob")
+}
+
+func resampledFile(cachePath string, freq Frequency) string {
+	return filepath.Join(cachePath, freq.String()+".gob")
+}
+
+// resampledDir stores per-ticker resampled files in the columnar format.
+func resampledDir(cachePath string, freq Frequency) string {
+	return filepath.Join(cachePath, freq.String())
+}
+
+func columnarResampledFile(cachePath string, freq Frequency, ticker string) string {
+	return filepath.Join(resampledDir(cachePath, freq), ticker+fileExt(ColumnarFormat))
+}
+
+func symbolsFile(cachePath string) string {
+	return filepath.Join(cachePath, "symbols.gob")
+}
+
+func universesFile(cachePath string) string {
+	return filepath.Join(cachePath, "universes.gob")
+}
+
+func actionsFile(cachePath string) string {
+	return filepath.Join(cachePath, "actions.gob")
 }
 
-func monthlyFile(cachePath string) string {
-	return filepath.Join(cachePath, "monthly.gob")
+func fxFile(cachePath string) string {
+	return filepath.Join(cachePath, "fx.gob")
 }
 
 func metadataFile(cachePath string) string {
 	return filepath.Join(cachePath, "metadata.json")
 }
 
+// cachePath of the snapshot being read. The current snapshot is resolved once,
+// so that all the data is read from the same snapshot even if a newer one is
+// committed concurrently.
 func (r *Reader) cachePath() string {
-	return filepath.Join(r.DBPath, r.DB)
+	r.snapshotOnce.Do(func() {
+		root := filepath.Join(r.DBPath, r.DB)
+		r.snapshotName = r.Snapshot
+		if r.snapshotName == "" {
+			// An error is treated as no snapshots, the reads will fail anyway.
+			r.snapshotName, _ = currentSnapshot(root)
+		}
+		r.snapshotPath = root
+		if r.snapshotName != "" {
+			r.snapshotPath = snapshotDir(root, r.snapshotName)
+		}
+	})
+	return r.snapshotPath
+}
+
+// SnapshotName returns the name of the snapshot being read: Reader.Snapshot if
+// set, or the current snapshot at the time of the first read. It is "" for a
+// DB without snapshots. Record it to read the same data later.
+func (r *Reader) SnapshotName() string {
+	r.cachePath()
+	return r.snapshotName
+}
+
+// withLock runs f while holding the shared DB lock, so the snapshot being read
+// is not deleted by a concurrent Writer.Commit. A missing DB is not locked.
+func (r *Reader) withLock(f func() error) error {
+	root := filepath.Join(r.DBPath, r.DB)
+	if !dirExists(root) {
+		return f()
+	}
+	timeout := time.Duration(r.LockTimeout * float64(time.Second))
+	l, err := lockFile(readLockFile(root), false, timeout)
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB %s for reading", r.DB)
+	}
+	defer l.Unlock()
+	return f()
+}
+
+// format of the price and resampled tables as recorded in the metadata. A DB
+// without metadata is assumed to be in the default gob format.
+func (r *Reader) format() StorageFormat {
+	if !r.HasMetadata() {
+		return GobFormat
+	}
+	if err := r.cacheMetadata(); err != nil || r.metadata.Format != ColumnarFormat {
+		return GobFormat
+	}
+	return ColumnarFormat
 }
 
 func (r *Reader) cacheMetadata() error {
 	r.metadataOnce.Do(func() {
-		fileName := metadataFile(r.cachePath())
-		f, err := os.Open(fileName)
-		if err != nil {
-			r.metadataError = errors.Annotate(err,
-				"failed to open file for reading: '%s'", fileName)
-		}
-		defer f.Close()
+		r.metadataError = r.withLock(func() error {
+			fileName := metadataFile(r.cachePath())
+			f, err := os.Open(fileName)
+			if err != nil {
+				return errors.Annotate(err,
+					"failed to open file for reading: '%s'", fileName)
+			}
+			defer f.Close()
 
-		dec := json.NewDecoder(f)
-		if err := dec.Decode(&r.metadata); err != nil {
-			r.metadataError = errors.Annotate(err, "failed to decode JSON")
-		}
+			dec := json.NewDecoder(f)
+			if err := dec.Decode(&r.metadata); err != nil {
+				return errors.Annotate(err, "failed to decode JSON")
+			}
+			return nil
+		})
 	})
 	return r.metadataError
 }
 
 func (r *Reader) cacheTickers() error {
 	r.tickersOnce.Do(func() {
-		if err := readGob(tickersFile(r.cachePath()), &r.tickers); err != nil {
-			r.tickersError = errors.Annotate(
-				err, "failed to load %s", tickersFile(r.cachePath()))
-		}
+		r.tickersError = r.withLock(func() error {
+			if err := readGob(tickersFile(r.cachePath()), &r.tickers); err != nil {
+				return errors.Annotate(
+					err, "failed to load %s", tickersFile(r.cachePath()))
+			}
+			return nil
+		})
 	})
 	return r.tickersError
 }
 
-func (r *Reader) cacheMonthly() error {
-	r.monthlyOnce.Do(func() {
-		if err := readGob(monthlyFile(r.cachePath()), &r.monthly); err != nil {
-			r.monthlyError = errors.Annotate(
-				err, "failed to load %s", monthlyFile(r.cachePath()))
-		}
+// resampledCache is the lazily loaded resampled table of a single frequency.
+type resampledCache struct {
+	rows map[string][]ResampledRow
+	once sync.Once
+	err  error
+}
+
+func (r *Reader) cacheResampled(freq Frequency) (map[string][]ResampledRow, error) {
+	c := &r.resampled[freq]
+	c.once.Do(func() {
+		c.err = r.withLock(func() (err error) {
+			if r.format() == ColumnarFormat {
+				dir := resampledDir(r.cachePath(), freq)
+				if c.rows, err = readColumnarResampledDir(dir); err != nil {
+					return errors.Annotate(err, "failed to load %s", dir)
+				}
+				return nil
+			}
+			fileName := resampledFile(r.cachePath(), freq)
+			if err := readGob(fileName, &c.rows); err != nil {
+				return errors.Annotate(err, "failed to load %s", fileName)
+			}
+			return nil
+		})
+	})
+	return c.rows, c.err
+}
+
+func (r *Reader) cacheActions() error {
+	r.actionsOnce.Do(func() {
+		r.actionsError = r.withLock(func() error {
+			if err := readGob(actionsFile(r.cachePath()), &r.actions); err != nil {
+				return errors.Annotate(
+					err, "failed to load %s", actionsFile(r.cachePath()))
+			}
+			return nil
+		})
+	})
+	return r.actionsError
+}
+
+func (r *Reader) cacheFX() error {
+	r.fxOnce.Do(func() {
+		r.fxError = r.withLock(func() error {
+			if err := readGob(fxFile(r.cachePath()), &r.fx); err != nil {
+				return errors.Annotate(err, "failed to load %s", fxFile(r.cachePath()))
+			}
+			return nil
+		})
+	})
+	return r.fxError
+}
+
+// fundamentalsCache is the lazily loaded fundamentals of a single ticker.
+type fundamentalsCache struct {
+	rows []FundamentalsRow
+	once sync.Once
+	err  error
+}
+
+// cacheFundamentals loads the ticker's fundamentals upon the first call, and
+// returns the cached copy thereafter. Go routine safe.
+func (r *Reader) cacheFundamentals(ticker string) ([]FundamentalsRow, error) {
+	r.fundamentalsMu.Lock()
+	c, ok := r.fundamentals[ticker]
+	if !ok {
+		c = &fundamentalsCache{}
+		r.fundamentals[ticker] = c
+	}
+	r.fundamentalsMu.Unlock()
+
+	c.once.Do(func() {
+		c.err = r.withLock(func() error {
+			fileName := fundamentalsFile(r.cachePath(), ticker)
+			if err := readGob(fileName, &c.rows); err != nil {
+				return errors.Annotate(err, "failed to load %s", fileName)
+			}
+			return nil
+		})
 	})
-	return r.monthlyError
+	return c.rows, c.err
 }
 
 func fileExists(fileName string) bool {
@@ -246,6 +478,14 @@ func fileExists(fileName string) bool {
 	return !info.IsDir()
 }
 
+func dirExists(dirName string) bool {
+	info, err := os.Stat(dirName)
+	if os.IsNotExist(err) {
+		return false
+	}
+	return info.IsDir()
+}
+
 // HasTickers checks if the DB exists and has the tickers table.
 func (r *Reader) HasTickers() bool {
 	return fileExists(tickersFile(r.cachePath()))
@@ -253,12 +493,37 @@ func (r *Reader) HasTickers() bool {
 
 // HasMonthly checks if the DB exists and has the monthly table.
 func (r *Reader) HasMonthly() bool {
-	return fileExists(monthlyFile(r.cachePath()))
+	return r.HasResampled(Monthly)
+}
+
+// HasResampled checks if the DB exists and has the resampled table of the
+// given frequency.
+func (r *Reader) HasResampled(freq Frequency) bool {
+	if r.format() == ColumnarFormat {
+		return dirExists(resampledDir(r.cachePath(), freq))
+	}
+	return fileExists(resampledFile(r.cachePath(), freq))
 }
 
 // HasPrices checks if the DB exists and has the prices for the ticker.
 func (r *Reader) HasPrices(ticker string) bool {
-	return fileExists(pricesFile(r.cachePath(), ticker))
+	return fileExists(pricesFile(r.cachePath(), ticker, r.format()))
+}
+
+// HasActions checks if the DB exists and has the actions table.
+func (r *Reader) HasActions() bool {
+	return fileExists(actionsFile(r.cachePath()))
+}
+
+// HasFX checks if the DB exists and has the FX rates table.
+func (r *Reader) HasFX() bool {
+	return fileExists(fxFile(r.cachePath()))
+}
+
+// HasFundamentals checks if the DB exists and has the fundamentals for the
+// ticker.
+func (r *Reader) HasFundamentals(ticker string) bool {
+	return fileExists(fundamentalsFile(r.cachePath(), ticker))
 }
 
 // HasMetadata checks if the DB exists and has the metadata.
@@ -275,8 +540,21 @@ func (r *Reader) Metadata() (Metadata, error) {
 }
 
 // TickerRow for the given ticker. It's an error if a ticker is not in R.
-// Tickers are cached in memory upon the first call. Go routine safe.
+// Tickers are cached in memory upon the first call. When AsOf is set, the row
+// is as of that date, see TickerRowAsOf. Go routine safe.
 func (r *Reader) TickerRow(ticker string) (TickerRow, error) {
+	row, err := r.currentTickerRow(ticker)
+	if err != nil {
+		return TickerRow{}, err
+	}
+	if r.AsOf.IsZero() {
+		return row, nil
+	}
+	return r.tickerRowAsOf(ticker, row, r.AsOf)
+}
+
+// currentTickerRow as stored in the tickers table.
+func (r *Reader) currentTickerRow(ticker string) (TickerRow, error) {
 	if err := r.cacheTickers(); err != nil {
 		return TickerRow{}, errors.Annotate(err, "failed to load tickers")
 	}
@@ -291,9 +569,9 @@ func (r *Reader) growthInRange(ctx context.Context, ticker string) bool {
 	if r.YearlyGrowth == nil {
 		return true
 	}
-	monthly, err := r.Monthly(ticker, r.YearlyGrowth.Start, r.YearlyGrowth.End)
+	monthly, err := r.Resampled(r.YearlyGrowth.Frequency, ticker, r.YearlyGrowth.Start, r.YearlyGrowth.End)
 	if err != nil {
-		logging.Warningf(ctx, "failed to load monthly data for %s:\n%s",
+		logging.Warningf(ctx, "failed to load resampled data for %s:\n%s",
 			ticker, err.Error())
 		return false
 	}
@@ -320,9 +598,9 @@ func (r *Reader) cashVolumeInRange(ctx context.Context, ticker string) bool {
 	if r.CashVolume == nil {
 		return true
 	}
-	monthly, err := r.Monthly(ticker, r.CashVolume.Start, r.CashVolume.End)
+	monthly, err := r.Resampled(r.CashVolume.Frequency, ticker, r.CashVolume.Start, r.CashVolume.End)
 	if err != nil {
-		logging.Warningf(ctx, "failed to load monthly data for %s:\n%s",
+		logging.Warningf(ctx, "failed to load resampled data for %s:\n%s",
 			ticker, err.Error())
 		return false
 	}
@@ -346,9 +624,9 @@ func (r *Reader) volatilityInRange(ctx context.Context, ticker string) bool {
 	if r.Volatility == nil {
 		return true
 	}
-	monthly, err := r.Monthly(ticker, r.Volatility.Start, r.Volatility.End)
+	monthly, err := r.Resampled(r.Volatility.Frequency, ticker, r.Volatility.Start, r.Volatility.End)
 	if err != nil {
-		logging.Warningf(ctx, "failed to load monthly data for %s\n%s",
+		logging.Warningf(ctx, "failed to load resampled data for %s\n%s",
 			ticker, err.Error())
 		return false
 	}
@@ -364,14 +642,14 @@ func (r *Reader) volatilityInRange(ctx context.Context, ticker string) bool {
 
 // checkTicker and its row if it satisfies all the constraints.
 func (r *Reader) checkTicker(ctx context.Context, ticker string, row TickerRow) bool {
-	if !r.constraints.CheckTicker(ticker) {
-		return false
-	}
-	if !r.constraints.CheckTickerRow(row) {
+	if !r.constraints.Check(ticker, row) {
 		return false
 	}
 	return r.growthInRange(ctx, ticker) &&
-		r.cashVolumeInRange(ctx, ticker) && r.volatilityInRange(ctx, ticker)
+		r.cashVolumeInRange(ctx, ticker) && r.volatilityInRange(ctx, ticker) &&
+		r.minPriceInRange(ctx, ticker) && r.drawdownInRange(ctx, ticker) &&
+		r.tradingDaysInRange(ctx, ticker) && r.zeroVolumeInRange(ctx, ticker) &&
+		r.correlationInRange(ctx, ticker)
 }
 
 // Tickers returns the list of tickers satisfying current Reader's constraints.
@@ -379,13 +657,36 @@ func (r *Reader) checkTicker(ctx context.Context, ticker string, row TickerRow)
 // Therefore, modifying Reader's constraints takes effect at the next call
 // without re-reading the tickers.  Go-routine safe assuming constraints are not
 // modified.
+//
+// When AsOf is set, only the tickers listed on that date are returned, and the
+// constraints apply to their rows as of that date, free of survivorship bias.
+// When Universe is set, the tickers must also be its members on AsOf date (or
+// currently, if AsOf is not set) and satisfy its constraints.
 func (r *Reader) Tickers(ctx context.Context) ([]string, error) {
 	if err := r.cacheTickers(); err != nil {
 		return nil, errors.Annotate(err, "failed to load tickers")
 	}
 	r.initConstraints()
+	if r.Universe != "" {
+		e, err := r.universeExpr()
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to load universe %s", r.Universe)
+		}
+		r.constraints.Where(e)
+	}
 	tickers := []string{}
 	for t, row := range r.tickers {
+		if !r.AsOf.IsZero() {
+			var err error
+			if row, err = r.tickerRowAsOf(t, row, r.AsOf); err != nil {
+				logging.Warningf(ctx, "failed to get %s as of %s:\n%s",
+					t, r.AsOf, err.Error())
+				continue
+			}
+			if !row.Active {
+				continue
+			}
+		}
 		if r.checkTicker(ctx, t, row) {
 			tickers = append(tickers, t)
 		}
@@ -409,86 +710,539 @@ func (r *Reader) AllTickerRows() (map[string]TickerRow, error) {
 // multiple times from the same process. Reading different tickers is definitely
 // safe in parallel, assuming consraints are not modified.
 func (r *Reader) Prices(ticker string) ([]PriceRow, error) {
-	prices := []PriceRow{}
-	if err := readGob(pricesFile(r.cachePath(), ticker), &prices); err != nil {
+	return r.PricesRange(ticker, Date{}, Date{})
+}
+
+// PricesRange for ticker within the inclusive date range, sorted by date. The
+// range is further restricted by Reader's Start and End, and zero bounds are
+// ignored. An end date without the time of day includes all the intraday bars
+// of that day. The range is located by binary search, and in the columnar
+// format only the prices within the range are read from disk. It is otherwise
+// the same as Prices.
+func (r *Reader) PricesRange(ticker string, start, end Date) ([]PriceRow, error) {
+	prices, err := r.readPrices(
+		ticker, MaxDate(start, r.Start), MinDate(end, r.End).EndOfDay())
+	if err != nil {
 		return nil, errors.Annotate(err, "failed to read prices for %s", ticker)
 	}
-	res := []PriceRow{}
+	if r.Intraday != nil {
+		res := []PriceRow{}
+		for _, p := range prices {
+			if r.Intraday.InRange(p.Date.Time) {
+				res = append(res, p)
+			}
+		}
+		prices = res
+	}
+	rates, err := r.fxRates(ticker)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to convert prices for %s", ticker)
+	}
+	if rates != nil {
+		for i := range prices {
+			prices[i] = prices[i].Convert(rates.rate(prices[i].Date))
+		}
+	}
+	return prices, nil
+}
+
+// PriceAt returns the latest price of ticker at or before the date, that is,
+// the price on the nearest previous trading day when the date is not one. For
+// intraday bars, a date without the time of day yields the last bar of that
+// day. Zero date means the latest available price. The result satisfies
+// Reader's constraints, and ok is false if there is no such price.
+func (r *Reader) PriceAt(ticker string, date Date) (p PriceRow, ok bool, err error) {
+	if r.format() == ColumnarFormat && r.Intraday == nil {
+		fileName := pricesFile(r.cachePath(), ticker, ColumnarFormat)
+		err = r.withLock(func() (err error) {
+			p, ok, err = readColumnarPriceAt(
+				fileName, r.Start, MinDate(date, r.End).EndOfDay())
+			return err
+		})
+		if err != nil {
+			return PriceRow{}, false, errors.Annotate(
+				err, "failed to read prices for %s", ticker)
+		}
+		rates, err := r.fxRates(ticker)
+		if err != nil {
+			return PriceRow{}, false, errors.Annotate(
+				err, "failed to convert prices for %s", ticker)
+		}
+		if ok && rates != nil {
+			p = p.Convert(rates.rate(p.Date))
+		}
+		return p, ok, nil
+	}
+	prices, err := r.PricesRange(ticker, Date{}, date)
+	if err != nil {
+		return PriceRow{}, false, errors.Annotate(err, "failed to read prices")
+	}
+	if len(prices) == 0 {
+		return PriceRow{}, false, nil
+	}
+	return prices[len(prices)-1], true, nil
+}
+
+// Gap is a range of consecutive trading days missing from a ticker's prices.
+type Gap struct {
+	Start Date `json:"start"` // the first missing trading day
+	End   Date `json:"end"`   // the last missing trading day
+	Days  int  `json:"days"`  // the number of missing trading days
+}
+
+// Gaps returns the ranges of NYSE trading days missing from the ticker's prices
+// between its first and the last price satisfying Reader's constraints, that
+// is, while the ticker was listed. Prices on non-trading days are ignored.
+func (r *Reader) Gaps(ticker string) ([]Gap, error) {
+	prices, err := r.Prices(ticker)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read prices")
+	}
+	if len(prices) == 0 {
+		return nil, nil
+	}
+	days := make(map[Date]bool)
 	for _, p := range prices {
-		if !p.Date.InRange(r.Start, r.End) {
+		days[p.Date.Date()] = true
+	}
+	gaps := []Gap{}
+	inGap := false
+	start := prices[0].Date.ToTime()
+	end := prices[len(prices)-1].Date.ToTime()
+	for _, t := range calendar.TradingDays(start, end) {
+		d := NewDateFromTime(t)
+		if days[d] {
+			inGap = false
 			continue
 		}
-		if r.Intraday != nil && !r.Intraday.InRange(p.Date.Time) {
-			continue
+		if !inGap {
+			gaps = append(gaps, Gap{Start: d})
+			inGap = true
+		}
+		g := &gaps[len(gaps)-1]
+		g.End = d
+		g.Days++
+	}
+	return gaps, nil
+}
+
+// pricesInRange returns the subslice of prices sorted by date within the
+// inclusive date range. Zero bounds are ignored.
+func pricesInRange(prices []PriceRow, start, end Date) []PriceRow {
+	b := 0
+	if !start.IsZero() {
+		b = sort.Search(len(prices), func(i int) bool {
+			return !prices[i].Date.Before(start)
+		})
+	}
+	e := len(prices)
+	if !end.IsZero() {
+		e = b + sort.Search(len(prices)-b, func(i int) bool {
+			return prices[b+i].Date.After(end)
+		})
+	}
+	return prices[b:e]
+}
+
+// readPrices for ticker within the inclusive date range. In the columnar
+// format, only the rows in the range are read from disk.
+func (r *Reader) readPrices(ticker string, start, end Date) (prices []PriceRow, err error) {
+	format := r.format()
+	err = r.withLock(func() error {
+		if format == ColumnarFormat {
+			prices, err = readColumnarPrices(
+				pricesFile(r.cachePath(), ticker, ColumnarFormat), start, end)
+			return err
+		}
+		prices = []PriceRow{}
+		if err := readGob(pricesFile(r.cachePath(), ticker, GobFormat), &prices); err != nil {
+			return errors.Annotate(err, "failed to read gob")
+		}
+		prices = pricesInRange(prices, start, end)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// Fundamentals for ticker in the given dimension with the DateKey within
+// Reader's date range, sorted by DateKey. Fundamentals are cached in memory for
+// each ticker upon the first call. Go routine safe assuming constraints are not
+// modified.
+func (r *Reader) Fundamentals(ticker string, dim Dimension) ([]FundamentalsRow, error) {
+	rows, err := r.cacheFundamentals(ticker)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read fundamentals for %s", ticker)
+	}
+	res := []FundamentalsRow{}
+	for _, f := range rows {
+		if f.Dimension == dim && f.DateKey.InRange(r.Start, r.End) {
+			res = append(res, f)
 		}
-		res = append(res, p)
 	}
 	return res, nil
 }
 
+// FundamentalsAsOf returns the latest fundamentals for ticker in the given
+// dimension as they were known on the given date, i.e. the row with the latest
+// DateKey <= date. The second value is false when there is no such row.  Note,
+// that MR* dimensions include restatements made after the DateKey, and only
+// AR* dimensions are free from look-ahead bias. Reader's date range is
+// ignored. Go routine safe.
+func (r *Reader) FundamentalsAsOf(ticker string, dim Dimension, date Date) (FundamentalsRow, bool, error) {
+	rows, err := r.cacheFundamentals(ticker)
+	if err != nil {
+		return FundamentalsRow{}, false, errors.Annotate(err,
+			"failed to read fundamentals for %s", ticker)
+	}
+	// Rows are sorted by dimension and then by DateKey.
+	end := sort.Search(len(rows), func(i int) bool {
+		if rows[i].Dimension != dim {
+			return rows[i].Dimension > dim
+		}
+		return rows[i].DateKey.After(date)
+	})
+	if end == 0 || rows[end-1].Dimension != dim {
+		return FundamentalsRow{}, false, nil
+	}
+	return rows[end-1], true, nil
+}
+
+// AllFundamentalsRows returns all the fundamentals of the ticker in all
+// dimensions, compatible with Writer.WriteFundamentals() method. Reader's date
+// range is ignored. Note: modifying the slice will modify the Reader's cached
+// copy.
+func (r *Reader) AllFundamentalsRows(ticker string) ([]FundamentalsRow, error) {
+	rows, err := r.cacheFundamentals(ticker)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read fundamentals for %s", ticker)
+	}
+	return rows, nil
+}
+
 // Monthly price data for ticker within the inclusive date range, sorted by
 // date.  If any of start or end is zero value, the corresponding Reader
 // constraint is used.  Data for all tickers are cached in memory upon the first
 // call. Go routine safe assuming constraints are not modified.
 func (r *Reader) Monthly(ticker string, start, end Date) ([]ResampledRow, error) {
-	if err := r.cacheMonthly(); err != nil {
-		return nil, errors.Annotate(err, "failed to load monthly data")
-	}
-	monthly, ok := r.monthly[ticker]
-	if !ok {
-		return nil, errors.Reason("no monthly data found for ticker %s", ticker)
-	}
+	return r.Resampled(Monthly, ticker, start, end)
+}
+
+// Resampled price data of the given frequency for ticker within the inclusive
+// date range, sorted by date. It is otherwise the same as Monthly, except that
+// in the columnar format the data is read from disk for each call, and only for
+// the requested date range.
+func (r *Reader) Resampled(freq Frequency, ticker string, start, end Date) ([]ResampledRow, error) {
 	if start.IsZero() {
 		start = r.Start
 	}
 	if end.IsZero() {
 		end = r.End
 	}
+	if r.format() == ColumnarFormat {
+		fileName := columnarResampledFile(r.cachePath(), freq, ticker)
+		if !fileExists(fileName) {
+			return nil, errors.Reason("no %s data found for ticker %s", freq, ticker)
+		}
+		var res []ResampledRow
+		err := r.withLock(func() (err error) {
+			res, err = readColumnarResampled(fileName, start, end)
+			return err
+		})
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to load %s data", freq)
+		}
+		return r.convertResampled(ticker, res)
+	}
+	all, err := r.cacheResampled(freq)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to load %s data", freq)
+	}
+	rows, ok := all[ticker]
+	if !ok {
+		return nil, errors.Reason("no %s data found for ticker %s", freq, ticker)
+	}
 	res := []ResampledRow{}
-	for _, row := range monthly {
+	for _, row := range rows {
 		if row.DateOpen.InRange(start, end) && row.DateClose.InRange(start, end) {
 			res = append(res, row)
 		}
 	}
-	return res, nil
+	return r.convertResampled(ticker, res)
+}
+
+// convertResampled rows of the ticker to Reader's Currency, if necessary. The
+// rows may be modified in place.
+func (r *Reader) convertResampled(ticker string, rows []ResampledRow) ([]ResampledRow, error) {
+	rates, err := r.fxRates(ticker)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to convert resampled data for %s", ticker)
+	}
+	if rates != nil {
+		for i, row := range rows {
+			rows[i] = row.Convert(rates.rate(row.DateOpen), rates.rate(row.DateClose))
+		}
+	}
+	return rows, nil
 }
 
 // AllMonthlyRows returns all the monthly resampled rows from the DB as a
 // {ticker -> row} map, compatible with Writer.WriteMonthly() method. Note:
 // modifying the map will modify the Reader's cached copy.
 func (r *Reader) AllMonthlyRows() (map[string][]ResampledRow, error) {
-	if err := r.cacheMonthly(); err != nil {
-		return nil, errors.Annotate(err, "failed to load tickers")
+	return r.AllResampledRows(Monthly)
+}
+
+// AllResampledRows returns all the resampled rows of the given frequency from
+// the DB as a {ticker -> row} map, compatible with Writer.WriteResampled()
+// method. Note: modifying the map will modify the Reader's cached copy.
+func (r *Reader) AllResampledRows(freq Frequency) (map[string][]ResampledRow, error) {
+	rows, err := r.cacheResampled(freq)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to load %s data", freq)
+	}
+	return rows, nil
+}
+
+// Actions for ticker within Reader's date range, sorted by date. A ticker
+// without any actions has an empty list. Actions for all tickers are cached in
+// memory upon the first call. Go routine safe assuming constraints are not
+// modified.
+func (r *Reader) Actions(ticker string) ([]ActionRow, error) {
+	if err := r.cacheActions(); err != nil {
+		return nil, errors.Annotate(err, "failed to load actions")
+	}
+	res := []ActionRow{}
+	for _, a := range r.actions[ticker] {
+		if a.Date.InRange(r.Start, r.End) {
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
+// FX rates of the currency pair (see FXPair) within Reader's date range, sorted
+// by date. The FX table is cached in memory upon the first call. Go routine safe
+// assuming constraints are not modified.
+func (r *Reader) FX(pair string) ([]FXRow, error) {
+	if err := r.cacheFX(); err != nil {
+		return nil, errors.Annotate(err, "failed to load FX rates")
+	}
+	res := []FXRow{}
+	for _, f := range r.fx[pair] {
+		if f.Date.InRange(r.Start, r.End) {
+			res = append(res, f)
+		}
+	}
+	return res, nil
+}
+
+// AllFXRows returns all the FX rates from the DB as a {pair -> rows} map,
+// compatible with Writer.WriteFX() method. Note: modifying the map will modify
+// the Reader's cached copy.
+func (r *Reader) AllFXRows() (map[string][]FXRow, error) {
+	if err := r.cacheFX(); err != nil {
+		return nil, errors.Annotate(err, "failed to load FX rates")
+	}
+	return r.fx, nil
+}
+
+// fxRates is a series of FX rates sorted by date.
+type fxRates []FXRow
+
+// rate in effect at the date: the latest rate at or before the date, or the
+// earliest rate for the dates before the series.
+func (f fxRates) rate(d Date) float32 {
+	i := sort.Search(len(f), func(i int) bool { return f[i].Date.After(d) })
+	if i == 0 {
+		return f[0].Rate
 	}
-	return r.monthly, nil
+	return f[i-1].Rate
 }
 
+// fxRates to convert the ticker's prices from its native currency to Reader's
+// Currency, or nil if no conversion is needed, i.e. when either of the
+// currencies is not set or they are the same. The rates of the direct pair are
+// preferred, otherwise the inverse pair is used. It is an error if neither is
+// in the DB.
+func (r *Reader) fxRates(ticker string) (fxRates, error) {
+	if r.Currency == "" {
+		return nil, nil
+	}
+	row, err := r.currentTickerRow(ticker)
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read ticker row")
+	}
+	if row.Currency == "" || row.Currency == r.Currency {
+		return nil, nil
+	}
+	if err := r.cacheFX(); err != nil {
+		return nil, errors.Annotate(err, "failed to load FX rates")
+	}
+	if rows := r.fx[FXPair(row.Currency, r.Currency)]; len(rows) > 0 {
+		return rows, nil
+	}
+	if rows := r.fx[FXPair(r.Currency, row.Currency)]; len(rows) > 0 {
+		inverse := make(fxRates, len(rows))
+		for i, f := range rows {
+			inverse[i] = FXRow{Date: f.Date, Rate: 1 / f.Rate}
+		}
+		return inverse, nil
+	}
+	return nil, errors.Reason("no FX rates to convert %s to %s",
+		row.Currency, r.Currency)
+}
+
+// AllActionRows returns all the actions from the DB as a {ticker -> rows} map,
+// compatible with Writer.WriteActions() method. Note: modifying the map will
+// modify the Reader's cached copy.
+func (r *Reader) AllActionRows() (map[string][]ActionRow, error) {
+	if err := r.cacheActions(); err != nil {
+		return nil, errors.Annotate(err, "failed to load actions")
+	}
+	return r.actions, nil
+}
+
+// Writer of the database. All the writes are staged into a new snapshot
+// starting with the current DB contents, and become visible to Readers only
+// after Commit.
 type Writer struct {
-	dbPath     string
-	db         string
-	Metadata   Metadata
-	mkdirOnce  sync.Once
-	mkdirError error
+	dbPath      string
+	db          string
+	Metadata    Metadata
+	Keep        int           // number of snapshots to keep; default: DefaultKeepSnapshots
+	LockTimeout time.Duration // default: DefaultLockTimeout
+	snapshot    string
+	writeLock   *fileLock
+	mkdirOnce   sync.Once
+	mkdirError  error
 }
 
+// NewWriter creates a Writer for the DB. If the DB already exists, the new data
+// is written in its storage format; otherwise, set Metadata.Format before
+// writing to choose the format.
 func NewWriter(dbPath, db string) *Writer {
-	return &Writer{dbPath: dbPath, db: db}
+	w := &Writer{
+		dbPath:      dbPath,
+		db:          db,
+		Keep:        DefaultKeepSnapshots,
+		LockTimeout: DefaultLockTimeout,
+	}
+	if r := NewReader(dbPath, db); r.HasMetadata() {
+		if m, err := r.Metadata(); err == nil {
+			w.Metadata.Format = m.Format
+		}
+	}
+	return w
 }
 
-func (w *Writer) cachePath() string {
+func (w *Writer) root() string {
 	return filepath.Join(w.dbPath, w.db)
 }
 
+// cachePath of the staged snapshot. Only valid after createDirs.
+func (w *Writer) cachePath() string {
+	return snapshotDir(w.root(), w.snapshot+stagingSuffix)
+}
+
+// createDirs locks the DB for writing and stages a new snapshot upon the first
+// write. The lock is held until Commit, or until the process exits.
 func (w *Writer) createDirs() error {
 	w.mkdirOnce.Do(func() {
-		if err := os.MkdirAll(pricesDir(w.cachePath()), os.ModeDir|0755); err != nil {
-			w.mkdirError = errors.Annotate(
-				err, "failed to create %s", pricesDir(w.cachePath()))
+		if err := os.MkdirAll(w.root(), os.ModeDir|0755); err != nil {
+			w.mkdirError = errors.Annotate(err, "failed to create %s", w.root())
+			return
+		}
+		l, err := lockFile(writeLockFile(w.root()), true, w.LockTimeout)
+		if err != nil {
+			w.mkdirError = errors.Annotate(err, "failed to lock DB %s for writing", w.db)
+			return
+		}
+		w.writeLock = l
+		name, err := stageSnapshot(w.root(), NewReader(w.dbPath, w.db).cachePath())
+		if err != nil {
+			w.mkdirError = errors.Annotate(err, "failed to stage a new snapshot")
+			return
+		}
+		w.snapshot = name
+		for _, dir := range []string{
+			pricesDir(w.cachePath()),
+			fundamentalsDir(w.cachePath()),
+		} {
+			if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+				w.mkdirError = errors.Annotate(err, "failed to create %s", dir)
+				return
+			}
 		}
 	})
 	return w.mkdirError
 }
 
+// Begin locks the DB for writing and stages a new snapshot, unless already done
+// by a previous write, and returns a Reader of the staged snapshot. An update
+// which depends on the existing data must call Begin before reading it, and
+// read it with the returned Reader, so that an update committed concurrently is
+// not silently overwritten. The Reader also sees the writes staged so far, and
+// is not valid after Commit or Rollback.
+func (w *Writer) Begin() (*Reader, error) {
+	if err := w.createDirs(); err != nil {
+		return nil, errors.Annotate(err, "failed to create DB directories")
+	}
+	r := NewReader(w.dbPath, w.db)
+	r.Snapshot = w.snapshot + stagingSuffix
+	return r, nil
+}
+
+// Commit atomically makes the staged writes the current DB snapshot, deletes
+// the older snapshots beyond the last w.Keep ones, and releases the write lock.
+// It is a no-op if nothing was written. The Writer can be reused after Commit,
+// and the next write starts a new snapshot.
+func (w *Writer) Commit() error {
+	if w.snapshot == "" {
+		return w.mkdirError
+	}
+	l, err := lockFile(readLockFile(w.root()), true, w.LockTimeout)
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB %s for commit", w.db)
+	}
+	err = commitSnapshot(w.root(), w.snapshot, w.Keep)
+	l.Unlock()
+	if err != nil {
+		return errors.Annotate(err, "failed to commit snapshot %s", w.snapshot)
+	}
+	if err := w.writeLock.Unlock(); err != nil {
+		return errors.Annotate(err, "failed to release the write lock")
+	}
+	w.writeLock = nil
+	w.snapshot = ""
+	w.mkdirOnce = sync.Once{}
+	return nil
+}
+
+// Rollback discards the staged writes and releases the write lock. It is a
+// no-op after Commit or if nothing was written, so it is safe to defer it right
+// after Begin. The Writer can be reused after Rollback.
+func (w *Writer) Rollback() error {
+	var err error
+	if w.snapshot != "" {
+		if e := os.RemoveAll(w.cachePath()); e != nil {
+			err = errors.Annotate(e, "failed to remove the staged snapshot")
+		}
+	}
+	if w.writeLock != nil {
+		if e := w.writeLock.Unlock(); e != nil && err == nil {
+			err = errors.Annotate(e, "failed to release the write lock")
+		}
+	}
+	w.writeLock = nil
+	w.snapshot = ""
+	w.mkdirOnce = sync.Once{}
+	w.mkdirError = nil
+	return err
+}
+
 // WriteTickers saves the tickers table to the DB file, and sets the number of
 // tickers in the metadata.
 func (w *Writer) WriteTickers(tickers map[string]TickerRow) error {
@@ -502,26 +1256,129 @@ func (w *Writer) WriteTickers(tickers map[string]TickerRow) error {
 	return nil
 }
 
+// WriteTickerHistory saves the ticker history table, replacing the existing one.
+// See also UpdateTickerHistory.
+func (w *Writer) WriteTickerHistory(history map[string][]TickerHistoryRow) error {
+	if err := w.createDirs(); err != nil {
+		return errors.Annotate(err, "failed to create DB directories")
+	}
+	if err := writeGob(tickerHistoryFile(w.cachePath()), history); err != nil {
+		return errors.Annotate(err, "failed to write '%s'",
+			tickerHistoryFile(w.cachePath()))
+	}
+	return nil
+}
+
 // WritePrices saves the ticker prices to the DB file and incrementally updates
 // the metadata.  Prices are assumed to be sorted by date.
 func (w *Writer) WritePrices(ticker string, prices []PriceRow) error {
 	if err := w.createDirs(); err != nil {
 		return errors.Annotate(err, "failed to create DB directories")
 	}
-	if err := writeGob(pricesFile(w.cachePath(), ticker), prices); err != nil {
-		return errors.Annotate(err, "failed to write '%s'",
-			pricesFile(w.cachePath(), ticker))
+	fileName := pricesFile(w.cachePath(), ticker, w.Metadata.Format)
+	if w.Metadata.Format == ColumnarFormat {
+		if err := writeColumnarPrices(fileName, prices); err != nil {
+			return errors.Annotate(err, "failed to write prices for %s", ticker)
+		}
+	} else if err := writeGob(fileName, prices); err != nil {
+		return errors.Annotate(err, "failed to write '%s'", fileName)
 	}
 	w.Metadata.UpdatePrices(prices)
 	return nil
 }
 
+// WriteFundamentals saves the ticker fundamentals of all dimensions to the DB
+// file and incrementally updates the metadata. Rows are assumed to be sorted by
+// dimension, and then by DateKey within each dimension.
+func (w *Writer) WriteFundamentals(ticker string, rows []FundamentalsRow) error {
+	if err := w.createDirs(); err != nil {
+		return errors.Annotate(err, "failed to create DB directories")
+	}
+	if err := writeGob(fundamentalsFile(w.cachePath(), ticker), rows); err != nil {
+		return errors.Annotate(err, "failed to write '%s'",
+			fundamentalsFile(w.cachePath(), ticker))
+	}
+	w.Metadata.UpdateFundamentals(rows)
+	return nil
+}
+
+// DeleteFundamentals removes the fundamentals of all the tickers from the
+// staged snapshot and resets their count in the metadata.
+func (w *Writer) DeleteFundamentals() error {
+	if err := w.createDirs(); err != nil {
+		return errors.Annotate(err, "failed to create DB directories")
+	}
+	dir := fundamentalsDir(w.cachePath())
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Annotate(err, "failed to remove '%s'", dir)
+	}
+	if err := os.MkdirAll(dir, os.ModeDir|0755); err != nil {
+		return errors.Annotate(err, "failed to create '%s'", dir)
+	}
+	w.Metadata.NumFundamentals = 0
+	return nil
+}
+
 // ComputeMonthly converts daily price series into resampled monthly price
 // series.
 func ComputeMonthly(prices []PriceRow) []ResampledRow {
+	return ComputeResampled(prices, Date.MonthStart)
+}
+
+// ComputeDaily aggregates intraday bars into daily bars: Open is the first
+// bar's Open, High and Low are the extremes of the day, the closing prices and
+// the active status are of the last bar, and the cash volume is the total. The
+// resulting dates have no time of day. Prices must be sorted by date. Daily
+// prices are returned as is.
+func ComputeDaily(prices []PriceRow) []PriceRow {
+	if !IsIntraday(prices) {
+		return prices
+	}
+	res := []PriceRow{}
+	for _, p := range prices {
+		day := p.Date.Date()
+		if len(res) == 0 || res[len(res)-1].Date != day {
+			p.Date = day
+			res = append(res, p)
+			continue
+		}
+		d := &res[len(res)-1]
+		if d.Open == 0 {
+			d.Open = p.Open
+		}
+		if p.High > d.High {
+			d.High = p.High
+		}
+		if p.Low != 0 && (d.Low == 0 || p.Low < d.Low) {
+			d.Low = p.Low
+		}
+		d.Close = p.Close
+		d.CloseSplitAdjusted = p.CloseSplitAdjusted
+		d.CloseFullyAdjusted = p.CloseFullyAdjusted
+		d.CashVolume += p.CashVolume
+	}
+	return res
+}
+
+// IsIntraday checks if any of the prices has a time of day.
+func IsIntraday(prices []PriceRow) bool {
+	for _, p := range prices {
+		if !p.Date.Time.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// ComputeResampled converts daily price series into resampled price series
+// where each bar spans the days with the same period(date), e.g. the start of
+// the week or the month. See also Frequency.Period. Intraday prices are first
+// aggregated into daily bars by ComputeDaily.
+func ComputeResampled(prices []PriceRow, period func(Date) Date) []ResampledRow {
 	if len(prices) == 0 {
 		return nil
 	}
+	prices = ComputeDaily(prices)
 
 	absLogProfit := func(x, y float32) float32 {
 		if y <= 0.0 {
@@ -535,15 +1392,15 @@ func ComputeMonthly(prices []PriceRow) []ResampledRow {
 	}
 
 	res := []ResampledRow{}
-	var currMonth Date
+	var currPeriod Date
 	var currRes ResampledRow
 	var prevClose float32
 	for _, p := range prices {
-		if currMonth != p.Date.MonthStart() {
-			if !currMonth.IsZero() {
+		if currPeriod != period(p.Date) {
+			if !currPeriod.IsZero() {
 				res = append(res, currRes)
 			}
-			prevClose = 0.0 // do not add cross-month volatility
+			prevClose = 0.0 // do not add cross-period volatility
 			currRes = ResampledRow{
 				Open:              p.Close,
 				OpenSplitAdjusted: p.CloseSplitAdjusted,
@@ -551,7 +1408,7 @@ func ComputeMonthly(prices []PriceRow) []ResampledRow {
 				DateOpen:          p.Date,
 			}
 		}
-		currMonth = p.Date.MonthStart()
+		currPeriod = period(p.Date)
 		currRes.Close = p.CloseUnadjusted()
 		currRes.CloseSplitAdjusted = p.CloseSplitAdjusted
 		currRes.CloseFullyAdjusted = p.CloseFullyAdjusted
@@ -566,73 +1423,313 @@ func ComputeMonthly(prices []PriceRow) []ResampledRow {
 	return res
 }
 
+// ComputeMonthlyTail updates the existing monthly series with the new daily
+// prices starting from the given date. Monthly bars closing before the month of
+// "since" are kept intact, and the rest are recomputed from prices, which must
+// be sorted by date and include all the daily samples of the month of "since".
+func ComputeMonthlyTail(monthly []ResampledRow, prices []PriceRow, since Date) []ResampledRow {
+	return ComputeResampledTail(monthly, prices, since, Date.MonthStart)
+}
+
+// ComputeResampledTail is the same as ComputeMonthlyTail for an arbitrary
+// period function, as in ComputeResampled.
+func ComputeResampledTail(rows []ResampledRow, prices []PriceRow, since Date, period func(Date) Date) []ResampledRow {
+	periodStart := period(since)
+	res := []ResampledRow{}
+	for _, r := range rows {
+		if !r.DateClose.Before(periodStart) {
+			break
+		}
+		res = append(res, r)
+	}
+	i := sort.Search(len(prices), func(i int) bool {
+		return !prices[i].Date.Before(periodStart)
+	})
+	return append(res, ComputeResampled(prices[i:], period)...)
+}
+
 // WriteMonthly saves the monthly resampled table to the DB file and sets the
 // number of samples in the metadata. ResampledRow's are indexed by ticker, and
 // for each ticker are assumed to be sorted by the closing date.
 func (w *Writer) WriteMonthly(monthly map[string][]ResampledRow) error {
+	return w.WriteResampled(Monthly, monthly)
+}
+
+// WriteResampled is the same as WriteMonthly for the given frequency.
+func (w *Writer) WriteResampled(freq Frequency, rows map[string][]ResampledRow) error {
+	if err := w.createDirs(); err != nil {
+		return errors.Annotate(err, "failed to create DB directories")
+	}
+	if w.Metadata.Format == ColumnarFormat {
+		if err := writeColumnarResampledDir(resampledDir(w.cachePath(), freq), rows); err != nil {
+			return errors.Annotate(err, "failed to write %s data", freq)
+		}
+	} else {
+		fileName := resampledFile(w.cachePath(), freq)
+		if err := writeGob(fileName, rows); err != nil {
+			return errors.Annotate(err, "failed to write '%s'", fileName)
+		}
+	}
+	w.Metadata.UpdateResampled(freq, rows)
+	return nil
+}
+
+// WriteActions saves the actions table to the DB file and sets the number of
+// actions in the metadata. ActionRow's are indexed by ticker, and for each
+// ticker are assumed to be sorted by date.
+func (w *Writer) WriteActions(actions map[string][]ActionRow) error {
 	if err := w.createDirs(); err != nil {
 		return errors.Annotate(err, "failed to create DB directories")
 	}
-	if err := writeGob(monthlyFile(w.cachePath()), monthly); err != nil {
+	if err := writeGob(actionsFile(w.cachePath()), actions); err != nil {
 		return errors.Annotate(err, "failed to write '%s'",
-			monthlyFile(w.cachePath()))
+			actionsFile(w.cachePath()))
+	}
+	w.Metadata.UpdateActions(actions)
+	return nil
+}
+
+// WriteSymbols saves the symbol history table, replacing the existing one. The
+// rows are indexed by the old symbol, see UpdateSymbols.
+func (w *Writer) WriteSymbols(symbols map[string][]SymbolRow) error {
+	if err := w.createDirs(); err != nil {
+		return errors.Annotate(err, "failed to create DB directories")
+	}
+	if err := writeGob(symbolsFile(w.cachePath()), symbols); err != nil {
+		return errors.Annotate(err, "failed to write '%s'", symbolsFile(w.cachePath()))
 	}
-	w.Metadata.UpdateMonthly(monthly)
+	return nil
+}
+
+// WriteUniverses saves the named universes, replacing the existing ones.
+func (w *Writer) WriteUniverses(universes map[string]Universe) error {
+	if err := w.createDirs(); err != nil {
+		return errors.Annotate(err, "failed to create DB directories")
+	}
+	if err := writeGob(universesFile(w.cachePath()), universes); err != nil {
+		return errors.Annotate(err, "failed to write '%s'", universesFile(w.cachePath()))
+	}
+	return nil
+}
+
+// WriteFX saves the FX rates table, replacing the existing one. The rates of
+// each pair must be sorted by date.
+func (w *Writer) WriteFX(rates map[string][]FXRow) error {
+	if err := w.createDirs(); err != nil {
+		return errors.Annotate(err, "failed to create DB directories")
+	}
+	if err := writeGob(fxFile(w.cachePath()), rates); err != nil {
+		return errors.Annotate(err, "failed to write '%s'", fxFile(w.cachePath()))
+	}
+	w.Metadata.UpdateFX(rates)
 	return nil
 }
 
 // WriteMetadata saves the metadata accumulated by the Write* methods. It is
 // stored in JSON format to be human-readable.
 func (w *Writer) WriteMetadata(m Metadata) error {
+	if err := w.createDirs(); err != nil {
+		return errors.Annotate(err, "failed to create DB directories")
+	}
 	fileName := metadataFile(w.cachePath())
-	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	err := writeFileAtomic(fileName, func(f io.Writer) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(m)
+	})
 	if err != nil {
-		return errors.Annotate(err, "failed to open file for writing: '%s'", fileName)
-	}
-	defer f.Close()
-
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(m); err != nil {
 		return errors.Annotate(err, "failed to write to '%s'", fileName)
 	}
 	return nil
 }
 
-// Cleanup the DB: delete price files that do not have a corresponding ticker.
-// This is useful, e.g. when a ticker gets renamed and its price series is
-// downloaded under the new name, but the old series remains in the DB.
-func Cleanup(ctx context.Context, dbPath, db string) error {
-	r := NewReader(dbPath, db)
-	tickers, err := r.AllTickerRows()
+// cleanupDir deletes the .gob and .col files in dir without a corresponding
+// ticker. The files of a renamed symbol are instead moved to its current ticker
+// according to renamed {old symbol -> ticker}, unless the ticker already has
+// them. A missing dir is not an error.
+func cleanupDir(ctx context.Context, dir string, tickers map[string]TickerRow, renamed map[string]string) error {
+	f, err := os.Open(dir)
 	if err != nil {
-		return errors.Annotate(err, "failed to read tickers from DB")
-	}
-	pricesPath := pricesDir(r.cachePath())
-	f, err := os.Open(pricesPath)
-	if err != nil {
-		return errors.Annotate(err, "cannot open '%s'", pricesPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Annotate(err, "cannot open '%s'", dir)
 	}
 	defer f.Close()
 
 	entries, err := f.ReadDir(0)
 	if err != nil {
-		return errors.Annotate(err, "failed to read '%s'", pricesPath)
+		return errors.Annotate(err, "failed to read '%s'", dir)
 	}
 	for _, e := range entries {
 		name := e.Name()
-		if len(name) < 4 || name[len(name)-4:] != ".gob" {
+		ext := filepath.Ext(name)
+		if ext != fileExt(GobFormat) && ext != fileExt(ColumnarFormat) {
 			continue
 		}
-		ticker := name[:len(name)-4]
+		ticker := name[:len(name)-len(ext)]
 		if _, ok := tickers[ticker]; ok {
 			continue
 		}
-		logging.Infof(ctx, "removing stale prices for %s", ticker)
-		stalePath := pricesFile(r.cachePath(), ticker)
+		stalePath := filepath.Join(dir, name)
+		if t, ok := renamed[ticker]; ok {
+			newPath := filepath.Join(dir, t+ext)
+			if !fileExists(newPath) {
+				logging.Infof(ctx, "moving %s of %s to %s", filepath.Base(dir), ticker, t)
+				if err := os.Rename(stalePath, newPath); err != nil {
+					return errors.Annotate(err, "failed to rename '%s'", stalePath)
+				}
+				continue
+			}
+		}
+		logging.Infof(ctx, "removing stale %s for %s", filepath.Base(dir), ticker)
 		if err := os.Remove(stalePath); err != nil {
 			return errors.Annotate(err, "failed to remove '%s'", stalePath)
 		}
 	}
 	return nil
 }
+
+// Cleanup the DB: delete price, resampled and fundamentals files that do not
+// have a corresponding ticker.  This is useful, e.g. when a ticker gets renamed
+// and its price series is downloaded under the new name, but the old series
+// remains in the DB. When the symbol history records the rename and the new
+// ticker has no such file, the old file is moved to the new ticker instead, so
+// the data is not lost. The result is committed as a new snapshot.
+func Cleanup(ctx context.Context, dbPath, db string) error {
+	w := NewWriter(dbPath, db)
+	if err := w.Cleanup(ctx); err != nil {
+		return errors.Annotate(err, "failed to clean up DB")
+	}
+	if err := w.Commit(); err != nil {
+		return errors.Annotate(err, "failed to commit DB")
+	}
+	return nil
+}
+
+// Cleanup is the same as the package-level Cleanup applied to the staged
+// snapshot, including any tickers written by w. Call Commit to save the result.
+func (w *Writer) Cleanup(ctx context.Context) error {
+	if err := w.createDirs(); err != nil {
+		return errors.Annotate(err, "failed to create DB directories")
+	}
+	tickers := make(map[string]TickerRow)
+	if err := readGob(tickersFile(w.cachePath()), &tickers); err != nil {
+		return errors.Annotate(err, "failed to read tickers from DB")
+	}
+	symbols := make(map[string][]SymbolRow)
+	if fileExists(symbolsFile(w.cachePath())) {
+		if err := readGob(symbolsFile(w.cachePath()), &symbols); err != nil {
+			return errors.Annotate(err, "failed to read symbols from DB")
+		}
+	}
+	ids := tickerIDs(tickers)
+	renamed := make(map[string]string) // old symbol -> current ticker
+	for s, rows := range symbols {
+		if len(rows) == 0 {
+			continue
+		}
+		if t, ok := ids[rows[len(rows)-1].ID]; ok {
+			renamed[s] = t
+		}
+	}
+	dirs := []string{
+		pricesDir(w.cachePath()),
+		fundamentalsDir(w.cachePath()),
+	}
+	for _, f := range Frequencies {
+		dirs = append(dirs, resampledDir(w.cachePath(), f))
+	}
+	for _, dir := range dirs {
+		if err := cleanupDir(ctx, dir, tickers, renamed); err != nil {
+			return errors.Annotate(err, "failed to clean up '%s'", dir)
+		}
+	}
+	return nil
+}
+
+// Convert the price and resampled tables of an existing DB to the given storage
+// format. The converted DB is committed as a new snapshot.
+func Convert(ctx context.Context, dbPath, db string, format StorageFormat) error {
+	if !NewReader(dbPath, db).HasMetadata() {
+		return errors.Reason("DB %s has no metadata", db)
+	}
+	w := NewWriter(dbPath, db)
+	r, err := w.Begin()
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB for conversion")
+	}
+	defer w.Rollback()
+	if !r.HasMetadata() {
+		return errors.Reason("DB %s has no metadata", db)
+	}
+	oldFormat := r.format()
+	if oldFormat == format {
+		logging.Infof(ctx, "DB %s is already in %s format", db, format)
+		return nil
+	}
+	meta, err := r.Metadata()
+	if err != nil {
+		return errors.Annotate(err, "failed to read metadata")
+	}
+	tickers, err := r.AllTickerRows()
+	if err != nil {
+		return errors.Annotate(err, "failed to read tickers")
+	}
+	w.Metadata = meta
+	w.Metadata.Format = format
+	w.Metadata.NumPrices = 0 // recomputed by WritePrices
+	logging.Infof(ctx, "converting prices to %s format...", format)
+	var converted []string
+	for t := range tickers {
+		if !r.HasPrices(t) {
+			continue
+		}
+		prices, err := r.readPrices(t, Date{}, Date{})
+		if err != nil {
+			return errors.Annotate(err, "failed to read prices for %s", t)
+		}
+		if err := w.WritePrices(t, prices); err != nil {
+			return errors.Annotate(err, "failed to write prices for %s", t)
+		}
+		converted = append(converted, t)
+	}
+	var frequencies []Frequency
+	for _, f := range Frequencies {
+		if !r.HasResampled(f) {
+			continue
+		}
+		logging.Infof(ctx, "converting %s data to %s format...", f, format)
+		rows, err := r.AllResampledRows(f)
+		if err != nil {
+			return errors.Annotate(err, "failed to read %s data", f)
+		}
+		if err := w.WriteResampled(f, rows); err != nil {
+			return errors.Annotate(err, "failed to write %s data", f)
+		}
+		frequencies = append(frequencies, f)
+	}
+	if err := w.WriteMetadata(w.Metadata); err != nil {
+		return errors.Annotate(err, "failed to write metadata")
+	}
+	logging.Infof(ctx, "removing %s files...", oldFormat)
+	for _, t := range converted {
+		fileName := pricesFile(w.cachePath(), t, oldFormat)
+		if err := os.Remove(fileName); err != nil {
+			return errors.Annotate(err, "failed to remove '%s'", fileName)
+		}
+	}
+	for _, f := range frequencies {
+		fileName := resampledFile(w.cachePath(), f)
+		if oldFormat == ColumnarFormat {
+			fileName = resampledDir(w.cachePath(), f)
+		}
+		if err := os.RemoveAll(fileName); err != nil {
+			return errors.Annotate(err, "failed to remove '%s'", fileName)
+		}
+	}
+	if err := w.Commit(); err != nil {
+		return errors.Annotate(err, "failed to commit DB")
+	}
+	return nil
+}
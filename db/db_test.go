@@ -39,15 +39,17 @@ func TestRanges(t *testing.T) {
   "min": -1.5,
   "max": 100,
   "start": "2020-02-02",
-  "end": "2021-04-21"
+  "end": "2021-04-21",
+  "frequency": "weekly"
 }`)), ShouldBeNil)
 			min := -1.5
 			max := 100.0
 			So(i, ShouldResemble, Interval{
-				Min:   &min,
-				Max:   &max,
-				Start: NewDate(2020, 2, 2),
-				End:   NewDate(2021, 4, 21),
+				Min:       &min,
+				Max:       &max,
+				Start:     NewDate(2020, 2, 2),
+				End:       NewDate(2021, 4, 21),
+				Frequency: Weekly,
 			})
 			So(i.ValueInRange(50.0), ShouldBeTrue)
 			So(i.ValueInRange(-50.0), ShouldBeFalse)
@@ -123,6 +125,25 @@ func TestDB(t *testing.T) {
 				TestResampled(NewDate(2019, 2, 1), NewDate(2019, 2, 28), 150.0, 200.0, 200.0, 2000.0, true),
 			},
 		}
+		weekly := map[string][]ResampledRow{
+			"A": {
+				TestResampled(NewDate(2019, 1, 1), NewDate(2019, 1, 3), 10.0, 12.0, 12.0, 3300.0, true),
+			},
+		}
+		actions := map[string][]ActionRow{
+			"A": {
+				TestAction(NewDate(2019, 1, 2), SplitAction, 2.0, ""),
+				TestAction(NewDate(2019, 2, 2), DividendAction, 0.5, ""),
+			},
+			"B": {
+				TestAction(NewDate(2019, 1, 3), SpinoffAction, 10.0, "C"),
+			},
+		}
+		fundamentalsA := []FundamentalsRow{
+			TestFundamentals(ARQ, NewDate(2019, 2, 10), NewDate(2018, 12, 31), 100.0, 10.0),
+			TestFundamentals(ARQ, NewDate(2019, 5, 10), NewDate(2019, 3, 31), 110.0, 12.0),
+			TestFundamentals(MRQ, NewDate(2019, 2, 10), NewDate(2018, 12, 31), 100.0, 10.0),
+		}
 
 		Convey("write methods work", func() {
 			w := NewWriter(tmpdir, dbName)
@@ -130,7 +151,11 @@ func TestDB(t *testing.T) {
 			So(w.WritePrices("A", pricesA), ShouldBeNil)
 			So(w.WritePrices("B", pricesB), ShouldBeNil)
 			So(w.WriteMonthly(monthly), ShouldBeNil)
+			So(w.WriteResampled(Weekly, weekly), ShouldBeNil)
+			So(w.WriteActions(actions), ShouldBeNil)
+			So(w.WriteFundamentals("A", fundamentalsA), ShouldBeNil)
 			So(w.WriteMetadata(w.Metadata), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
 		})
 
 		Convey("ComputeMonthly works", func() {
@@ -189,6 +214,65 @@ func TestDB(t *testing.T) {
 			})
 		})
 
+		Convey("ComputeResampled works", func() {
+			daily := []PriceRow{
+				TestPrice(NewDate(2020, 1, 2), 100.0, 50.0, 50.0, 1000.0, true),
+				TestPrice(NewDate(2020, 1, 3), 110.0, 55.0, 55.0, 1000.0, true),
+				TestPrice(NewDate(2020, 1, 6), 102.0, 51.0, 51.0, 2000.0, true),
+				TestPrice(NewDate(2020, 4, 1), 130.0, 65.0, 65.0, 1000.0, true),
+			}
+			weekly := ComputeResampled(daily, Weekly.Period)
+			So(len(weekly), ShouldEqual, 3)
+			So(weekly[0].DateOpen, ShouldResemble, NewDate(2020, 1, 2))
+			So(weekly[0].DateClose, ShouldResemble, NewDate(2020, 1, 3))
+			So(weekly[0].NumSamples, ShouldEqual, 2)
+			So(weekly[1].DateOpen, ShouldResemble, NewDate(2020, 1, 6))
+
+			quarterly := ComputeResampled(daily, Quarterly.Period)
+			So(len(quarterly), ShouldEqual, 2)
+			So(quarterly[0].DateClose, ShouldResemble, NewDate(2020, 1, 6))
+			So(quarterly[0].CashVolume, ShouldEqual, 4000.0)
+
+			yearly := ComputeResampled(daily, Yearly.Period)
+			So(len(yearly), ShouldEqual, 1)
+			So(yearly[0].NumSamples, ShouldEqual, 4)
+
+			So(ComputeResampled(daily, Monthly.Period), ShouldResemble, ComputeMonthly(daily))
+		})
+
+		Convey("ComputeDaily works", func() {
+			intraday := []PriceRow{
+				TestPriceRow(NewDatetime(2020, 1, 2, 9, 30, 0, 0), 0, 12, 10, 11, 11, 11, 100, true),
+				TestPriceRow(NewDatetime(2020, 1, 2, 10, 0, 0, 0), 11, 14, 0, 13, 13, 13, 200, true),
+				TestPriceRow(NewDatetime(2020, 1, 2, 10, 30, 0, 0), 13, 13, 9, 12, 12, 12, 300, true),
+				TestPriceRow(NewDatetime(2020, 1, 3, 9, 30, 0, 0), 12, 15, 12, 14, 14, 14, 400, true),
+			}
+			So(IsIntraday(intraday), ShouldBeTrue)
+			daily := ComputeDaily(intraday)
+			So(daily, ShouldResemble, []PriceRow{
+				TestPriceRow(NewDate(2020, 1, 2), 11, 14, 9, 12, 12, 12, 600, true),
+				TestPriceRow(NewDate(2020, 1, 3), 12, 15, 12, 14, 14, 14, 400, true),
+			})
+			So(IsIntraday(daily), ShouldBeFalse)
+			So(ComputeDaily(daily), ShouldResemble, daily)
+			So(ComputeMonthly(intraday), ShouldResemble, ComputeMonthly(daily))
+		})
+
+		Convey("ComputeMonthlyTail works", func() {
+			daily := []PriceRow{
+				TestPrice(NewDate(2020, 1, 3), 100.0, 50.0, 50.0, 1000.0, true),
+				TestPrice(NewDate(2020, 1, 15), 102.0, 51.0, 51.0, 2000.0, true),
+				TestPrice(NewDate(2020, 2, 5), 130.0, 65.0, 65.0, 1000.0, true),
+				TestPrice(NewDate(2020, 2, 6), 140.0, 70.0, 70.0, 1000.0, true),
+			}
+			monthly := ComputeMonthly(daily)
+			daily = append(daily,
+				TestPrice(NewDate(2020, 2, 7), 150.0, 75.0, 75.0, 1000.0, true),
+				TestPrice(NewDate(2020, 3, 1), 160.0, 80.0, 80.0, 500.0, true))
+			So(ComputeMonthlyTail(monthly, daily, NewDate(2020, 2, 7)),
+				ShouldResemble, ComputeMonthly(daily))
+		})
+
 		Convey("ticker access methods work", func() {
 			db := NewReader(tmpdir, dbName)
 			ctx := context.Background()
@@ -267,6 +351,64 @@ func TestDB(t *testing.T) {
 			So(p, ShouldResemble, pricesB[1:])
 		})
 
+		Convey("PricesRange works", func() {
+			db := NewReader(tmpdir, dbName)
+			p, err := db.PricesRange("B", NewDate(2019, 1, 2), Date{})
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, pricesB[1:])
+
+			p, err = db.PricesRange("B", Date{}, NewDate(2019, 1, 2))
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, pricesB[:2])
+
+			db.Start = NewDate(2019, 1, 3)
+			p, err = db.PricesRange("B", NewDate(2019, 1, 2), Date{})
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, pricesB[2:])
+
+			p, err = db.PricesRange("B", Date{}, NewDate(2019, 1, 2))
+			So(err, ShouldBeNil)
+			So(len(p), ShouldEqual, 0)
+
+			// The end date includes all the intraday bars of that day.
+			db.Start = Date{}
+			p, err = db.PricesRange("A", Date{}, NewDate(2019, 1, 2))
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, pricesA[:2])
+		})
+
+		Convey("PriceAt works", func() {
+			db := NewReader(tmpdir, dbName)
+			p, ok, err := db.PriceAt("B", NewDate(2019, 1, 2))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(p, ShouldResemble, pricesB[1])
+
+			p, ok, err = db.PriceAt("B", NewDate(2019, 1, 10))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(p, ShouldResemble, pricesB[2])
+
+			_, ok, err = db.PriceAt("B", NewDate(2018, 12, 31))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			db.End = NewDate(2019, 1, 1)
+			p, ok, err = db.PriceAt("B", Date{})
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(p, ShouldResemble, pricesB[0])
+
+			db.End = Date{}
+			p, ok, err = db.PriceAt("A", NewDate(2019, 1, 3))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(p, ShouldResemble, pricesA[2])
+
+			_, _, err = db.PriceAt("UNKNOWN", NewDate(2019, 1, 2))
+			So(err, ShouldNotBeNil)
+		})
+
 		Convey("monthly access methods work", func() {
 			db := NewReader(tmpdir, dbName)
 			a, err := db.Monthly("A", Date{}, Date{})
@@ -284,16 +426,116 @@ func TestDB(t *testing.T) {
 			So(a, ShouldResemble, monthly["B"][:1])
 		})
 
+		Convey("resampled access methods work", func() {
+			db := NewReader(tmpdir, dbName)
+			So(db.HasResampled(Monthly), ShouldBeTrue)
+			So(db.HasResampled(Weekly), ShouldBeTrue)
+			So(db.HasResampled(Yearly), ShouldBeFalse)
+
+			a, err := db.Resampled(Weekly, "A", Date{}, Date{})
+			So(err, ShouldBeNil)
+			So(a, ShouldResemble, weekly["A"])
+
+			_, err = db.Resampled(Weekly, "B", Date{}, Date{})
+			So(err, ShouldNotBeNil)
+
+			_, err = db.Resampled(Yearly, "A", Date{}, Date{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("actions access methods work", func() {
+			db := NewReader(tmpdir, dbName)
+			So(db.HasActions(), ShouldBeTrue)
+
+			a, err := db.Actions("A")
+			So(err, ShouldBeNil)
+			So(a, ShouldResemble, actions["A"])
+
+			a, err = db.Actions("C")
+			So(err, ShouldBeNil)
+			So(len(a), ShouldEqual, 0)
+
+			db.End = NewDate(2019, 1, 31)
+			a, err = db.Actions("A")
+			So(err, ShouldBeNil)
+			So(a, ShouldResemble, actions["A"][:1])
+
+			all, err := db.AllActionRows()
+			So(err, ShouldBeNil)
+			So(all, ShouldResemble, actions)
+		})
+
+		Convey("fundamentals access methods work", func() {
+			db := NewReader(tmpdir, dbName)
+			So(db.HasFundamentals("A"), ShouldBeTrue)
+			So(db.HasFundamentals("B"), ShouldBeFalse)
+
+			f, err := db.Fundamentals("A", ARQ)
+			So(err, ShouldBeNil)
+			So(f, ShouldResemble, fundamentalsA[:2])
+
+			f, err = db.Fundamentals("A", ART)
+			So(err, ShouldBeNil)
+			So(len(f), ShouldEqual, 0)
+
+			db.End = NewDate(2019, 3, 1)
+			f, err = db.Fundamentals("A", ARQ)
+			So(err, ShouldBeNil)
+			So(f, ShouldResemble, fundamentalsA[:1])
+
+			_, err = db.Fundamentals("B", ARQ)
+			So(err, ShouldNotBeNil)
+
+			f, err = db.AllFundamentalsRows("A")
+			So(err, ShouldBeNil)
+			So(f, ShouldResemble, fundamentalsA)
+		})
+
+		Convey("FundamentalsAsOf works", func() {
+			db := NewReader(tmpdir, dbName)
+			db.End = NewDate(2019, 3, 1) // ignored
+
+			f, ok, err := db.FundamentalsAsOf("A", ARQ, NewDate(2019, 6, 1))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(f, ShouldResemble, fundamentalsA[1])
+
+			f, ok, err = db.FundamentalsAsOf("A", ARQ, NewDate(2019, 5, 9))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(f, ShouldResemble, fundamentalsA[0])
+
+			f, ok, err = db.FundamentalsAsOf("A", MRQ, NewDate(2019, 6, 1))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(f, ShouldResemble, fundamentalsA[2])
+
+			_, ok, err = db.FundamentalsAsOf("A", ARQ, NewDate(2019, 2, 9))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			_, ok, err = db.FundamentalsAsOf("A", ART, NewDate(2019, 6, 1))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			_, _, err = db.FundamentalsAsOf("B", ARQ, NewDate(2019, 6, 1))
+			So(err, ShouldNotBeNil)
+		})
+
 		Convey("metadata access methods work", func() {
 			db := NewReader(tmpdir, dbName)
 			m, err := db.Metadata()
 			So(err, ShouldBeNil)
 			So(m, ShouldResemble, Metadata{
-				Start:      NewDate(2019, 1, 1),
-				End:        NewDatetime(2019, 1, 3, 17, 9, 59, 0),
-				NumTickers: 2,
-				NumPrices:  6,
-				NumMonthly: 4,
+				Start:           NewDate(2019, 1, 1),
+				End:             NewDatetime(2019, 1, 3, 17, 9, 59, 0),
+				NumTickers:      2,
+				NumPrices:       6,
+				NumWeekly:       1,
+				NumMonthly:      4,
+				NumActions:      3,
+				NumFundamentals: 3,
+				Intraday:        true,
 			})
 		})
 
@@ -314,7 +556,8 @@ func TestDB(t *testing.T) {
   "end": "2021-10-02"
   }`, tmpdir, dbName)
 			So(r.InitMessage(testutil.JSON(js)), ShouldBeNil)
-			So(r.cachePath(), ShouldEqual, dbPath)
+			So(r.SnapshotName(), ShouldNotEqual, "")
+			So(r.cachePath(), ShouldEqual, snapshotDir(dbPath, r.SnapshotName()))
 			r.initConstraints()
 			So(r.constraints, ShouldResemble, &Constraints{
 				Sources:        map[string]struct{}{"S1": {}, "S2": {}},
@@ -331,13 +574,145 @@ func TestDB(t *testing.T) {
 		Convey("Cleanup works", func() {
 			w := NewWriter(tmpdir, dbName)
 			So(w.WritePrices("C", pricesB), ShouldBeNil) // C is not in tickers
-			So(fileExists(pricesFile(w.cachePath(), "C")), ShouldBeTrue)
+			So(w.WriteFundamentals("C", fundamentalsA), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+			r := NewReader(tmpdir, dbName)
+			So(fileExists(pricesFile(r.cachePath(), "C", GobFormat)), ShouldBeTrue)
+			So(fileExists(fundamentalsFile(r.cachePath(), "C")), ShouldBeTrue)
 
 			ctx := context.Background()
 			So(Cleanup(ctx, tmpdir, dbName), ShouldBeNil)
-			So(fileExists(pricesFile(w.cachePath(), "C")), ShouldBeFalse)
-			So(fileExists(pricesFile(w.cachePath(), "A")), ShouldBeTrue)
-			So(fileExists(pricesFile(w.cachePath(), "B")), ShouldBeTrue)
+			r = NewReader(tmpdir, dbName)
+			So(fileExists(pricesFile(r.cachePath(), "C", GobFormat)), ShouldBeFalse)
+			So(fileExists(fundamentalsFile(r.cachePath(), "C")), ShouldBeFalse)
+			So(fileExists(pricesFile(r.cachePath(), "A", GobFormat)), ShouldBeTrue)
+			So(fileExists(pricesFile(r.cachePath(), "B", GobFormat)), ShouldBeTrue)
+			So(fileExists(fundamentalsFile(r.cachePath(), "A")), ShouldBeTrue)
+		})
+	})
+
+	Convey("Gaps works", t, func() {
+		dbName := "gaps"
+		prices := []PriceRow{
+			TestPrice(NewDate(2022, 12, 21), 10.0, 10.0, 10.0, 1000.0, true),
+			// Missing: Dec 22 (Thu), Dec 23 (Fri); Dec 26 is a holiday.
+			TestPrice(NewDate(2022, 12, 27), 11.0, 11.0, 11.0, 1100.0, true),
+			TestPrice(NewDatetime(2022, 12, 28, 10, 0, 0, 0), 12.0, 12.0, 12.0, 1200.0, true),
+			TestPrice(NewDatetime(2022, 12, 28, 11, 0, 0, 0), 12.0, 12.0, 12.0, 1200.0, true),
+			// Missing: Dec 29 (Thu).
+			TestPrice(NewDate(2022, 12, 30), 13.0, 13.0, 13.0, 1300.0, true),
+			TestPrice(NewDate(2022, 12, 31), 13.0, 13.0, 13.0, 1300.0, true), // Saturday
+		}
+		w := NewWriter(tmpdir, dbName)
+		So(w.WritePrices("A", prices), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
+
+		r := NewReader(tmpdir, dbName)
+		gaps, err := r.Gaps("A")
+		So(err, ShouldBeNil)
+		So(gaps, ShouldResemble, []Gap{
+			{Start: NewDate(2022, 12, 22), End: NewDate(2022, 12, 23), Days: 2},
+			{Start: NewDate(2022, 12, 29), End: NewDate(2022, 12, 29), Days: 1},
+		})
+
+		r.Start = NewDate(2022, 12, 27)
+		gaps, err = r.Gaps("A")
+		So(err, ShouldBeNil)
+		So(gaps, ShouldResemble, []Gap{
+			{Start: NewDate(2022, 12, 29), End: NewDate(2022, 12, 29), Days: 1},
+		})
+
+		_, err = r.Gaps("UNKNOWN")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("FX conversion works", t, func() {
+		dbName := "fx"
+		tickers := map[string]TickerRow{
+			"EU": {Currency: "EUR"},
+			"CH": {Currency: "CHF"},
+			"US": {Currency: "USD"},
+			"XX": {}, // unknown currency
+			"GB": {Currency: "GBP"},
+		}
+		prices := []PriceRow{
+			TestPrice(NewDate(2020, 1, 2), 10.0, 10.0, 10.0, 1000.0, true),
+			TestPrice(NewDate(2020, 1, 3), 20.0, 20.0, 20.0, 2000.0, true),
+		}
+		rates := map[string][]FXRow{
+			"EURUSD": {
+				{Date: NewDate(2020, 1, 2), Rate: 1.5},
+				{Date: NewDate(2020, 1, 3), Rate: 2.0},
+			},
+			"USDCHF": {{Date: NewDate(2020, 1, 1), Rate: 0.5}},
+		}
+		w := NewWriter(tmpdir, dbName)
+		So(w.WriteTickers(tickers), ShouldBeNil)
+		for t := range tickers {
+			So(w.WritePrices(t, prices), ShouldBeNil)
+		}
+		So(w.WriteMonthly(map[string][]ResampledRow{"EU": ComputeMonthly(prices)}), ShouldBeNil)
+		So(w.WriteFX(rates), ShouldBeNil)
+		So(w.Metadata.NumFX, ShouldEqual, 3)
+		So(w.Commit(), ShouldBeNil)
+
+		r := NewReader(tmpdir, dbName)
+		So(r.HasFX(), ShouldBeTrue)
+		fx, err := r.FX("EURUSD")
+		So(err, ShouldBeNil)
+		So(fx, ShouldResemble, rates["EURUSD"])
+
+		Convey("native currency by default", func() {
+			p, err := r.Prices("EU")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, prices)
+		})
+
+		Convey("direct pair", func() {
+			r.Currency = "USD"
+			p, err := r.Prices("EU")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, []PriceRow{
+				TestPrice(NewDate(2020, 1, 2), 15.0, 15.0, 15.0, 1500.0, true),
+				TestPrice(NewDate(2020, 1, 3), 40.0, 40.0, 40.0, 4000.0, true),
+			})
+			p0, ok, err := r.PriceAt("EU", NewDate(2020, 1, 2))
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(p0, ShouldResemble, p[0])
+
+			m, err := r.Monthly("EU", Date{}, Date{})
+			So(err, ShouldBeNil)
+			So(len(m), ShouldEqual, 1)
+			So(m[0].Open, ShouldEqual, 15.0)
+			So(m[0].Close, ShouldEqual, 40.0)
+			So(m[0].CashVolume, ShouldEqual, 6000.0)
+		})
+
+		Convey("inverse pair", func() {
+			r.Currency = "USD"
+			p, err := r.Prices("CH")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, []PriceRow{
+				TestPrice(NewDate(2020, 1, 2), 20.0, 20.0, 20.0, 2000.0, true),
+				TestPrice(NewDate(2020, 1, 3), 40.0, 40.0, 40.0, 4000.0, true),
+			})
+		})
+
+		Convey("same or unknown currency", func() {
+			r.Currency = "USD"
+			p, err := r.Prices("US")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, prices)
+			p, err = r.Prices("XX")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, prices)
+		})
+
+		Convey("missing rates", func() {
+			r.Currency = "USD"
+			_, err := r.Prices("GB")
+			So(err, ShouldNotBeNil)
 		})
 	})
 }
@@ -0,0 +1,184 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"math"
+
+	"github.com/stockparfait/logging"
+)
+
+// MaxDrawdown computes the largest relative decline of the fully adjusted
+// price from its running peak over the list of consecutive resampled bars, as a
+// fraction in [0..1]. Only the opening price of the first bar and the closing
+// prices of the bars are considered, so the declines within a bar are ignored.
+func MaxDrawdown(rows []ResampledRow) float64 {
+	if len(rows) == 0 {
+		return 0.0
+	}
+	first := rows[0]
+	var peak float64
+	// Recompute open for full adjustment.
+	if first.CloseSplitAdjusted > 0.0 {
+		peak = float64(first.OpenSplitAdjusted) * float64(first.CloseFullyAdjusted) /
+			float64(first.CloseSplitAdjusted)
+	}
+	var drawdown float64
+	for _, r := range rows {
+		p := float64(r.CloseFullyAdjusted)
+		if p <= 0.0 {
+			continue
+		}
+		if p > peak {
+			peak = p
+			continue
+		}
+		if d := 1.0 - p/peak; d > drawdown {
+			drawdown = d
+		}
+	}
+	return drawdown
+}
+
+// barCorrelation computes the correlation of the log-profits of the fully
+// adjusted closing prices of two series of resampled bars of the given
+// frequency. A log-profit is included only when both series have the bars for
+// the same two consecutive periods. The result is not ok when there are fewer
+// than 2 such log-profits, or either of them is constant.
+func barCorrelation(freq Frequency, xs, ys []ResampledRow) (corr float64, ok bool) {
+	yCloses := make(map[Date]float32)
+	for _, y := range ys {
+		yCloses[freq.Period(y.DateClose)] = y.CloseFullyAdjusted
+	}
+	var n, sumX, sumY, sumXX, sumYY, sumXY float64
+	for i := 1; i < len(xs); i++ {
+		x0, x1 := xs[i-1].CloseFullyAdjusted, xs[i].CloseFullyAdjusted
+		y0, ok0 := yCloses[freq.Period(xs[i-1].DateClose)]
+		y1, ok1 := yCloses[freq.Period(xs[i].DateClose)]
+		if !ok0 || !ok1 || x0 <= 0.0 || x1 <= 0.0 || y0 <= 0.0 || y1 <= 0.0 {
+			continue
+		}
+		x := math.Log(float64(x1)) - math.Log(float64(x0))
+		y := math.Log(float64(y1)) - math.Log(float64(y0))
+		n++
+		sumX += x
+		sumY += y
+		sumXX += x * x
+		sumYY += y * y
+		sumXY += x * y
+	}
+	if n < 2 {
+		return 0.0, false
+	}
+	varX := sumXX/n - (sumX/n)*(sumX/n)
+	varY := sumYY/n - (sumY/n)*(sumY/n)
+	if varX <= 0.0 || varY <= 0.0 {
+		return 0.0, false
+	}
+	cov := sumXY/n - (sumX/n)*(sumY/n)
+	return cov / math.Sqrt(varX*varY), true
+}
+
+// intervalBars returns the resampled bars of the ticker for the interval, or
+// nil if they cannot be loaded.
+func (r *Reader) intervalBars(ctx context.Context, ticker string, i *Interval) []ResampledRow {
+	rows, err := r.Resampled(i.Frequency, ticker, i.Start, i.End)
+	if err != nil {
+		logging.Warningf(ctx, "failed to load resampled data for %s:\n%s",
+			ticker, err.Error())
+		return nil
+	}
+	return rows
+}
+
+// minPriceInRange checks the lowest unadjusted closing price of the bars.
+func (r *Reader) minPriceInRange(ctx context.Context, ticker string) bool {
+	if r.MinPrice == nil {
+		return true
+	}
+	rows := r.intervalBars(ctx, ticker, r.MinPrice)
+	if len(rows) == 0 {
+		return false
+	}
+	minPrice := math.Inf(1)
+	for _, m := range rows {
+		minPrice = math.Min(minPrice, float64(m.Close))
+	}
+	return r.MinPrice.ValueInRange(minPrice)
+}
+
+func (r *Reader) drawdownInRange(ctx context.Context, ticker string) bool {
+	if r.MaxDrawdown == nil {
+		return true
+	}
+	rows := r.intervalBars(ctx, ticker, r.MaxDrawdown)
+	if len(rows) == 0 {
+		return false
+	}
+	return r.MaxDrawdown.ValueInRange(MaxDrawdown(rows))
+}
+
+func (r *Reader) tradingDaysInRange(ctx context.Context, ticker string) bool {
+	if r.TradingDays == nil {
+		return true
+	}
+	rows := r.intervalBars(ctx, ticker, r.TradingDays)
+	var days int
+	for _, m := range rows {
+		days += int(m.NumSamples)
+	}
+	return r.TradingDays.ValueInRange(float64(days))
+}
+
+// zeroVolumeInRange checks the percentage of the trading days without volume.
+// The resampled bars only store the total volume, so the daily prices within
+// the interval are read instead, and a frequency other than the default is
+// rejected by Reader.InitMessage.
+func (r *Reader) zeroVolumeInRange(ctx context.Context, ticker string) bool {
+	if r.ZeroVolume == nil {
+		return true
+	}
+	prices, err := r.PricesRange(ticker, r.ZeroVolume.Start, r.ZeroVolume.End)
+	if err != nil {
+		logging.Warningf(ctx, "failed to load prices for %s:\n%s",
+			ticker, err.Error())
+		return false
+	}
+	prices = ComputeDaily(prices)
+	if len(prices) == 0 {
+		return false
+	}
+	var zeros int
+	for _, p := range prices {
+		if p.CashVolume == 0.0 {
+			zeros++
+		}
+	}
+	return r.ZeroVolume.ValueInRange(100.0 * float64(zeros) / float64(len(prices)))
+}
+
+func (r *Reader) correlationInRange(ctx context.Context, ticker string) bool {
+	if r.Correlation == nil {
+		return true
+	}
+	rows := r.intervalBars(ctx, ticker, r.Correlation)
+	benchmark := r.intervalBars(ctx, r.Benchmark, r.Correlation)
+	corr, ok := barCorrelation(r.Correlation.Frequency, rows, benchmark)
+	if !ok {
+		return false
+	}
+	return r.Correlation.ValueInRange(corr)
+}
@@ -0,0 +1,163 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stockparfait/testutil"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFilters(t *testing.T) {
+	t.Parallel()
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testfilters")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	bars := func(closes ...float32) []ResampledRow {
+		var res []ResampledRow
+		open := closes[0]
+		for i, c := range closes {
+			start := NewDate(2019, uint8(i+1), 1)
+			end := NewDate(2019, uint8(i+1), start.DaysInMonth())
+			res = append(res, TestResampled(start, end, open, c, c, 1000.0, true))
+			open = c
+		}
+		return res
+	}
+
+	Convey("MaxDrawdown works", t, func() {
+		So(MaxDrawdown(nil), ShouldEqual, 0.0)
+		So(MaxDrawdown(bars(10, 12, 14)), ShouldEqual, 0.0)
+		So(testutil.Round(MaxDrawdown(bars(10, 20, 15, 25, 10)), 5), ShouldEqual, 0.6)
+	})
+
+	Convey("barCorrelation works", t, func() {
+		corr, ok := barCorrelation(Monthly, bars(10, 20, 10, 20), bars(5, 10, 5, 10))
+		So(ok, ShouldBeTrue)
+		So(testutil.Round(corr, 5), ShouldEqual, 1.0)
+
+		corr, ok = barCorrelation(Monthly, bars(10, 20, 10, 20), bars(10, 5, 10, 5))
+		So(ok, ShouldBeTrue)
+		So(testutil.Round(corr, 5), ShouldEqual, -1.0)
+
+		_, ok = barCorrelation(Monthly, bars(10, 20, 10), bars(10, 10, 10))
+		So(ok, ShouldBeFalse) // constant benchmark
+
+		_, ok = barCorrelation(Monthly, bars(10, 20, 10), bars(10))
+		So(ok, ShouldBeFalse) // no overlap
+	})
+
+	Convey("Reader filters tickers by prices", t, func() {
+		ctx := context.Background()
+		dbName := "db"
+		tickers := map[string]TickerRow{
+			"A":   {},
+			"B":   {},
+			"SPY": {},
+		}
+		monthly := map[string][]ResampledRow{
+			"A":   bars(10, 20, 10, 20),
+			"B":   bars(2, 1, 2, 1),
+			"SPY": bars(100, 110, 100, 110),
+		}
+		monthly["B"] = monthly["B"][:3]
+		prices := func(volumes ...float32) []PriceRow {
+			var res []PriceRow
+			for i, v := range volumes {
+				res = append(res, TestPrice(NewDate(2019, 1, uint8(i+2)), 10.0, 10.0, 10.0, v, true))
+			}
+			return res
+		}
+		w := NewWriter(tmpdir, dbName)
+		So(w.WriteTickers(tickers), ShouldBeNil)
+		So(w.WriteMonthly(monthly), ShouldBeNil)
+		So(w.WritePrices("A", prices(1000, 1000, 1000, 1000)), ShouldBeNil)
+		So(w.WritePrices("B", prices(1000, 0, 0, 1000)), ShouldBeNil)
+		So(w.WritePrices("SPY", prices(1000, 1000, 1000, 0)), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
+
+		r := NewReader(tmpdir, dbName)
+		selected := func() []string {
+			ts, err := r.Tickers(ctx)
+			So(err, ShouldBeNil)
+			sort.Strings(ts)
+			return ts
+		}
+		val := func(x float64) *float64 { return &x }
+
+		Convey("min price", func() {
+			r.MinPrice = &Interval{Min: val(5)}
+			So(selected(), ShouldResemble, []string{"A", "SPY"})
+		})
+
+		Convey("max drawdown", func() {
+			r.MaxDrawdown = &Interval{Max: val(0.2)}
+			So(selected(), ShouldResemble, []string{"SPY"})
+		})
+
+		Convey("trading days", func() {
+			r.TradingDays = &Interval{Min: val(70)}
+			So(selected(), ShouldResemble, []string{"A", "SPY"})
+			r.TradingDays = &Interval{Min: val(70), End: NewDate(2019, 3, 31)}
+			So(selected(), ShouldResemble, []string{}) // only 60 days
+		})
+
+		Convey("zero volume", func() {
+			r.ZeroVolume = &Interval{Max: val(30)}
+			So(selected(), ShouldResemble, []string{"A", "SPY"})
+			r.ZeroVolume = &Interval{Max: val(30), End: NewDate(2019, 1, 4)}
+			So(selected(), ShouldResemble, []string{"A", "SPY"})
+			r.ZeroVolume = &Interval{Min: val(50)}
+			So(selected(), ShouldResemble, []string{"B"})
+		})
+
+		Convey("correlation", func() {
+			r.Benchmark = "SPY"
+			r.Correlation = &Interval{Min: val(0.5)}
+			So(selected(), ShouldResemble, []string{"A", "SPY"})
+			r.Correlation = &Interval{Max: val(0)}
+			So(selected(), ShouldResemble, []string{"B"})
+		})
+
+		Convey("zero volume does not support frequency", func() {
+			var r Reader
+			So(r.InitMessage(testutil.JSON(`{
+  "DB": "db",
+  "zero volume": {"max": 1, "frequency": "weekly"}
+}`)), ShouldNotBeNil)
+			So(r.InitMessage(testutil.JSON(`{
+  "DB": "db",
+  "zero volume": {"max": 1}
+}`)), ShouldBeNil)
+		})
+
+		Convey("correlation requires a benchmark", func() {
+			var r Reader
+			So(r.InitMessage(testutil.JSON(`{
+  "DB": "db",
+  "correlation": {"min": 0.5}
+}`)), ShouldNotBeNil)
+		})
+	})
+}
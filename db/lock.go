@@ -0,0 +1,131 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stockparfait/errors"
+)
+
+// A DB is protected by two advisory file locks:
+//
+//   <dbPath>/<db>/write.lock - held exclusively by a Writer from its first
+//                              write until Commit, so only one process at a
+//                              time can update the DB;
+//   <dbPath>/<db>/lock       - held exclusively by Writer.Commit while it swaps
+//                              and deletes snapshots, and shared by a Reader
+//                              while it reads a file.
+//
+// Thus, a long update blocks other writers but not readers, and a reader never
+// sees a snapshot being deleted from under it. The exclusive holder records its
+// process ID and command line in the lock file for the error messages.
+
+// DefaultLockTimeout is the default time to wait for a DB lock.
+const DefaultLockTimeout = 10 * time.Second
+
+const lockPollInterval = 20 * time.Millisecond
+
+func readLockFile(root string) string {
+	return filepath.Join(root, "lock")
+}
+
+func writeLockFile(root string) string {
+	return filepath.Join(root, "write.lock")
+}
+
+type fileLock struct {
+	f         *os.File
+	exclusive bool
+}
+
+// lockHolder describes the process holding the lock, as recorded in the file.
+func lockHolder(fileName string) string {
+	data, err := os.ReadFile(fileName)
+	if err != nil || len(data) == 0 {
+		return "a reader"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// lockFile acquires an advisory lock on the file, creating it if necessary, and
+// waits for at most the timeout for other holders to release it. A shared lock
+// only needs read access to the file, so that a read-only DB or the one owned by
+// another user can still be read. If such a lock file does not exist and cannot
+// be created for the lack of write access, no writer can hold it either, and the
+// result is a nil lock without an error.
+func lockFile(fileName string, exclusive bool, timeout time.Duration) (*fileLock, error) {
+	var f *os.File
+	var err error
+	if exclusive {
+		f, err = os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	} else {
+		f, err = os.Open(fileName)
+		if errors.Is(err, os.ErrNotExist) {
+			f, err = os.OpenFile(fileName, os.O_RDONLY|os.O_CREATE, 0644)
+			if err != nil && isReadOnly(err) {
+				return nil, nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to open lock file '%s'", fileName)
+	}
+	kind := "shared"
+	if exclusive {
+		kind = "exclusive"
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLock(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, errors.Annotate(err, "failed to acquire %s lock", kind)
+		}
+		if ok {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, errors.Reason(
+				"timed out after %s waiting for %s lock on '%s' held by %s",
+				timeout, kind, fileName, lockHolder(fileName))
+		}
+		time.Sleep(lockPollInterval)
+	}
+	if exclusive {
+		holder := fmt.Sprintf("pid %d (%s)\n", os.Getpid(), strings.Join(os.Args, " "))
+		if err := f.Truncate(0); err == nil {
+			f.WriteAt([]byte(holder), 0)
+		}
+	}
+	return &fileLock{f: f, exclusive: exclusive}, nil
+}
+
+// Unlock releases the lock. A nil lock is a no-op.
+func (l *fileLock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+	defer l.f.Close()
+	if l.exclusive {
+		l.f.Truncate(0)
+	}
+	return unlockFile(l.f)
+}
@@ -0,0 +1,38 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package db
+
+import (
+	"os"
+
+	"github.com/stockparfait/errors"
+)
+
+// tryLock always succeeds on platforms without advisory file locking, with the
+// same API as its flock'ed version.
+func tryLock(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+// isReadOnly checks if the error is due to the lack of write access.
+func isReadOnly(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
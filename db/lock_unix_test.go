@@ -0,0 +1,159 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLock(t *testing.T) {
+	t.Parallel()
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testlock")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	Convey("lockFile works", t, func() {
+		fileName := filepath.Join(tmpdir, "lock")
+		timeout := 50 * time.Millisecond
+		pid := fmt.Sprintf("pid %d", os.Getpid())
+
+		s1, err := lockFile(fileName, false, timeout)
+		So(err, ShouldBeNil)
+		s2, err := lockFile(fileName, false, timeout)
+		So(err, ShouldBeNil)
+
+		_, err = lockFile(fileName, true, timeout)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "held by a reader")
+
+		So(s1.Unlock(), ShouldBeNil)
+		So(s2.Unlock(), ShouldBeNil)
+
+		x, err := lockFile(fileName, true, timeout)
+		So(err, ShouldBeNil)
+		_, err = lockFile(fileName, false, timeout)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "held by "+pid)
+		So(x.Unlock(), ShouldBeNil)
+
+		s1, err = lockFile(fileName, false, timeout)
+		So(err, ShouldBeNil)
+		So(s1.Unlock(), ShouldBeNil)
+	})
+
+	Convey("DB locks", t, func() {
+		dbName := "locked"
+		tickers := map[string]TickerRow{"A": {}}
+		w := NewWriter(tmpdir, dbName)
+		So(w.WriteTickers(tickers), ShouldBeNil)
+
+		Convey("only one writer at a time", func() {
+			w2 := NewWriter(tmpdir, dbName)
+			w2.LockTimeout = 50 * time.Millisecond
+			err := w2.WriteTickers(tickers)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, fmt.Sprintf("pid %d", os.Getpid()))
+
+			So(w.Commit(), ShouldBeNil)
+			w2 = NewWriter(tmpdir, dbName)
+			So(w2.WriteTickers(tickers), ShouldBeNil)
+			So(w2.Commit(), ShouldBeNil)
+		})
+
+		Convey("readers are not blocked by a writer until commit", func() {
+			So(w.Commit(), ShouldBeNil)
+			So(w.WriteTickers(tickers), ShouldBeNil)
+			r := NewReader(tmpdir, dbName)
+			_, err := r.AllTickerRows()
+			So(err, ShouldBeNil)
+
+			l, err := lockFile(readLockFile(filepath.Join(tmpdir, dbName)), true, 0)
+			So(err, ShouldBeNil)
+			r = NewReader(tmpdir, dbName)
+			r.LockTimeout = 0.05
+			_, err = r.AllTickerRows()
+			So(err, ShouldNotBeNil)
+
+			w.LockTimeout = 50 * time.Millisecond
+			So(w.Commit(), ShouldNotBeNil)
+			So(l.Unlock(), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+		})
+
+		Convey("Begin locks before reading the staged data", func() {
+			So(w.Commit(), ShouldBeNil)
+			r, err := w.Begin()
+			So(err, ShouldBeNil)
+			So(w.WriteTickers(map[string]TickerRow{"B": {}}), ShouldBeNil)
+			rows, err := r.AllTickerRows()
+			So(err, ShouldBeNil)
+			So(rows, ShouldResemble, map[string]TickerRow{"B": {}})
+
+			w2 := NewWriter(tmpdir, dbName)
+			w2.LockTimeout = 50 * time.Millisecond
+			_, err = w2.Begin()
+			So(err, ShouldNotBeNil)
+
+			So(w.Rollback(), ShouldBeNil)
+			w2 = NewWriter(tmpdir, dbName)
+			r, err = w2.Begin()
+			So(err, ShouldBeNil)
+			rows, err = r.AllTickerRows()
+			So(err, ShouldBeNil)
+			So(rows, ShouldResemble, tickers)
+			So(w2.Rollback(), ShouldBeNil)
+			So(w2.Rollback(), ShouldBeNil) // no-op
+		})
+
+		readOnlyConvey := Convey
+		if os.Geteuid() == 0 {
+			readOnlyConvey = SkipConvey // permissions do not apply to root
+		}
+		readOnlyConvey("a read-only DB is readable", func() {
+			So(w.Commit(), ShouldBeNil)
+			root := filepath.Join(tmpdir, dbName)
+			lockName := readLockFile(root)
+			So(os.Chmod(lockName, 0444), ShouldBeNil)
+			So(os.Chmod(root, 0555), ShouldBeNil)
+			defer os.Chmod(root, 0755)
+
+			r := NewReader(tmpdir, dbName)
+			rows, err := r.AllTickerRows()
+			So(err, ShouldBeNil)
+			So(rows, ShouldResemble, tickers)
+
+			// Without a lock file which cannot be created, reads are not locked.
+			So(os.Chmod(root, 0755), ShouldBeNil)
+			So(os.Remove(lockName), ShouldBeNil)
+			So(os.Chmod(root, 0555), ShouldBeNil)
+
+			r = NewReader(tmpdir, dbName)
+			rows, err = r.AllTickerRows()
+			So(err, ShouldBeNil)
+			So(rows, ShouldResemble, tickers)
+		})
+	})
+}
@@ -0,0 +1,33 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package db
+
+import (
+	"os"
+
+	"github.com/stockparfait/errors"
+)
+
+// mmapFile reads the entire file into memory on platforms without mmap
+// support, with the same API as its mmap'ed version.
+func mmapFile(fileName string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "failed to read '%s'", fileName)
+	}
+	return data, func() error { return nil }, nil
+}
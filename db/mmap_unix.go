@@ -0,0 +1,48 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package db
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/stockparfait/errors"
+)
+
+// mmapFile maps the entire file into memory read-only. The returned function
+// must be called to unmap the data when it's no longer needed.
+func mmapFile(fileName string) ([]byte, func() error, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "failed to open '%s'", fileName)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "failed to stat '%s'", fileName)
+	}
+	if info.Size() == 0 {
+		return []byte{}, func() error { return nil }, nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()),
+		syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "failed to mmap '%s'", fileName)
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -53,6 +54,7 @@ func parseTime(s string) (time.Time, error) {
 		"2006-01-02 15:04:05.999",
 		"2006-01-02T15:04:05.999",
 		"2006-01-02T15:04:05.999Z",
+		"2006-01-02T15:04:05.999Z07:00", // the local time is preserved
 		"2006-01-02 15:04:05",
 		"2006-01-02T15:04:05",
 		"2006-01-02",
@@ -304,6 +306,16 @@ func (d Date) Date() Date {
 	return NewDate(d.Year(), d.Month(), d.Day())
 }
 
+// EndOfDay returns the last millisecond of the day if d has no time of day, and
+// d itself otherwise. It turns a date used as an inclusive end of a range into
+// a bound which includes all the intraday samples of that day.
+func (d Date) EndOfDay() Date {
+	if d.IsZero() || !d.Time.IsZero() {
+		return d
+	}
+	return NewDatetime(d.Year(), d.Month(), d.Day(), 23, 59, 59, 999)
+}
+
 // Monday returns a new Date of the Monday midnight of the current date's
 // week. Note: week is assumed to start on Sunday, so Monday(d=Sunday) returns
 // the next day.
@@ -323,6 +335,11 @@ func (d Date) QuarterStart() Date {
 	return NewDate(d.Year(), (d.Month()-1)/3*3+1, 1)
 }
 
+// YearStart returns January 1st of the year of the current date.
+func (d Date) YearStart() Date {
+	return NewDate(d.Year(), 1, 1)
+}
+
 // Before compares two Date objects for strict inequality (self < d2).
 func (d Date) Before(d2 Date) bool {
 	return lessLex([]int{int(d.Year()), int(d.Month()), int(d.Day()), int(d.Time)},
@@ -425,12 +442,14 @@ func (d Date) InRange(start, end Date) bool {
 // TickerRow is a row in the tickers table.
 type TickerRow struct {
 	Source      string // where the ticker was downloaded, e.g. NDL table name
+	ID          string // permanent security ID which survives renames; may be empty
 	Exchange    string // the primary exchange trading this ticker
 	Name        string // the company name
 	Category    string
 	Sector      string
 	Industry    string
 	Location    string
+	Currency    string // of prices and cash volume, e.g. "USD"; may be empty
 	SECFilings  string // URL
 	CompanySite string // URL
 	Active      bool   // ticker is listed at the last price date
@@ -465,6 +484,8 @@ func (r TickerTableRow) CSV() []string {
 		r.SECFilings,
 		r.CompanySite,
 		bool2str(r.Active),
+		r.Currency,
+		r.ID,
 	}
 }
 
@@ -482,9 +503,120 @@ func TickerRowHeader() []string {
 		"SEC Filings",
 		"Company Site",
 		"Active",
+		"Currency",
+		"ID",
 	}
 }
 
+// TickerHistoryRow is a row in the ticker history table: the attributes of a
+// ticker which were in effect until the given date, exclusive.
+type TickerHistoryRow struct {
+	Until Date
+	Row   TickerRow
+}
+
+// sameAttributes checks if the two rows are the same except for the Active
+// status, which is tracked by the listing actions and prices, and the ID, which
+// is not an attribute of the security.
+func sameAttributes(x, y TickerRow) bool {
+	x.Active = y.Active
+	x.ID = y.ID
+	return x == y
+}
+
+// UpdateTickerHistory records in history the old rows of the tickers whose
+// attributes (other than Active) differ in the new rows, as being in effect
+// until the date. The history rows of each ticker remain sorted by date,
+// assuming that date is later than the existing ones. Returns the number of
+// the recorded changes.
+func UpdateTickerHistory(history map[string][]TickerHistoryRow, old, new map[string]TickerRow, date Date) int {
+	n := 0
+	for t, newRow := range new {
+		oldRow, ok := old[t]
+		if !ok || sameAttributes(oldRow, newRow) {
+			continue
+		}
+		history[t] = append(history[t], TickerHistoryRow{Until: date, Row: oldRow})
+		n++
+	}
+	return n
+}
+
+// SymbolRow is a row in the symbol history table: the security with the
+// permanent ID traded under the symbol from Start (inclusive; zero means since
+// the beginning) until End (exclusive). The current symbols are the tickers
+// themselves, and are not included.
+type SymbolRow struct {
+	Start Date
+	End   Date
+	ID    string
+}
+
+// Rename of a security's symbol from Old to New effective on the Date.
+type Rename struct {
+	Date Date
+	Old  string
+	New  string
+}
+
+// UpdateSymbols records the renames in the symbol history {symbol -> rows},
+// with the permanent IDs of the current tickers. A rename to a symbol which was
+// itself renamed later is followed to the current ticker. Renames of securities
+// without an ID and the already recorded renames are skipped. The Start dates of
+// all rows are then set from the previous renames of the same security, and the
+// rows of each symbol are sorted by date. Returns the number of added rows.
+func UpdateSymbols(symbols map[string][]SymbolRow, tickers map[string]TickerRow, renames []Rename) int {
+	ids := make(map[string]string) // symbol -> ID, going back in time
+	for t, row := range tickers {
+		if row.ID != "" {
+			ids[t] = row.ID
+		}
+	}
+	sorted := make([]Rename, len(renames))
+	copy(sorted, renames)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[j].Date.Before(sorted[i].Date)
+	})
+	n := 0
+	for _, r := range sorted {
+		id, ok := ids[r.New]
+		if !ok {
+			continue
+		}
+		ids[r.Old] = id
+		row := SymbolRow{End: r.Date, ID: id}
+		found := false
+		for _, x := range symbols[r.Old] {
+			if x.End == row.End && x.ID == row.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			symbols[r.Old] = append(symbols[r.Old], row)
+			n++
+		}
+	}
+	ends := make(map[string][]Date) // ID -> End dates of all its symbols
+	for _, rows := range symbols {
+		for _, x := range rows {
+			ends[x.ID] = append(ends[x.ID], x.End)
+		}
+	}
+	for _, rows := range symbols {
+		for i := range rows {
+			rows[i].Start = Date{}
+			for _, e := range ends[rows[i].ID] {
+				if e.Before(rows[i].End) && rows[i].Start.Before(e) {
+					rows[i].Start = e
+				}
+			}
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].End.Before(rows[j].End) })
+	}
+	return n
+}
+
 func bool2str(x bool) string {
 	if x {
 		return "TRUE"
@@ -508,7 +640,7 @@ func uint2str(x uint16) string {
 // CloseUnadjusted() and Active() methods to get the corresponding values.
 //
 // Price and cash volume are assumed to be in the stock's native currency,
-// e.g. dollar for the US stocks.
+// e.g. dollar for the US stocks, as set in TickerRow.Currency.
 type PriceRow struct {
 	Date               Date
 	Open               float32 // all other prices are unadjusted
@@ -613,6 +745,19 @@ func (p *PriceRow) SetActive(active bool) {
 	}
 }
 
+// Convert the prices and the cash volume to another currency at the given
+// rate, the price of one native currency unit in the target currency.
+func (p PriceRow) Convert(rate float32) PriceRow {
+	p.Open *= rate
+	p.High *= rate
+	p.Low *= rate
+	p.Close *= rate
+	p.CloseSplitAdjusted *= rate
+	p.CloseFullyAdjusted *= rate
+	p.CashVolume *= rate
+	return p
+}
+
 // TestPrice creates a PriceRow instance for use in tests. It uses the closing
 // price to assign the other OHL prices.
 func TestPrice(date Date, close, splitAdj, fullyAdj, dv float32, active bool) PriceRow {
@@ -659,6 +804,19 @@ type ResampledRow struct {
 
 var _ table.Row = &ResampledRow{}
 
+// Convert the prices and the cash volume to another currency: the open prices
+// at the openRate, and the close prices and the cash volume at the closeRate.
+func (r ResampledRow) Convert(openRate, closeRate float32) ResampledRow {
+	r.Open *= openRate
+	r.OpenSplitAdjusted *= openRate
+	r.OpenFullyAdjusted *= openRate
+	r.Close *= closeRate
+	r.CloseSplitAdjusted *= closeRate
+	r.CloseFullyAdjusted *= closeRate
+	r.CashVolume *= closeRate
+	return r
+}
+
 func (r ResampledRow) CSV() []string {
 	return []string{
 		float2str(r.Open),
@@ -711,8 +869,79 @@ func TestResampled(dateOpen, dateClose Date, open, close, adj, dv float32, activ
 	}
 }
 
+// Frequency of the resampled bars. Monthly is the default (zero) value.
+type Frequency uint8
+
+var _ message.Message = (*Frequency)(nil)
+
+// Values of Frequency.
+const (
+	Monthly Frequency = iota
+	Weekly
+	Quarterly
+	Yearly
+)
+
+// numFrequencies is the number of Frequency values.
+const numFrequencies = int(Yearly) + 1
+
+// Frequencies lists all the supported values of Frequency.
+var Frequencies = []Frequency{Weekly, Monthly, Quarterly, Yearly}
+
+var frequency2string = map[Frequency]string{
+	Weekly:    "weekly",
+	Monthly:   "monthly",
+	Quarterly: "quarterly",
+	Yearly:    "yearly",
+}
+
+// InitMessage implements message.Message.
+func (f *Frequency) InitMessage(js any) error {
+	switch v := js.(type) {
+	case map[string]any: // default value
+		*f = Monthly
+	case string:
+		for freq, s := range frequency2string {
+			if s == v {
+				*f = freq
+				return nil
+			}
+		}
+		return errors.Reason("unsupported frequency '%s'", v)
+	default:
+		return errors.Reason("unexpected JSON type: %T", js)
+	}
+	return nil
+}
+
+// String prints Frequency. It's a value method, so it prints correctly in
+// fmt.Printf.
+func (f Frequency) String() string {
+	if s, ok := frequency2string[f]; ok {
+		return s
+	}
+	return "invalid"
+}
+
+// Period returns the start of the period of the given frequency which
+// contains the date. It is intended as the period function for
+// ComputeResampled.
+func (f Frequency) Period(d Date) Date {
+	switch f {
+	case Weekly:
+		return d.Monday()
+	case Monthly:
+		return d.MonthStart()
+	case Quarterly:
+		return d.QuarterStart()
+	case Yearly:
+		return d.YearStart()
+	}
+	panic(errors.Reason("unsupported frequency: %d", f))
+}
+
 // DailyVolatility computes the average daily absolute log-profit from the list
-// of consecutive monthly bars.
+// of consecutive resampled bars of any frequency.
 func DailyVolatility(rows []ResampledRow) (volatility float64, samples uint16) {
 	absLogProfit := func(x, y float32) float64 {
 		diff := math.Log(float64(x)) - math.Log(float64(y))
@@ -740,13 +969,298 @@ func DailyVolatility(rows []ResampledRow) (volatility float64, samples uint16) {
 	return
 }
 
+// ActionType is the enum for the corporate actions.
+type ActionType uint8
+
+// Values of ActionType.
+const (
+	UnknownAction  ActionType = iota
+	SplitAction               // Value: new shares per each original share
+	DividendAction            // Value: cash dividend per share
+	SpinoffAction             // Value: cash value of spunoff shares per share
+	ListedAction              //
+	DelistedAction            //
+	AcquiredAction            // ContraTicker: the acquirer, if known
+)
+
+var action2string = map[ActionType]string{
+	UnknownAction:  "unknown",
+	SplitAction:    "split",
+	DividendAction: "dividend",
+	SpinoffAction:  "spinoff",
+	ListedAction:   "listed",
+	DelistedAction: "delisted",
+	AcquiredAction: "acquired",
+}
+
+// String representation of the action type.
+func (a ActionType) String() string {
+	if s, ok := action2string[a]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// NewActionType parses the string representation of the action type. An
+// unrecognized string is an error.
+func NewActionType(s string) (ActionType, error) {
+	for a, str := range action2string {
+		if str == s {
+			return a, nil
+		}
+	}
+	return UnknownAction, errors.Reason("unknown action type: '%s'", s)
+}
+
+// ActionRow is a row in the actions table, a corporate action such as a split,
+// dividend or a delisting.
+type ActionRow struct {
+	Date         Date
+	Type         ActionType
+	Value        float32 // depends on Type
+	ContraTicker string  // e.g. spunoff company or acquirer; may be empty
+}
+
+var _ table.Row = ActionRow{}
+
+// TestAction creates an ActionRow for use in tests.
+func TestAction(date Date, tp ActionType, value float32, contra string) ActionRow {
+	return ActionRow{
+		Date:         date,
+		Type:         tp,
+		Value:        value,
+		ContraTicker: contra,
+	}
+}
+
+func ActionRowHeader() []string {
+	return []string{
+		"Date",
+		"Action",
+		"Value",
+		"Contra Ticker",
+	}
+}
+
+func (a ActionRow) CSV() []string {
+	return []string{
+		a.Date.String(),
+		a.Type.String(),
+		float2str(a.Value),
+		a.ContraTicker,
+	}
+}
+
+// FXRow is a row in the FX rates table of a currency pair such as "EURUSD": the
+// price of one unit of the base currency (EUR) in the quote currency (USD) at
+// the close of the day.
+type FXRow struct {
+	Date Date
+	Rate float32
+}
+
+var _ table.Row = FXRow{}
+
+// FXPair is the name of the currency pair in the FX rates table.
+func FXPair(base, quote string) string {
+	return base + quote
+}
+
+func FXRowHeader() []string {
+	return []string{"Date", "Rate"}
+}
+
+func (f FXRow) CSV() []string {
+	return []string{f.Date.String(), float2str(f.Rate)}
+}
+
+// UniverseSnapshot is the membership of a universe from the Date until the
+// next snapshot. Zero Date means since the beginning.
+type UniverseSnapshot struct {
+	Date    Date
+	Tickers []string
+}
+
+// Universe is a named set of tickers stored in the DB. It is defined by the
+// dated membership snapshots, a constraint expression, or both, in which case
+// the members must also satisfy the expression.
+type Universe struct {
+	Snapshots []UniverseSnapshot // sorted by date
+	Filter    string             // Expr in JSON; empty means no constraints
+}
+
+// Members of the universe on the date, that is in the latest snapshot on or
+// before the date, or in the latest snapshot for zero date. ok is false if the
+// universe has no snapshots, and thus its membership is not restricted.
+func (u Universe) Members(date Date) (tickers []string, ok bool) {
+	s, ok := u.snapshot(date)
+	return s.Tickers, ok
+}
+
+// snapshot in effect on the date, as in Members. It is an empty snapshot with
+// a zero date if the date precedes all the snapshots.
+func (u Universe) snapshot(date Date) (s UniverseSnapshot, ok bool) {
+	if len(u.Snapshots) == 0 {
+		return UniverseSnapshot{}, false
+	}
+	if date.IsZero() {
+		return u.Snapshots[len(u.Snapshots)-1], true
+	}
+	for i := len(u.Snapshots) - 1; i >= 0; i-- {
+		if !u.Snapshots[i].Date.After(date) {
+			return u.Snapshots[i], true
+		}
+	}
+	return UniverseSnapshot{Tickers: []string{}}, true
+}
+
+// AddSnapshots merges the snapshots into the universe, replacing the existing
+// ones with the same dates.
+func (u *Universe) AddSnapshots(snapshots ...UniverseSnapshot) {
+	for _, s := range snapshots {
+		i := sort.Search(len(u.Snapshots), func(i int) bool {
+			return !u.Snapshots[i].Date.Before(s.Date)
+		})
+		if i < len(u.Snapshots) && u.Snapshots[i].Date == s.Date {
+			u.Snapshots[i] = s
+			continue
+		}
+		u.Snapshots = append(u.Snapshots, UniverseSnapshot{})
+		copy(u.Snapshots[i+1:], u.Snapshots[i:])
+		u.Snapshots[i] = s
+	}
+}
+
+// Dimension of the fundamentals data: as reported (AR) or most recent (MR,
+// including restatements), quarterly (Q), annual (Y) or trailing twelve months
+// (T).
+type Dimension uint8
+
+// Values of Dimension.
+const (
+	UnknownDimension Dimension = iota
+	ARQ
+	ARY
+	ART
+	MRQ
+	MRY
+	MRT
+)
+
+var dimension2string = map[Dimension]string{
+	UnknownDimension: "unknown",
+	ARQ:              "ARQ",
+	ARY:              "ARY",
+	ART:              "ART",
+	MRQ:              "MRQ",
+	MRY:              "MRY",
+	MRT:              "MRT",
+}
+
+// String representation of the dimension.
+func (d Dimension) String() string {
+	if s, ok := dimension2string[d]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// NewDimension parses the string representation of the dimension. An
+// unrecognized string is an error.
+func NewDimension(s string) (Dimension, error) {
+	for d, str := range dimension2string {
+		if d != UnknownDimension && str == s {
+			return d, nil
+		}
+	}
+	return UnknownDimension, errors.Reason("unknown dimension: '%s'", s)
+}
+
+// FundamentalsRow is a row in the fundamentals table: a set of core financial
+// metrics of a company for a single reporting period. Cash values are in the
+// reporting currency of the company.
+type FundamentalsRow struct {
+	Dimension         Dimension
+	DateKey           Date // when the data became known, e.g. SEC filing date
+	ReportPeriod      Date // the end of the fiscal reporting period
+	Revenue           float32
+	CostOfRevenue     float32
+	GrossProfit       float32
+	OperatingIncome   float32
+	NetIncome         float32
+	EBITDA            float32
+	EPS               float32
+	EPSDiluted        float32
+	DividendsPerShare float32
+	OperatingCashFlow float32
+	FreeCashFlow      float32
+	Assets            float32
+	Liabilities       float32
+	Equity            float32
+	Debt              float32
+	Cash              float32
+	Shares            float32 // weighted average shares outstanding
+	MarketCap         float32
+	EV                float32 // enterprise value
+	PE                float32 // price to earnings ratio
+	PS                float32 // price to sales ratio
+	PB                float32 // price to book ratio
+	GrossMargin       float32
+	NetMargin         float32
+	ROE               float32 // return on average equity
+}
+
+// TestFundamentals creates a FundamentalsRow for use in tests.
+func TestFundamentals(dim Dimension, dateKey, reportPeriod Date, revenue, netIncome float32) FundamentalsRow {
+	return FundamentalsRow{
+		Dimension:    dim,
+		DateKey:      dateKey,
+		ReportPeriod: reportPeriod,
+		Revenue:      revenue,
+		NetIncome:    netIncome,
+	}
+}
+
+// StorageFormat of the price and resampled tables in the DB.
+type StorageFormat string
+
+const (
+	// GobFormat stores prices as a gob-encoded slice per ticker, and each
+	// resampled table as a single gob-encoded map. This is the default.
+	GobFormat StorageFormat = "gob"
+	// ColumnarFormat stores both prices and resampled bars as memory-mapped
+	// fixed-width columnar files per ticker, which allows reading a date range
+	// without decoding the entire history.
+	ColumnarFormat StorageFormat = "columnar"
+)
+
+// NewStorageFormat validates the format name. Empty string is the default
+// GobFormat.
+func NewStorageFormat(s string) (StorageFormat, error) {
+	switch StorageFormat(s) {
+	case "", GobFormat:
+		return GobFormat, nil
+	case ColumnarFormat:
+		return ColumnarFormat, nil
+	}
+	return "", errors.Reason("unsupported storage format: '%s'", s)
+}
+
 // Metadata is the schema for the metadata.json file.
 type Metadata struct {
-	Start      Date `json:"start"` // the earliest available price date
-	End        Date `json:"end"`   // the latest available price date
-	NumTickers int  `json:"num_tickers"`
-	NumPrices  int  `json:"num_prices"`  // daily price samples
-	NumMonthly int  `json:"num_monthly"` // monthly price samples
+	Start           Date          `json:"start"` // the earliest available price date
+	End             Date          `json:"end"`   // the latest available price date
+	NumTickers      int           `json:"num_tickers"`
+	NumPrices       int           `json:"num_prices"`  // daily or intraday price samples
+	NumMonthly      int           `json:"num_monthly"` // monthly price samples
+	NumWeekly       int           `json:"num_weekly"`
+	NumQuarterly    int           `json:"num_quarterly"`
+	NumYearly       int           `json:"num_yearly"`
+	NumActions      int           `json:"num_actions"`
+	NumFX           int           `json:"num_fx,omitempty"`   // FX rates of all pairs
+	NumFundamentals int           `json:"num_fundamentals"`   // all dimensions
+	Format          StorageFormat `json:"format,omitempty"`   // default: gob
+	Intraday        bool          `json:"intraday,omitempty"` // some prices are intraday bars
 }
 
 func (m *Metadata) UpdateTickers(tickers map[string]TickerRow) {
@@ -755,6 +1269,9 @@ func (m *Metadata) UpdateTickers(tickers map[string]TickerRow) {
 
 func (m *Metadata) UpdatePrices(prices []PriceRow) {
 	m.NumPrices += len(prices)
+	if IsIntraday(prices) {
+		m.Intraday = true
+	}
 	for _, p := range prices {
 		if m.Start.IsZero() || m.Start.After(p.Date) {
 			m.Start = p.Date
@@ -766,9 +1283,42 @@ func (m *Metadata) UpdatePrices(prices []PriceRow) {
 }
 
 func (m *Metadata) UpdateMonthly(monthly map[string][]ResampledRow) {
-	m.NumMonthly = 0
-	for _, ms := range monthly {
-		m.NumMonthly += len(ms)
+	m.UpdateResampled(Monthly, monthly)
+}
+
+// UpdateResampled sets the number of samples for the given frequency.
+func (m *Metadata) UpdateResampled(freq Frequency, rows map[string][]ResampledRow) {
+	n := 0
+	for _, rs := range rows {
+		n += len(rs)
+	}
+	switch freq {
+	case Weekly:
+		m.NumWeekly = n
+	case Monthly:
+		m.NumMonthly = n
+	case Quarterly:
+		m.NumQuarterly = n
+	case Yearly:
+		m.NumYearly = n
+	}
+}
+
+func (m *Metadata) UpdateFundamentals(rows []FundamentalsRow) {
+	m.NumFundamentals += len(rows)
+}
+
+func (m *Metadata) UpdateActions(actions map[string][]ActionRow) {
+	m.NumActions = 0
+	for _, as := range actions {
+		m.NumActions += len(as)
+	}
+}
+
+func (m *Metadata) UpdateFX(rates map[string][]FXRow) {
+	m.NumFX = 0
+	for _, rs := range rates {
+		m.NumFX += len(rs)
 	}
 }
 
@@ -164,6 +164,14 @@ func TestSchema(t *testing.T) {
 			So(NewDatetime(2019, 1, 2, 3, 4, 5, 123).Date(), ShouldResemble, NewDate(2019, 1, 2))
 		})
 
+		Convey("EndOfDay works correctly", func() {
+			So(NewDate(2019, 1, 2).EndOfDay(), ShouldResemble,
+				NewDatetime(2019, 1, 2, 23, 59, 59, 999))
+			d := NewDatetime(2019, 1, 2, 3, 4, 5, 123)
+			So(d.EndOfDay(), ShouldResemble, d)
+			So(Date{}.EndOfDay(), ShouldResemble, Date{})
+		})
+
 		Convey("Monday works correctly", func() {
 			// Jan 2, 2019 is Wednesday.
 			So(NewDate(2019, 1, 2).Monday(), ShouldResemble, NewDate(2018, 12, 31))
@@ -181,29 +189,57 @@ func TestSchema(t *testing.T) {
 			So(NewDate(2018, 8, 14).QuarterStart(), ShouldResemble, NewDate(2018, 7, 1))
 			So(NewDate(2018, 11, 14).QuarterStart(), ShouldResemble, NewDate(2018, 10, 1))
 		})
+
+		Convey("YearStart works correctly", func() {
+			So(NewDate(2018, 11, 14).YearStart(), ShouldResemble, NewDate(2018, 1, 1))
+		})
+	})
+
+	Convey("Frequency", t, func() {
+		Convey("works as Message", func() {
+			var f Frequency
+			So(f.InitMessage("quarterly"), ShouldBeNil)
+			So(f, ShouldEqual, Quarterly)
+			So(f.String(), ShouldEqual, "quarterly")
+
+			So(f.InitMessage(map[string]any{}), ShouldBeNil)
+			So(f, ShouldEqual, Monthly)
+
+			So(f.InitMessage("daily"), ShouldNotBeNil)
+		})
+
+		Convey("Period works", func() {
+			d := NewDate(2019, 5, 15) // Wednesday
+			So(Weekly.Period(d), ShouldResemble, NewDate(2019, 5, 13))
+			So(Monthly.Period(d), ShouldResemble, NewDate(2019, 5, 1))
+			So(Quarterly.Period(d), ShouldResemble, NewDate(2019, 4, 1))
+			So(Yearly.Period(d), ShouldResemble, NewDate(2019, 1, 1))
+		})
 	})
 
 	Convey("TickerRow", t, func() {
 		Convey("TickerRowHeader works", func() {
-			So(len(TickerRowHeader()), ShouldEqual, 11)
+			So(len(TickerRowHeader()), ShouldEqual, 13)
 		})
 
 		Convey("CSV works", func() {
 			t := TickerRow{
 				Source:      "source",
+				ID:          "123",
 				Exchange:    "exch",
 				Name:        "My name is",
 				Category:    "cat",
 				Sector:      "sec",
 				Industry:    "ind",
 				Location:    "there",
+				Currency:    "USD",
 				SECFilings:  "click",
 				CompanySite: "http:",
 				Active:      true,
 			}
 			So(t.Row("TK").CSV(), ShouldResemble, []string{
 				"TK", "source", "exch", "My name is", "cat", "sec", "ind",
-				"there", "click", "http:", "TRUE",
+				"there", "click", "http:", "TRUE", "USD", "123",
 			})
 		})
 	})
@@ -294,6 +330,27 @@ func TestSchema(t *testing.T) {
 		})
 	})
 
+	Convey("ActionRow", t, func() {
+		Convey("ActionType converts to and from string", func() {
+			So(SpinoffAction.String(), ShouldEqual, "spinoff")
+			a, err := NewActionType("delisted")
+			So(err, ShouldBeNil)
+			So(a, ShouldEqual, DelistedAction)
+			_, err = NewActionType("foo")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("ActionRowHeader", func() {
+			So(len(ActionRowHeader()), ShouldEqual, 4)
+		})
+
+		Convey("CSV", func() {
+			a := TestAction(NewDate(2019, 1, 2), SpinoffAction, 12.5, "XYZ")
+			So(a.CSV(), ShouldResemble, []string{
+				"2019-01-02", "spinoff", "12.5", "XYZ"})
+		})
+	})
+
 	Convey("Time methods work", t, func() {
 		Convey("marshals to JSON correctly", func() {
 			t := NewTime(2019, 1, 5, 13, 30, 45)
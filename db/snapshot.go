@@ -0,0 +1,263 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stockparfait/errors"
+)
+
+// The DB data is stored in immutable snapshots:
+//
+//   <dbPath>/<db>/snapshots/<name>/  - a complete copy of the DB
+//   <dbPath>/<db>/current            - symlink to the latest snapshot
+//
+// Writer stages all the writes into a new snapshot directory which shares the
+// unmodified files with the current snapshot through hard links, and Commit
+// atomically swaps the "current" symlink. DBs created before snapshots were
+// introduced store the data directly in <dbPath>/<db>; such DBs are still
+// readable, and are migrated into a snapshot at the first Commit.
+
+// DefaultKeepSnapshots is the default number of the most recent snapshots
+// retained by Writer.Commit.
+const DefaultKeepSnapshots = 3
+
+const (
+	snapshotsDirName = "snapshots"
+	currentLinkName  = "current"
+	stagingSuffix    = ".tmp"
+	// Snapshot names sort in the chronological order.
+	snapshotNameLayout = "20060102-150405.000000"
+)
+
+func snapshotsDir(root string) string {
+	return filepath.Join(root, snapshotsDirName)
+}
+
+func snapshotDir(root, name string) string {
+	return filepath.Join(snapshotsDir(root), name)
+}
+
+func currentLink(root string) string {
+	return filepath.Join(root, currentLinkName)
+}
+
+// currentSnapshot name, or "" if the DB has no snapshots.
+func currentSnapshot(root string) (string, error) {
+	target, err := os.Readlink(currentLink(root))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Annotate(err, "failed to read '%s'", currentLink(root))
+	}
+	return filepath.Base(target), nil
+}
+
+// Snapshots returns the names of the committed snapshots of the DB sorted from
+// the oldest to the newest. A DB without snapshots returns an empty list.
+func Snapshots(dbPath, db string) ([]string, error) {
+	dir := snapshotsDir(filepath.Join(dbPath, db))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Annotate(err, "failed to read '%s'", dir)
+	}
+	var res []string
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasSuffix(e.Name(), stagingSuffix) {
+			res = append(res, e.Name())
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// newSnapshotName which is not yet used in the DB.
+func newSnapshotName(root string) string {
+	name := time.Now().UTC().Format(snapshotNameLayout)
+	for i := 1; ; i++ {
+		_, err := os.Lstat(snapshotDir(root, name))
+		_, errStaging := os.Lstat(snapshotDir(root, name+stagingSuffix))
+		if os.IsNotExist(err) && os.IsNotExist(errStaging) {
+			return name
+		}
+		name = time.Now().UTC().Format(snapshotNameLayout) + "-" + strings.Repeat("0", i)
+	}
+}
+
+// dbEntries are the files and directories in cachePath belonging to the DB.
+func dbEntries(cachePath string) []string {
+	res := []string{
+		tickersFile(cachePath),
+		tickerHistoryFile(cachePath),
+		symbolsFile(cachePath),
+		universesFile(cachePath),
+		pricesDir(cachePath),
+		fundamentalsDir(cachePath),
+		actionsFile(cachePath),
+		fxFile(cachePath),
+		metadataFile(cachePath),
+	}
+	for _, f := range Frequencies {
+		res = append(res, resampledFile(cachePath, f), resampledDir(cachePath, f))
+	}
+	return res
+}
+
+// writeFileAtomic writes the file through a temporary file in the same
+// directory which is then renamed to fileName. Thus, the readers never see a
+// partially written file, and a file hard-linked from another snapshot is
+// replaced rather than modified.
+func writeFileAtomic(fileName string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*"+stagingSuffix)
+	if err != nil {
+		return errors.Annotate(err, "failed to create a temp file for '%s'", fileName)
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	if err := write(f); err != nil {
+		f.Close()
+		return errors.Annotate(err, "failed to write to '%s'", f.Name())
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return errors.Annotate(err, "failed to set permissions on '%s'", f.Name())
+	}
+	if err := f.Close(); err != nil {
+		return errors.Annotate(err, "failed to close '%s'", f.Name())
+	}
+	if err := os.Rename(f.Name(), fileName); err != nil {
+		return errors.Annotate(err, "failed to rename '%s' to '%s'", f.Name(), fileName)
+	}
+	return nil
+}
+
+// linkTree recreates the file or directory tree src as dst with hard links to
+// the files, falling back to copying when hard links are not supported.
+func linkTree(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return errors.Annotate(err, "failed to stat '%s'", src)
+	}
+	if !info.IsDir() {
+		if err := os.Link(src, dst); err == nil {
+			return nil
+		}
+		return copyFile(src, dst)
+	}
+	if err := os.MkdirAll(dst, os.ModeDir|0755); err != nil {
+		return errors.Annotate(err, "failed to create '%s'", dst)
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return errors.Annotate(err, "failed to read '%s'", src)
+	}
+	for _, e := range entries {
+		if err := linkTree(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Annotate(err, "failed to open '%s'", src)
+	}
+	defer in.Close()
+	return writeFileAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, in)
+		return err
+	})
+}
+
+// stageSnapshot creates a new staging snapshot directory in root populated
+// with the data from the current cachePath, and returns the new snapshot name.
+func stageSnapshot(root, cachePath string) (string, error) {
+	name := newSnapshotName(root)
+	staging := snapshotDir(root, name+stagingSuffix)
+	if err := os.MkdirAll(staging, os.ModeDir|0755); err != nil {
+		return "", errors.Annotate(err, "failed to create '%s'", staging)
+	}
+	for _, src := range dbEntries(cachePath) {
+		if _, err := os.Lstat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(staging, filepath.Base(src))
+		if err := linkTree(src, dst); err != nil {
+			return "", errors.Annotate(err, "failed to stage '%s'", src)
+		}
+	}
+	return name, nil
+}
+
+// commitSnapshot renames the staged snapshot into place, atomically points the
+// "current" link to it, and deletes all but the last keep snapshots, abandoned
+// staging directories and the pre-snapshot DB files.
+func commitSnapshot(root, name string, keep int) error {
+	staging := snapshotDir(root, name+stagingSuffix)
+	if err := os.Rename(staging, snapshotDir(root, name)); err != nil {
+		return errors.Annotate(err, "failed to rename '%s'", staging)
+	}
+	tmpLink := currentLink(root) + stagingSuffix
+	os.Remove(tmpLink)
+	if err := os.Symlink(filepath.Join(snapshotsDirName, name), tmpLink); err != nil {
+		return errors.Annotate(err, "failed to create link '%s'", tmpLink)
+	}
+	if err := os.Rename(tmpLink, currentLink(root)); err != nil {
+		return errors.Annotate(err, "failed to update '%s'", currentLink(root))
+	}
+	for _, e := range dbEntries(root) {
+		if err := os.RemoveAll(e); err != nil {
+			return errors.Annotate(err, "failed to remove '%s'", e)
+		}
+	}
+	entries, err := os.ReadDir(snapshotsDir(root))
+	if err != nil {
+		return errors.Annotate(err, "failed to read '%s'", snapshotsDir(root))
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), stagingSuffix) {
+			if err := os.RemoveAll(snapshotDir(root, e.Name())); err != nil {
+				return errors.Annotate(err, "failed to remove '%s'", e.Name())
+			}
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if keep < 1 {
+		keep = 1
+	}
+	for len(names) > keep {
+		if names[0] != name {
+			if err := os.RemoveAll(snapshotDir(root, names[0])); err != nil {
+				return errors.Annotate(err, "failed to remove snapshot %s", names[0])
+			}
+		}
+		names = names[1:]
+	}
+	return nil
+}
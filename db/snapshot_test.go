@@ -0,0 +1,141 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stockparfait/testutil"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testsnapshot")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	tickers := map[string]TickerRow{"A": {Active: true}}
+	prices1 := []PriceRow{
+		TestPrice(NewDate(2019, 1, 1), 10.0, 10.0, 10.0, 1000.0, true),
+	}
+	prices2 := []PriceRow{
+		TestPrice(NewDate(2019, 1, 1), 10.0, 10.0, 10.0, 1000.0, true),
+		TestPrice(NewDate(2019, 1, 2), 11.0, 11.0, 11.0, 1100.0, true),
+	}
+
+	Convey("writes are staged until Commit", t, func() {
+		dbName := "staged"
+		So(os.RemoveAll(filepath.Join(tmpdir, dbName)), ShouldBeNil)
+		w := NewWriter(tmpdir, dbName)
+		So(w.WriteTickers(tickers), ShouldBeNil)
+		So(w.WritePrices("A", prices1), ShouldBeNil)
+		So(NewReader(tmpdir, dbName).HasTickers(), ShouldBeFalse)
+		So(w.Commit(), ShouldBeNil)
+
+		r1 := NewReader(tmpdir, dbName)
+		p, err := r1.Prices("A")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, prices1)
+		snapshot1 := r1.SnapshotName()
+
+		So(w.WritePrices("A", prices2), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
+
+		// r1 stays pinned to its snapshot.
+		p, err = r1.Prices("A")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, prices1)
+
+		r2 := NewReader(tmpdir, dbName)
+		So(r2.SnapshotName(), ShouldNotEqual, snapshot1)
+		p, err = r2.Prices("A")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, prices2)
+		So(r2.HasTickers(), ShouldBeTrue) // carried over from snapshot1
+
+		snapshots, err := Snapshots(tmpdir, dbName)
+		So(err, ShouldBeNil)
+		So(snapshots, ShouldResemble, []string{snapshot1, r2.SnapshotName()})
+
+		Convey("Reader opens a named snapshot", func() {
+			var r Reader
+			js := fmt.Sprintf(`{"DB path": "%s", "DB": "%s", "snapshot": "%s"}`,
+				tmpdir, dbName, snapshot1)
+			So(r.InitMessage(testutil.JSON(js)), ShouldBeNil)
+			So(r.SnapshotName(), ShouldEqual, snapshot1)
+			p, err := r.Prices("A")
+			So(err, ShouldBeNil)
+			So(p, ShouldResemble, prices1)
+		})
+
+		Convey("old snapshots are deleted", func() {
+			w.Keep = 2
+			So(w.WritePrices("A", prices1), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+			snapshots, err := Snapshots(tmpdir, dbName)
+			So(err, ShouldBeNil)
+			So(len(snapshots), ShouldEqual, 2)
+			So(snapshots[0], ShouldEqual, r2.SnapshotName())
+			So(NewReader(tmpdir, dbName).SnapshotName(), ShouldEqual, snapshots[1])
+		})
+
+		Convey("abandoned staging is ignored and removed", func() {
+			abandoned := NewWriter(tmpdir, dbName)
+			So(abandoned.WritePrices("A", prices1), ShouldBeNil)
+			So(NewReader(tmpdir, dbName).SnapshotName(), ShouldEqual, r2.SnapshotName())
+			So(abandoned.writeLock.Unlock(), ShouldBeNil) // as if the process died
+
+			So(w.WritePrices("A", prices2), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+			So(testutil.FileExists(abandoned.cachePath()), ShouldBeFalse)
+		})
+	})
+
+	Convey("DB without snapshots is read and migrated", t, func() {
+		dbName := "legacy"
+		root := filepath.Join(tmpdir, dbName)
+		So(os.MkdirAll(pricesDir(root), 0755), ShouldBeNil)
+		So(writeGob(tickersFile(root), tickers), ShouldBeNil)
+		So(writeGob(pricesFile(root, "A", GobFormat), prices1), ShouldBeNil)
+		So(testutil.WriteFile(filepath.Join(root, "config.toml"), ""), ShouldBeNil)
+
+		r := NewReader(tmpdir, dbName)
+		So(r.SnapshotName(), ShouldEqual, "")
+		p, err := r.Prices("A")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, prices1)
+
+		w := NewWriter(tmpdir, dbName)
+		So(w.WriteMetadata(Metadata{NumTickers: 1}), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
+
+		r = NewReader(tmpdir, dbName)
+		So(r.SnapshotName(), ShouldNotEqual, "")
+		p, err = r.Prices("A")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, prices1)
+		So(r.HasMetadata(), ShouldBeTrue)
+		So(testutil.FileExists(tickersFile(root)), ShouldBeFalse)
+		So(testutil.FileExists(filepath.Join(root, "config.toml")), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"github.com/stockparfait/errors"
+)
+
+// The data of a security is stored under its current ticker, and
+// TickerRow.ID identifies the security permanently. The symbol history table
+// maps the old symbols of renamed securities to their IDs for the periods when
+// the symbols were in use, so an old symbol on an old date can be resolved to
+// the current ticker.
+
+// tickerIDs maps the permanent IDs to the current tickers.
+func tickerIDs(tickers map[string]TickerRow) map[string]string {
+	ids := make(map[string]string)
+	for t, row := range tickers {
+		if row.ID != "" {
+			ids[row.ID] = t
+		}
+	}
+	return ids
+}
+
+func (r *Reader) cacheSymbols() error {
+	r.symbolsOnce.Do(func() {
+		if err := r.cacheTickers(); err != nil {
+			r.symbolsError = errors.Annotate(err, "failed to load tickers")
+			return
+		}
+		r.ids = tickerIDs(r.tickers)
+		if !r.HasSymbols() {
+			return
+		}
+		r.symbolsError = r.withLock(func() error {
+			fileName := symbolsFile(r.cachePath())
+			if err := readGob(fileName, &r.symbols); err != nil {
+				return errors.Annotate(err, "failed to load %s", fileName)
+			}
+			return nil
+		})
+	})
+	return r.symbolsError
+}
+
+// HasSymbols checks if the DB exists and has the symbol history table.
+func (r *Reader) HasSymbols() bool {
+	return fileExists(symbolsFile(r.cachePath()))
+}
+
+// AllSymbolRows returns the entire symbol history table as a {symbol -> rows}
+// map, compatible with Writer.WriteSymbols() method. A DB without the table
+// returns an empty map. Note: modifying the map will modify the Reader's cached
+// copy.
+func (r *Reader) AllSymbolRows() (map[string][]SymbolRow, error) {
+	if err := r.cacheSymbols(); err != nil {
+		return nil, errors.Annotate(err, "failed to load symbols")
+	}
+	return r.symbols, nil
+}
+
+// Resolve returns the current ticker of the security which traded under the
+// symbol on the date. A symbol which was not renamed by that date resolves to
+// itself, if it is a known ticker.
+func (r *Reader) Resolve(symbol string, date Date) (string, error) {
+	if err := r.cacheSymbols(); err != nil {
+		return "", errors.Annotate(err, "failed to load symbols")
+	}
+	for _, row := range r.symbols[symbol] {
+		if date.Before(row.Start) || !date.Before(row.End) {
+			continue
+		}
+		t, ok := r.ids[row.ID]
+		if !ok {
+			return "", errors.Reason("no ticker with ID %s for %s on %s",
+				row.ID, symbol, date)
+		}
+		return t, nil
+	}
+	if _, ok := r.tickers[symbol]; !ok {
+		return "", errors.Reason("unknown symbol %s on %s", symbol, date)
+	}
+	return symbol, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSymbols(t *testing.T) {
+	t.Parallel()
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testsymbols")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	// Security 1 traded as X, then W, now Z. Security 2 traded as X after 1
+	// left it, and is now Y.
+	tickers := map[string]TickerRow{
+		"Z": {ID: "1", Active: true},
+		"Y": {ID: "2", Active: true},
+		"V": {Active: true},
+	}
+	renames := []Rename{
+		{Date: NewDate(2015, 1, 1), Old: "X", New: "Y"},
+		{Date: NewDate(2010, 1, 1), Old: "X", New: "W"},
+		{Date: NewDate(2012, 1, 1), Old: "W", New: "Z"},
+		{Date: NewDate(2013, 1, 1), Old: "U", New: "V"}, // V has no ID
+	}
+	expected := map[string][]SymbolRow{
+		"X": {
+			{End: NewDate(2010, 1, 1), ID: "1"},
+			{End: NewDate(2015, 1, 1), ID: "2"},
+		},
+		"W": {{Start: NewDate(2010, 1, 1), End: NewDate(2012, 1, 1), ID: "1"}},
+	}
+
+	Convey("UpdateSymbols works", t, func() {
+		symbols := make(map[string][]SymbolRow)
+		So(UpdateSymbols(symbols, tickers, renames), ShouldEqual, 3)
+		So(symbols, ShouldResemble, expected)
+
+		Convey("and skips the recorded renames", func() {
+			So(UpdateSymbols(symbols, tickers, renames[1:2]), ShouldEqual, 0)
+			So(symbols, ShouldResemble, expected)
+		})
+
+		Convey("incrementally", func() {
+			symbols := make(map[string][]SymbolRow)
+			So(UpdateSymbols(symbols, map[string]TickerRow{"W": {ID: "1"}},
+				renames[1:2]), ShouldEqual, 1)
+			So(UpdateSymbols(symbols, tickers, renames[2:3]), ShouldEqual, 1)
+			So(symbols, ShouldResemble, map[string][]SymbolRow{
+				"X": {{End: NewDate(2010, 1, 1), ID: "1"}},
+				"W": {{Start: NewDate(2010, 1, 1), End: NewDate(2012, 1, 1), ID: "1"}},
+			})
+		})
+	})
+
+	Convey("Resolve and Cleanup work", t, func() {
+		dbName := "db"
+		symbols := make(map[string][]SymbolRow)
+		UpdateSymbols(symbols, tickers, renames)
+		prices := []PriceRow{
+			TestPrice(NewDate(2009, 1, 2), 10.0, 10.0, 10.0, 1000.0, true),
+		}
+		w := NewWriter(tmpdir, dbName)
+		So(w.WriteTickers(tickers), ShouldBeNil)
+		So(w.WriteSymbols(symbols), ShouldBeNil)
+		So(w.WritePrices("X", prices), ShouldBeNil) // orphan of 2 -> Y
+		So(w.WritePrices("W", prices), ShouldBeNil) // orphan of 1 -> Z
+		So(w.WritePrices("Z", prices[:0]), ShouldBeNil)
+		So(w.WritePrices("U", prices), ShouldBeNil) // orphan without ID
+		So(w.Commit(), ShouldBeNil)
+
+		r := NewReader(tmpdir, dbName)
+		So(r.HasSymbols(), ShouldBeTrue)
+		for _, c := range []struct {
+			symbol string
+			date   Date
+			ticker string
+		}{
+			{"X", NewDate(2009, 1, 1), "Z"},
+			{"X", NewDate(2010, 1, 1), "Y"},
+			{"X", NewDate(2014, 12, 31), "Y"},
+			{"W", NewDate(2011, 1, 1), "Z"},
+			{"Z", NewDate(2011, 1, 1), "Z"},
+			{"Y", NewDate(2020, 1, 1), "Y"},
+		} {
+			ticker, err := r.Resolve(c.symbol, c.date)
+			So(err, ShouldBeNil)
+			So(ticker, ShouldEqual, c.ticker)
+		}
+		_, err := r.Resolve("X", NewDate(2015, 1, 1))
+		So(err, ShouldNotBeNil)
+		_, err = r.Resolve("W", NewDate(2009, 1, 1))
+		So(err, ShouldNotBeNil)
+
+		So(Cleanup(context.Background(), tmpdir, dbName), ShouldBeNil)
+		r = NewReader(tmpdir, dbName)
+		p, err := r.Prices("Y")
+		So(err, ShouldBeNil)
+		So(p, ShouldResemble, prices)
+		p, err = r.Prices("Z")
+		So(err, ShouldBeNil)
+		So(len(p), ShouldEqual, 0) // Z had its own prices
+		So(r.HasPrices("X"), ShouldBeFalse)
+		So(r.HasPrices("W"), ShouldBeFalse)
+		So(r.HasPrices("U"), ShouldBeFalse)
+	})
+}
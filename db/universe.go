@@ -0,0 +1,114 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"encoding/json"
+
+	"github.com/stockparfait/errors"
+)
+
+func (r *Reader) cacheUniverses() error {
+	r.universesOnce.Do(func() {
+		r.universesError = r.withLock(func() error {
+			fileName := universesFile(r.cachePath())
+			if err := readGob(fileName, &r.universes); err != nil {
+				return errors.Annotate(err, "failed to load %s", fileName)
+			}
+			return nil
+		})
+	})
+	return r.universesError
+}
+
+// HasUniverses checks if the DB exists and has the named universes.
+func (r *Reader) HasUniverses() bool {
+	return fileExists(universesFile(r.cachePath()))
+}
+
+// AllUniverses returns all the named universes in the DB, compatible with
+// Writer.WriteUniverses() method. Note: modifying the map will modify the
+// Reader's cached copy.
+func (r *Reader) AllUniverses() (map[string]Universe, error) {
+	if err := r.cacheUniverses(); err != nil {
+		return nil, errors.Annotate(err, "failed to load universes")
+	}
+	return r.universes, nil
+}
+
+// UniverseByName returns the named universe, or error if it doesn't exist.
+func (r *Reader) UniverseByName(name string) (Universe, error) {
+	if !r.HasUniverses() {
+		return Universe{}, errors.Reason("DB %s has no universes", r.DB)
+	}
+	if err := r.cacheUniverses(); err != nil {
+		return Universe{}, errors.Annotate(err, "failed to load universes")
+	}
+	u, ok := r.universes[name]
+	if !ok {
+		return Universe{}, errors.Reason("no universe %s in DB %s", name, r.DB)
+	}
+	return u, nil
+}
+
+// universeKey identifies a compiled universe expression.
+type universeKey struct {
+	name string
+	asOf Date
+}
+
+// universeExpr converts Reader's universe into a constraint expression, with
+// the membership as of r.AsOf. The members of each snapshot are the symbols in
+// use on its date, which are resolved to the current tickers. The expression is
+// cached for the universe and the date.
+func (r *Reader) universeExpr() (*Expr, error) {
+	key := universeKey{name: r.Universe, asOf: r.AsOf}
+	r.universeExprMu.Lock()
+	defer r.universeExprMu.Unlock()
+	if e, ok := r.universeExprs[key]; ok {
+		return e, nil
+	}
+	u, err := r.UniverseByName(r.Universe)
+	if err != nil {
+		return nil, err
+	}
+	e := &Expr{}
+	if s, ok := u.snapshot(r.AsOf); ok {
+		e.tickerSet = make(map[string]struct{})
+		for _, symbol := range s.Tickers {
+			t := symbol
+			if !s.Date.IsZero() {
+				// An unknown symbol is kept as is, and matches no ticker.
+				if resolved, err := r.Resolve(symbol, s.Date); err == nil {
+					t = resolved
+				}
+			}
+			e.tickerSet[t] = struct{}{}
+		}
+	}
+	if u.Filter != "" {
+		var js any
+		if err := json.Unmarshal([]byte(u.Filter), &js); err != nil {
+			return nil, errors.Annotate(err, "failed to parse universe filter")
+		}
+		var f Expr
+		if err := f.InitMessage(js); err != nil {
+			return nil, errors.Annotate(err, "failed to init universe filter")
+		}
+		e.And = []Expr{f}
+	}
+	r.universeExprs[key] = e
+	return e, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"os"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUniverse(t *testing.T) {
+	t.Parallel()
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testuniverse")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	s2020 := UniverseSnapshot{Date: NewDate(2020, 1, 1), Tickers: []string{"A", "B"}}
+	s2021 := UniverseSnapshot{Date: NewDate(2021, 1, 1), Tickers: []string{"B", "C"}}
+
+	Convey("Universe works", t, func() {
+		var u Universe
+		_, ok := u.Members(NewDate(2020, 6, 1))
+		So(ok, ShouldBeFalse)
+
+		u.AddSnapshots(s2021, s2020)
+		So(u.Snapshots, ShouldResemble, []UniverseSnapshot{s2020, s2021})
+		m, ok := u.Members(NewDate(2019, 1, 1))
+		So(ok, ShouldBeTrue)
+		So(m, ShouldResemble, []string{})
+		m, _ = u.Members(NewDate(2020, 12, 31))
+		So(m, ShouldResemble, []string{"A", "B"})
+		m, _ = u.Members(NewDate(2021, 1, 1))
+		So(m, ShouldResemble, []string{"B", "C"})
+		m, _ = u.Members(Date{})
+		So(m, ShouldResemble, []string{"B", "C"})
+
+		s := UniverseSnapshot{Date: NewDate(2020, 1, 1), Tickers: []string{"D"}}
+		u.AddSnapshots(s)
+		So(u.Snapshots, ShouldResemble, []UniverseSnapshot{s, s2021})
+	})
+
+	Convey("Reader with a universe works", t, func() {
+		ctx := context.Background()
+		dbName := "db"
+		tickers := map[string]TickerRow{
+			"A": {Exchange: "NYSE"},
+			"B": {Exchange: "NYSE"},
+			"C": {Exchange: "OTC"},
+			"D": {Exchange: "NYSE"},
+		}
+		w := NewWriter(tmpdir, dbName)
+		So(w.WriteTickers(tickers), ShouldBeNil)
+		So(w.WriteUniverses(map[string]Universe{
+			"dated":    {Snapshots: []UniverseSnapshot{s2020, s2021}},
+			"filtered": {Filter: `{"exchanges": ["NYSE"]}`},
+			"both": {
+				Snapshots: []UniverseSnapshot{s2020, s2021},
+				Filter:    `{"not": {"exchanges": ["OTC"]}}`,
+			},
+		}), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
+
+		universe := func(name string) []string {
+			r := NewReader(tmpdir, dbName)
+			r.Universe = name
+			ts, err := r.Tickers(ctx)
+			So(err, ShouldBeNil)
+			sort.Strings(ts)
+			return ts
+		}
+		So(universe("dated"), ShouldResemble, []string{"B", "C"})
+		So(universe("filtered"), ShouldResemble, []string{"A", "B", "D"})
+		So(universe("both"), ShouldResemble, []string{"B"})
+
+		r := NewReader(tmpdir, dbName)
+		r.Universe = "missing"
+		_, err := r.Tickers(ctx)
+		So(err, ShouldNotBeNil)
+
+		Convey("resolving renamed members as of the snapshot date", func() {
+			tickers["C"] = TickerRow{Exchange: "OTC", ID: "3"}
+			w := NewWriter(tmpdir, dbName)
+			So(w.WriteTickers(tickers), ShouldBeNil)
+			So(w.WriteSymbols(map[string][]SymbolRow{
+				"OLDC": {{Start: NewDate(2019, 1, 1), End: NewDate(2020, 6, 1), ID: "3"}},
+			}), ShouldBeNil)
+			So(w.WriteUniverses(map[string]Universe{
+				"renamed": {Snapshots: []UniverseSnapshot{
+					{Date: NewDate(2020, 1, 1), Tickers: []string{"A", "OLDC"}},
+				}},
+			}), ShouldBeNil)
+			So(w.Commit(), ShouldBeNil)
+
+			So(universe("renamed"), ShouldResemble, []string{"A", "C"})
+		})
+
+		Convey("caching the universe expression", func() {
+			r := NewReader(tmpdir, dbName)
+			r.Universe = "dated"
+			e, err := r.universeExpr()
+			So(err, ShouldBeNil)
+			e2, err := r.universeExpr()
+			So(err, ShouldBeNil)
+			So(e2, ShouldEqual, e)
+
+			r.AsOf = NewDate(2020, 6, 1)
+			e2, err = r.universeExpr()
+			So(err, ShouldBeNil)
+			So(e2, ShouldNotEqual, e)
+			So(e2.tickerSet, ShouldResemble, map[string]struct{}{"A": {}, "B": {}})
+		})
+	})
+}
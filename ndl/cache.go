@@ -0,0 +1,212 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ndl
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stockparfait/errors"
+	"github.com/stockparfait/logging"
+)
+
+// BulkFile is the bulk download archive of a specific version of a table
+// cached in a local directory. The archive is first downloaded into a partial
+// file, and an interrupted download resumes from where it stopped using HTTP
+// range requests. The complete archive is then reused until the table is
+// updated.
+type BulkFile struct {
+	Dir     string // the cache directory
+	Table   string // full table name, e.g. SHARADAR/SEP
+	Version string // the version of the table data, see VersionKey
+}
+
+// VersionKey identifies the version of the table data from its metadata: the
+// data version code and the time of the last refresh.
+func VersionKey(m *TableMetadata) string {
+	refreshed := time.Time(m.Datatable.Status.RefreshedAt).UTC().Format("20060102T150405")
+	return m.Datatable.Version.Code + "-" + refreshed
+}
+
+// NewBulkFile for the table version described by its metadata.
+func NewBulkFile(dir, table string, m *TableMetadata) *BulkFile {
+	return &BulkFile{Dir: dir, Table: table, Version: VersionKey(m)}
+}
+
+// prefix of the cached file names of all the versions of the table.
+func (f *BulkFile) prefix() string {
+	return strings.ReplaceAll(f.Table, "/", "_") + "-"
+}
+
+// Path to the complete cached archive.
+func (f *BulkFile) Path() string {
+	return filepath.Join(f.Dir, f.prefix()+f.Version+".zip")
+}
+
+func (f *BulkFile) partPath() string {
+	return f.Path() + ".part"
+}
+
+// Cached checks if the complete archive of this version is in the cache.
+func (f *BulkFile) Cached() bool {
+	_, err := os.Stat(f.Path())
+	return err == nil
+}
+
+// Download the archive pointed to by the handle into the cache, unless it's
+// already there. Transient failures during the download are retried according
+// to the retry policy of the context's client, each time resuming from the end
+// of the partial file. Once the archive is complete and its size is verified, the
+// cached archives of the other versions of the table are deleted.
+func (f *BulkFile) Download(ctx context.Context, h *BulkDownloadHandle) error {
+	if f.Cached() {
+		logging.Infof(ctx, "using cached %s", f.Path())
+		return nil
+	}
+	if h.Status != StatusFresh && h.Status != StatusRegenerating {
+		return errors.Reason(
+			"data archive is not available, status=%s", h.Status)
+	}
+	if err := os.MkdirAll(f.Dir, os.ModeDir|0755); err != nil {
+		return errors.Annotate(err, "failed to create '%s'", f.Dir)
+	}
+	client := GetClient(ctx)
+	if client == nil { // the link does not require the API key
+		client = NewClient("")
+	}
+	for attempt := 0; ; attempt++ {
+		wait, err := f.resume(ctx, client, h, attempt)
+		if err == nil {
+			break
+		}
+		if wait < 0 || attempt >= client.retry.Retries || ctx.Err() != nil {
+			return errors.Annotate(err, "failed to download %s", f.Table)
+		}
+		logging.Warningf(ctx, "resuming download of %s in %s: %s", f.Table, wait, err.Error())
+		if err := sleep(ctx, wait); err != nil {
+			return errors.Annotate(err, "context is canceled")
+		}
+	}
+	z, err := zip.OpenReader(f.partPath())
+	if err != nil {
+		os.Remove(f.partPath())
+		return errors.Annotate(err, "downloaded archive is invalid")
+	}
+	z.Close()
+	if err := os.Rename(f.partPath(), f.Path()); err != nil {
+		return errors.Annotate(err, "failed to rename '%s'", f.partPath())
+	}
+	old, err := filepath.Glob(filepath.Join(f.Dir, f.prefix()+"*"))
+	if err != nil {
+		return errors.Annotate(err, "failed to list old versions of %s", f.Table)
+	}
+	for _, name := range old {
+		if name != f.Path() {
+			os.Remove(name)
+		}
+	}
+	return nil
+}
+
+// resume downloading into the partial file from its current end, in a single
+// request as the attempt-th retry. On failure, it returns the wait before the
+// next attempt, or a negative wait if the failure is not transient.
+func (f *BulkFile) resume(ctx context.Context, client *Client, h *BulkDownloadHandle, attempt int) (time.Duration, error) {
+	backoff := client.retry.backoff(attempt)
+	file, err := os.OpenFile(f.partPath(), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return backoff, errors.Annotate(err, "failed to open '%s'", f.partPath())
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return backoff, errors.Annotate(err, "failed to seek in '%s'", f.partPath())
+	}
+	var header http.Header
+	if offset > 0 {
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+		logging.Infof(ctx, "resuming download of %s from %s", f.Table, humanize(offset))
+	}
+	resp, wait, err := client.getOnce(ctx, h.Link, header, attempt)
+	if err != nil {
+		var he *httpError
+		if errors.As(err, &he) && he.code == http.StatusRequestedRangeNotSatisfiable {
+			file.Truncate(0) // start over
+			wait = backoff
+		}
+		return wait, errors.Annotate(err, "failed to initiate download")
+	}
+	total := resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		var start, end int64
+		_, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d",
+			&start, &end, &total)
+		if err != nil || start != offset {
+			resp.Body.Close()
+			file.Truncate(0)
+			return backoff, errors.Reason("unexpected Content-Range: '%s'",
+				resp.Header.Get("Content-Range"))
+		}
+	} else { // the server sends the whole file
+		if err := file.Truncate(0); err != nil {
+			resp.Body.Close()
+			return backoff, errors.Annotate(err, "failed to truncate '%s'", f.partPath())
+		}
+		if offset, err = file.Seek(0, io.SeekStart); err != nil {
+			resp.Body.Close()
+			return backoff, errors.Annotate(err, "failed to seek in '%s'", f.partPath())
+		}
+	}
+	var reader io.ReadCloser = resp.Body
+	if h.MonitorFactory != nil {
+		reader = h.MonitorFactory(resp)
+	}
+	n, err := io.Copy(file, reader)
+	reader.Close()
+	if err != nil {
+		return backoff, errors.Annotate(err, "failed to read response body")
+	}
+	if size := offset + n; total >= 0 && size != total {
+		if size > total {
+			file.Truncate(0)
+		}
+		return backoff, errors.Reason("downloaded %d bytes, expected %d", size, total)
+	}
+	return 0, nil
+}
+
+// OpenCSV returns a CSVReader streaming the single CSV file in the cached
+// archive. When error is nil, make sure to call CSVReader.Close() when done
+// with the CSV stream.
+func (f *BulkFile) OpenCSV() (*CSVReader, error) {
+	z, err := zip.OpenReader(f.Path())
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to open '%s'", f.Path())
+	}
+	var r CSVReader
+	r.AddCloser(z)
+	if err := r.openZip(&z.Reader); err != nil {
+		r.Close()
+		return nil, errors.Annotate(err, "failed to read '%s'", f.Path())
+	}
+	return &r, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ndl
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stockparfait/fetch"
+	"github.com/stockparfait/stockparfait/db"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// rangeHandler serves the content with range requests. The first truncate
+// responses are cut short after half of the content. When status is set, all
+// the requests fail with it.
+type rangeHandler struct {
+	mu       sync.Mutex
+	content  []byte
+	truncate int
+	status   int
+	ranges   []string // Range headers of the requests
+}
+
+func (h *rangeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ranges = append(h.ranges, r.Header.Get("Range"))
+	if h.status != 0 {
+		w.WriteHeader(h.status)
+		return
+	}
+	if h.truncate > 0 {
+		h.truncate--
+		w.Header().Set("Content-Length", strconv.Itoa(len(h.content)))
+		w.Write(h.content[:len(h.content)/2])
+		return
+	}
+	http.ServeContent(w, r, "test.zip", time.Time{}, bytes.NewReader(h.content))
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testndlcache")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	Convey("VersionKey works", t, func() {
+		m := TableMetadata{Datatable: DatatableMeta{
+			Status:  TableStatus{RefreshedAt: *db.NewTime(2020, 4, 9, 22, 51, 22)},
+			Version: TableVersion{Code: "2"},
+		}}
+		So(VersionKey(&m), ShouldEqual, "2-20200409T225122")
+		f := NewBulkFile(tmpdir, "TEST/TABLE", &m)
+		So(f.Path(), ShouldEqual, filepath.Join(tmpdir, "TEST_TABLE-2-20200409T225122.zip"))
+	})
+
+	Convey("BulkFile downloads and caches the archive", t, func() {
+		rows := [][]string{{"one", "two"}, {"three", "four"}}
+		var buf bytes.Buffer
+		zipW := zip.NewWriter(&buf)
+		w, err := zipW.Create("test.csv")
+		So(err, ShouldBeNil)
+		So(csv.NewWriter(w).WriteAll(rows), ShouldBeNil)
+		So(zipW.Close(), ShouldBeNil)
+
+		h := &rangeHandler{content: buf.Bytes(), truncate: 1}
+		server := httptest.NewServer(h)
+		defer server.Close()
+
+		ctx := fetch.UseClient(context.Background(), server.Client())
+		ctx = InjectClient(ctx, newClient(server.URL, "key").Retry(
+			RetryPolicy{Retries: 2, MinWait: time.Millisecond, MaxWait: time.Millisecond}))
+		handle := &BulkDownloadHandle{Link: server.URL + "/test.zip", Status: StatusFresh}
+
+		dir, err := os.MkdirTemp(tmpdir, "cache") // fresh for each Convey leaf
+		So(err, ShouldBeNil)
+		f := &BulkFile{Dir: dir, Table: "TEST/TABLE", Version: "1"}
+		old := &BulkFile{Dir: dir, Table: "TEST/TABLE", Version: "0"}
+		So(os.WriteFile(old.Path(), []byte("old"), 0644), ShouldBeNil)
+
+		So(f.Cached(), ShouldBeFalse)
+		So(f.Download(ctx, handle), ShouldBeNil)
+		So(f.Cached(), ShouldBeTrue)
+		So(old.Cached(), ShouldBeFalse)
+		half := len(h.content) / 2
+		So(h.ranges, ShouldResemble, []string{"", "bytes=" + strconv.Itoa(half) + "-"})
+
+		r, err := f.OpenCSV()
+		So(err, ShouldBeNil)
+		row, err := r.Read()
+		So(err, ShouldBeNil)
+		So(row, ShouldResemble, rows[0])
+		row, err = r.Read()
+		So(err, ShouldBeNil)
+		So(row, ShouldResemble, rows[1])
+		_, err = r.Read()
+		So(err, ShouldEqual, io.EOF)
+		r.Close()
+
+		// The cached version is not downloaded again.
+		So(f.Download(ctx, handle), ShouldBeNil)
+		So(len(h.ranges), ShouldEqual, 2)
+
+		Convey("restarts when the partial file is too long", func() {
+			g := &BulkFile{Dir: dir, Table: "TEST/OTHER", Version: "1"}
+			So(os.WriteFile(g.partPath(), bytes.Repeat([]byte("x"), len(h.content)+10), 0644), ShouldBeNil)
+			So(g.Download(ctx, handle), ShouldBeNil)
+			data, err := os.ReadFile(g.Path())
+			So(err, ShouldBeNil)
+			So(data, ShouldResemble, h.content)
+		})
+
+		Convey("retries only in the resume loop", func() {
+			h.status = http.StatusServiceUnavailable
+			g := &BulkFile{Dir: dir, Table: "TEST/OTHER", Version: "3"}
+			err := g.Download(ctx, handle)
+			So(err, ShouldNotBeNil)
+			So(len(h.ranges), ShouldEqual, 2+3) // Retries: 2
+		})
+
+		Convey("does not retry a permanent failure", func() {
+			h.status = http.StatusNotFound
+			g := &BulkFile{Dir: dir, Table: "TEST/OTHER", Version: "3"}
+			err := g.Download(ctx, handle)
+			So(err, ShouldNotBeNil)
+			So(len(h.ranges), ShouldEqual, 2+1)
+		})
+
+		Convey("fails on unavailable archive", func() {
+			g := &BulkFile{Dir: dir, Table: "TEST/OTHER", Version: "2"}
+			err := g.Download(ctx, &BulkDownloadHandle{Status: StatusCreating})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "data archive is not available")
+		})
+	})
+}
@@ -0,0 +1,251 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ndl
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/stockparfait/errors"
+	"github.com/stockparfait/stockparfait/db"
+	"github.com/stockparfait/stockparfait/stats"
+)
+
+// Collapse is the frequency of the time-series data points. The last value of
+// each period is returned.
+type Collapse string
+
+// Values of Collapse.
+const (
+	CollapseNone      = Collapse("")
+	CollapseDaily     = Collapse("daily")
+	CollapseWeekly    = Collapse("weekly")
+	CollapseMonthly   = Collapse("monthly")
+	CollapseQuarterly = Collapse("quarterly")
+	CollapseAnnual    = Collapse("annual")
+)
+
+// Transform is the server-side calculation applied to the time-series data.
+type Transform string
+
+// Values of Transform.
+const (
+	TransformNone      = Transform("")
+	TransformDiff      = Transform("diff")       // y[t] - y[t-1]
+	TransformRDiff     = Transform("rdiff")      // (y[t] - y[t-1]) / y[t-1]
+	TransformRDiffFrom = Transform("rdiff_from") // (y[latest] - y[t]) / y[t]
+	TransformCumul     = Transform("cumul")      // y[0] + y[1] + ... + y[t]
+	TransformNormalize = Transform("normalize")  // y[t] / y[0] * 100
+)
+
+// DatasetQuery is a builder for a time-series dataset query, such as FRED/DGS10
+// for the 10-year Treasury rate. Unlike TableQuery, the result is not paged,
+// and it is returned as a stats.Timeseries for each value column.
+type DatasetQuery struct {
+	dataset   string // a fully qualified dataset code, e.g. FRED/DGS10
+	start     db.Date
+	end       db.Date
+	collapse  Collapse
+	transform Transform
+	columns   []string // if non-nil, return only these columns
+}
+
+// NewDatasetQuery creates a new query for the dataset specified as
+// DATABASE/DATASET.
+func NewDatasetQuery(dataset string) *DatasetQuery {
+	q := DatasetQuery{dataset: dataset}
+	return &q
+}
+
+// Copy creates a deep copy of the query. It is primarily used in its builder
+// methods.
+func (q *DatasetQuery) Copy() *DatasetQuery {
+	q2 := *q
+	if q.columns != nil {
+		q2.columns = make([]string, len(q.columns))
+		copy(q2.columns, q.columns)
+	}
+	return &q2
+}
+
+// Start limits the data to the dates on or after d. Like other builder methods,
+// it always creates a deep copy of the query, leaving the original intact.
+func (q *DatasetQuery) Start(d db.Date) *DatasetQuery {
+	q2 := q.Copy()
+	q2.start = d
+	return q2
+}
+
+// End limits the data to the dates on or before d.
+func (q *DatasetQuery) End(d db.Date) *DatasetQuery {
+	q2 := q.Copy()
+	q2.end = d
+	return q2
+}
+
+// Collapse sets the frequency of the data points.
+func (q *DatasetQuery) Collapse(c Collapse) *DatasetQuery {
+	q2 := q.Copy()
+	q2.collapse = c
+	return q2
+}
+
+// Transform sets the calculation applied to the data before it's returned.
+func (q *DatasetQuery) Transform(t Transform) *DatasetQuery {
+	q2 := q.Copy()
+	q2.transform = t
+	return q2
+}
+
+// Columns constraints the query result to only these value columns. Since the
+// API can only select a single column by its index, the selection is done on
+// the client side.
+func (q *DatasetQuery) Columns(columns ...string) *DatasetQuery {
+	q2 := q.Copy()
+	q2.columns = columns
+	return q2
+}
+
+// Path returns the URL path to add to the base URL.
+func (q *DatasetQuery) Path() string {
+	return q.dataset
+}
+
+// Values returns the query values for the query. Each call creates a new
+// object, so the caller is free to modify it without affecting the query.
+func (q *DatasetQuery) Values() url.Values {
+	v := make(url.Values)
+	// Timeseries requires the dates in ascending order.
+	v["order"] = []string{"asc"}
+	if !q.start.IsZero() {
+		v["start_date"] = []string{q.start.String()}
+	}
+	if !q.end.IsZero() {
+		v["end_date"] = []string{q.end.String()}
+	}
+	if q.collapse != CollapseNone {
+		v["collapse"] = []string{string(q.collapse)}
+	}
+	if q.transform != TransformNone {
+		v["transform"] = []string{string(q.transform)}
+	}
+	return v
+}
+
+// datasetData holds the data and the column names of a dataset.
+type datasetData struct {
+	Columns []string  `json:"column_names"`
+	Data    [][]Value `json:"data"`
+}
+
+// datasetPage is the format of the time-series dataset data.
+type datasetPage struct {
+	Data datasetData `json:"dataset_data"`
+}
+
+// TestDatasetPage generates the JSON string in a format as returned by the NDL
+// time-series API. The first column is the date. For use in tests.
+func TestDatasetPage(data [][]Value, columns []string) (string, error) {
+	bytes, err := json.Marshal(&datasetPage{
+		Data: datasetData{Data: data, Columns: columns},
+	})
+	return string(bytes), err
+}
+
+// Read executes the query using the Client from the context, and returns a
+// Timeseries for each of the requested value columns (all by default) keyed by
+// the column name. Missing (null) values are skipped, so the Timeseries of
+// different columns may have different dates.
+func (q *DatasetQuery) Read(ctx context.Context) (map[string]*stats.Timeseries, error) {
+	client := GetClient(ctx)
+	if client == nil {
+		return nil, errors.Reason("DatasetQuery.Read: no client in context")
+	}
+	uri := client.baseURL + "/datasets/" + q.Path() + "/data.json"
+	query := q.Values()
+	query["api_key"] = []string{client.apiKey}
+
+	var page datasetPage
+	if err := client.fetchJSON(ctx, uri, query, &page); err != nil {
+		return nil, errors.Annotate(err, "DatasetQuery.Read: failed to fetch URL")
+	}
+	res, err := page.Data.timeseries(q.columns)
+	if err != nil {
+		return nil, errors.Annotate(err, "DatasetQuery.Read: failed to parse %s", q.dataset)
+	}
+	return res, nil
+}
+
+// timeseries converts the selected value columns into Timeseries.
+func (d *datasetData) timeseries(columns []string) (map[string]*stats.Timeseries, error) {
+	if len(d.Columns) < 2 {
+		return nil, errors.Reason("expected a date and at least one value column, got: %s",
+			strings.Join(d.Columns, ", "))
+	}
+	indices := make(map[string]int)
+	for i, c := range d.Columns[1:] {
+		indices[c] = i + 1
+	}
+	if columns == nil {
+		columns = d.Columns[1:]
+	}
+	var missing []string
+	for _, c := range columns {
+		if _, ok := indices[c]; !ok {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, errors.Reason("unknown columns: %s", strings.Join(missing, ", "))
+	}
+	dates := make(map[string][]db.Date)
+	values := make(map[string][]float64)
+	for i, row := range d.Data {
+		if len(row) != len(d.Columns) {
+			return nil, errors.Reason("row %d has %d values, expected %d",
+				i, len(row), len(d.Columns))
+		}
+		s, ok := row[0].(string)
+		if !ok {
+			return nil, errors.Reason("row %d: date %v is of the wrong type: %T",
+				i, row[0], row[0])
+		}
+		date, err := db.NewDateFromString(s)
+		if err != nil {
+			return nil, errors.Annotate(err, "row %d: invalid date", i)
+		}
+		for _, c := range columns {
+			v := row[indices[c]]
+			if v == nil {
+				continue
+			}
+			// Any number in JSON is unmarshaled as float64.
+			x, ok := v.(float64)
+			if !ok {
+				return nil, errors.Reason("row %d: %s = %v is of the wrong type: %T",
+					i, c, v, v)
+			}
+			dates[c] = append(dates[c], date)
+			values[c] = append(values[c], x)
+		}
+	}
+	res := make(map[string]*stats.Timeseries)
+	for _, c := range columns {
+		res[c] = stats.NewTimeseries(dates[c], values[c])
+	}
+	return res, nil
+}
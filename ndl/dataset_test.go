@@ -0,0 +1,108 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ndl
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stockparfait/fetch"
+	"github.com/stockparfait/stockparfait/db"
+	"github.com/stockparfait/testutil"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDataset(t *testing.T) {
+	t.Parallel()
+
+	Convey("DatasetQuery builds nondestructively", t, func() {
+		q := NewDatasetQuery("TEST/DATA")
+		q2 := q.Start(db.NewDate(2020, 1, 1)).End(db.NewDate(2020, 12, 31))
+		q3 := q.Collapse(CollapseMonthly).Transform(TransformRDiff)
+		q4 := q.Columns("c1", "c2")
+		So(q.Values(), ShouldResemble, url.Values{"order": {"asc"}})
+		So(q2.Values(), ShouldResemble, url.Values{
+			"order":      {"asc"},
+			"start_date": {"2020-01-01"},
+			"end_date":   {"2020-12-31"},
+		})
+		So(q3.Values(), ShouldResemble, url.Values{
+			"order":     {"asc"},
+			"collapse":  {"monthly"},
+			"transform": {"rdiff"},
+		})
+		So(q4.columns, ShouldResemble, []string{"c1", "c2"})
+		So(q.columns, ShouldBeNil)
+	})
+
+	Convey("DatasetQuery reads time-series", t, func() {
+		server := testutil.NewTestServer()
+		defer server.Close()
+
+		testKey := "testkey"
+		ctx := fetch.UseClient(context.Background(), server.Client())
+		ctx = InjectClient(ctx, newClient(server.URL()+"/api/v3", testKey))
+
+		page, err := TestDatasetPage([][]Value{
+			{"2020-01-02", 1.5, 10.0},
+			{"2020-01-03", nil, 11.0},
+			{"2020-01-06", 1.7, 12.0},
+		}, []string{"Date", "Open", "Close"})
+		So(err, ShouldBeNil)
+		server.ResponseBody = []string{page}
+
+		d := func(day uint8) db.Date { return db.NewDate(2020, 1, day) }
+
+		Convey("all columns", func() {
+			q := NewDatasetQuery("TEST/DATA").Start(d(1)).Collapse(CollapseDaily)
+			res, err := q.Read(ctx)
+			So(err, ShouldBeNil)
+			So(server.RequestPath, ShouldEqual, "/api/v3/datasets/TEST/DATA/data.json")
+			expectedQuery := q.Values()
+			expectedQuery["api_key"] = []string{testKey}
+			So(server.RequestQuery, ShouldResemble, expectedQuery)
+			So(len(res), ShouldEqual, 2)
+			So(res["Open"].Dates(), ShouldResemble, []db.Date{d(2), d(6)})
+			So(res["Open"].Data(), ShouldResemble, []float64{1.5, 1.7})
+			So(res["Close"].Dates(), ShouldResemble, []db.Date{d(2), d(3), d(6)})
+			So(res["Close"].Data(), ShouldResemble, []float64{10.0, 11.0, 12.0})
+		})
+
+		Convey("selected columns", func() {
+			res, err := NewDatasetQuery("TEST/DATA").Columns("Close").Read(ctx)
+			So(err, ShouldBeNil)
+			So(len(res), ShouldEqual, 1)
+			So(res["Close"].Data(), ShouldResemble, []float64{10.0, 11.0, 12.0})
+		})
+
+		Convey("unknown column", func() {
+			_, err := NewDatasetQuery("TEST/DATA").Columns("Close", "High").Read(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unknown columns: High")
+		})
+
+		Convey("invalid value", func() {
+			page, err := TestDatasetPage([][]Value{{"2020-01-02", "bad"}},
+				[]string{"Date", "Value"})
+			So(err, ShouldBeNil)
+			server.ResponseBody = []string{page}
+			_, err = NewDatasetQuery("TEST/DATA").Read(ctx)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "wrong type")
+		})
+	})
+}
@@ -12,9 +12,11 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package ndl implements generic table API of Nasdaq Data Link (NDL).
+// Package ndl implements generic table and time-series API of Nasdaq Data Link
+// (NDL).
 //
-// Official documentation is at https://docs.data.nasdaq.com/docs/tables-1 .
+// Official documentation is at https://docs.data.nasdaq.com/docs/tables-1 and
+// https://docs.data.nasdaq.com/docs/time-series .
 //
 // Each NDL table has a schema, which is the list of column names and their
 // types, in the order they appear in the table. This schema can be obtained for
@@ -27,6 +29,15 @@
 // allowing paging when downloading more than 10K rows. This package implements
 // transparent paging in RowIterator.
 //
+// Time-series datasets, such as FRED/DGS10 for the 10-year Treasury rate, are
+// queried with DatasetQuery, which returns a stats.Timeseries for each value
+// column of the dataset.
+//
+// All the requests of a Client share its token bucket rate limiter, and
+// transient failures (network errors, 5xx and 429 responses) are retried with
+// exponential backoff and jitter, honoring the Retry-After header up to the
+// maximum wait. See RetryPolicy and Limiter.
+//
 // APIs for specific providers and products, such as Sharadar Equities and ETFs,
 // are implemented in the subpackages.
 package ndl
@@ -27,7 +27,6 @@ import (
 	"strings"
 
 	"github.com/stockparfait/errors"
-	"github.com/stockparfait/fetch"
 	"github.com/stockparfait/logging"
 	"github.com/stockparfait/stockparfait/db"
 )
@@ -42,10 +41,14 @@ const (
 // before creating a new client.
 var URL = "https://data.nasdaq.com/api/v3"
 
-// Client for querying NDL tables and time-series.
+// Client for querying NDL tables and time-series. All the requests of the
+// client share its rate limiter, and transient failures are retried according
+// to its retry policy.
 type Client struct {
 	baseURL string // the base URL of the server
 	apiKey  string // your very own secret key
+	retry   RetryPolicy
+	limiter *Limiter
 }
 
 // newClient creates a new client.
@@ -53,9 +56,36 @@ func newClient(baseURL, apiKey string) *Client {
 	return &Client{
 		baseURL: baseURL,
 		apiKey:  apiKey,
+		retry:   DefaultRetryPolicy(),
+		limiter: DefaultLimiter(),
 	}
 }
 
+// NewClient creates a new client for the API key with the default retry policy
+// and rate limiter.
+func NewClient(apiKey string) *Client {
+	return newClient(URL, apiKey)
+}
+
+// Retry sets the retry policy of the client. It returns the same client for
+// chaining.
+func (c *Client) Retry(p RetryPolicy) *Client {
+	c.retry = p
+	return c
+}
+
+// Limit sets the rate limiter of the client; nil means no limit. It returns the
+// same client for chaining.
+func (c *Client) Limit(l *Limiter) *Client {
+	c.limiter = l
+	return c
+}
+
+// InjectClient into the context.
+func InjectClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientContextKey, c)
+}
+
 // GetClient extracts the Client from the context, if any.
 func GetClient(ctx context.Context) *Client {
 	c, ok := ctx.Value(clientContextKey).(*Client)
@@ -68,7 +98,7 @@ func GetClient(ctx context.Context) *Client {
 // UseClient creates a new client based on the API key and injects it into the
 // context.
 func UseClient(ctx context.Context, apiKey string) context.Context {
-	return context.WithValue(ctx, clientContextKey, newClient(URL, apiKey))
+	return InjectClient(ctx, NewClient(apiKey))
 }
 
 // ValueLoader is the interface that a row type of a specific table must
@@ -308,7 +338,7 @@ func (q *TableQuery) readPage(ctx context.Context, page *tablePage) error {
 	query := q.Values()
 	query["api_key"] = []string{client.apiKey}
 
-	if err := fetch.FetchJSON(ctx, uri, page, query, nil); err != nil {
+	if err := client.fetchJSON(ctx, uri, query, page); err != nil {
 		return errors.Annotate(err, "TableQuery.Read: failed to fetch URL")
 	}
 	return nil
@@ -453,7 +483,7 @@ func FetchTableMetadata(ctx context.Context, table string) (*TableMetadata, erro
 	uri := client.baseURL + "/datatables/" + table + "/metadata.json"
 	query := make(url.Values)
 	query["api_key"] = []string{client.apiKey}
-	if err := fetch.FetchJSON(ctx, uri, &tm, query, nil); err != nil {
+	if err := client.fetchJSON(ctx, uri, query, &tm); err != nil {
 		return nil, errors.Annotate(err, "failed to fetch URL")
 	}
 	return &tm, nil
@@ -507,7 +537,7 @@ func BulkDownload(ctx context.Context, table string) (*BulkDownloadHandle, error
 	query := make(url.Values)
 	query["api_key"] = []string{client.apiKey}
 	query["qopts.export"] = []string{"true"}
-	if err := fetch.FetchJSON(ctx, uri, &h, query, nil); err != nil {
+	if err := client.fetchJSON(ctx, uri, query, &h); err != nil {
 		return nil, errors.Annotate(err, "failed to fetch URL")
 	}
 	b := BulkDownloadHandle{
@@ -651,7 +681,11 @@ func BulkDownloadCSV(ctx context.Context, h *BulkDownloadHandle) (*CSVReader, er
 		return nil, errors.Reason(
 			"data archive is not available, status=%s", h.Status)
 	}
-	resp, err := fetch.GetRetry(ctx, h.Link, nil, nil)
+	client := GetClient(ctx)
+	if client == nil { // the link does not require the API key
+		client = NewClient("")
+	}
+	resp, err := client.get(ctx, h.Link, nil, nil)
 	if err != nil {
 		return nil, errors.Annotate(err, "failed to initiate download")
 	}
@@ -672,21 +706,29 @@ func BulkDownloadCSV(ctx context.Context, h *BulkDownloadHandle) (*CSVReader, er
 	if err != nil {
 		return nil, errors.Annotate(err, "failed to read zip archive")
 	}
+	if err := csvReader.openZip(z); err != nil {
+		return nil, err
+	}
+	csvReader.ignoreDeferredClose = true
+	return &csvReader, nil
+}
+
+// openZip sets up the reader to stream the single CSV file in the zip archive.
+func (r *CSVReader) openZip(z *zip.Reader) error {
 	if len(z.File) != 1 {
 		names := make([]string, len(z.File))
 		for i := 0; i < len(z.File); i++ {
 			names[i] = z.File[i].Name
 		}
-		return nil, errors.Reason("archive contains %d files (expected 1):\n  %s",
+		return errors.Reason("archive contains %d files (expected 1):\n  %s",
 			len(z.File), strings.Join(names, "\n  "))
 	}
 	rc, err := z.File[0].Open()
 	if err != nil {
-		return nil, errors.Annotate(err,
+		return errors.Annotate(err,
 			"failed to open file in archive '%s'", z.File[0].Name)
 	}
-	csvReader.AddCloser(rc)
-	csvReader.reader = csv.NewReader(rc)
-	csvReader.ignoreDeferredClose = true
-	return &csvReader, nil
+	r.AddCloser(rc)
+	r.reader = csv.NewReader(rc)
+	return nil
 }
@@ -0,0 +1,270 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ndl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stockparfait/errors"
+	"github.com/stockparfait/fetch"
+	"github.com/stockparfait/logging"
+)
+
+// RetryPolicy configures retrying of the transient failures of the requests to
+// the server: network errors, 5xx responses and 429 (too many requests).
+type RetryPolicy struct {
+	Retries int           // number of retries after the first attempt
+	MinWait time.Duration // wait before the first retry
+	MaxWait time.Duration // cap of the backoff and Retry-After waits
+	// Jitter randomly shortens each wait by up to this fraction of it, [0..1],
+	// so that concurrent clients do not retry in lockstep.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used by the new clients.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Retries: 5,
+		MinWait: time.Second,
+		MaxWait: time.Minute,
+		Jitter:  0.2,
+	}
+}
+
+// backoff returns the wait before the retry number attempt (starting from 0).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.MinWait
+	for i := 0; i < attempt && wait < p.MaxWait; i++ {
+		wait *= 2
+	}
+	if wait > p.MaxWait {
+		wait = p.MaxWait
+	}
+	if p.Jitter > 0.0 {
+		wait -= time.Duration(p.Jitter * rand.Float64() * float64(wait))
+	}
+	return wait
+}
+
+// retryAfter parses the Retry-After header of the response, either in seconds
+// or as an HTTP date. The result is not ok if the header is absent or invalid.
+func retryAfter(resp *http.Response, now time.Time) (wait time.Duration, ok bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if wait = t.Sub(now); wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// httpError is a failed HTTP response.
+type httpError struct {
+	code   int
+	status string
+	body   string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("response code %s, body: %s", e.status, e.body)
+}
+
+// retriableResponse checks if the failed response may succeed when retried.
+func retriableResponse(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests || fetch.ResponseRetriable(resp)
+}
+
+// Limiter is a token bucket rate limiter: it allows bursts of up to burst
+// requests, and refills at rate requests per second. It is go-routine safe, so
+// a single Limiter can be shared by many concurrent queries.
+type Limiter struct {
+	rate   float64
+	burst  float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time // when tokens were last refilled
+}
+
+// NewLimiter creates a full bucket. A non-positive rate means no limit.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// DefaultLimiter matches the NDL limit of 2000 calls per 10 minutes for the
+// authenticated users.
+func DefaultLimiter() *Limiter {
+	return NewLimiter(2000.0/600.0, 20)
+}
+
+// reserve takes a token and returns the time to wait until it's available.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0.0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until the next request is allowed, or the context is canceled.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil || l.rate <= 0.0 {
+		return nil
+	}
+	return sleep(ctx, l.reserve())
+}
+
+// sleep for the duration or until the context is canceled.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// get sends a GET request with the optional header, waiting for the client's
+// limiter before each attempt, and retrying transient failures according to its
+// retry policy. A Retry-After header in the response overrides the policy's
+// backoff, but the wait is still capped at the policy's MaxWait. When the error
+// is nil, the response is successful (2xx), and the caller must close its body.
+// The HTTP client may be injected with fetch.UseClient, e.g. in tests.
+func (c *Client) get(ctx context.Context, uri string, query url.Values, header http.Header) (*http.Response, error) {
+	if query != nil {
+		uri = uri + "?" + query.Encode()
+	}
+	for attempt := 0; ; attempt++ {
+		resp, wait, err := c.getOnce(ctx, uri, header, attempt)
+		if err == nil {
+			return resp, nil
+		}
+		if wait < 0 {
+			return nil, err
+		}
+		if attempt >= c.retry.Retries {
+			return nil, errors.Annotate(err, "exhausted %d retries", c.retry.Retries)
+		}
+		logging.Warningf(ctx, "retrying in %s: %s", wait, err.Error())
+		if err := sleep(ctx, wait); err != nil {
+			return nil, errors.Annotate(err, "context is canceled")
+		}
+	}
+}
+
+// getOnce makes a single attempt of get, for the callers which retry on their
+// own. On failure, it returns the wait before the next attempt as in get, or a
+// negative wait if the failure is not transient.
+func (c *Client) getOnce(ctx context.Context, uri string, header http.Header, attempt int) (*http.Response, time.Duration, error) {
+	client := http.DefaultClient
+	if hc := fetch.GetClient(ctx); hc != nil {
+		client = hc
+	}
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, -1, errors.Annotate(err, "context is canceled")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, -1, errors.Annotate(err, "failed to create HTTP request")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, errors.Annotate(err, "context is canceled")
+		}
+		return nil, c.retry.backoff(attempt), errors.Annotate(err, "failed to GET URL")
+	}
+	if fetch.ResponseOK(resp) {
+		return resp, 0, nil
+	}
+	// The body of the response may have additional info.
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	resp.Body.Close()
+	err = &httpError{code: resp.StatusCode, status: resp.Status, body: body.String()}
+	if !retriableResponse(resp) {
+		return nil, -1, err
+	}
+	wait := c.retry.backoff(attempt)
+	if w, ok := retryAfter(resp, time.Now()); ok {
+		wait = w
+		if wait > c.retry.MaxWait {
+			wait = c.retry.MaxWait
+		}
+	}
+	return nil, wait, err
+}
+
+// fetchJSON gets the JSON blob from uri and unpacks it into result.
+func (c *Client) fetchJSON(ctx context.Context, uri string, query url.Values, result any) error {
+	resp, err := c.get(ctx, uri, query, nil)
+	if err != nil {
+		return errors.Annotate(err, "failed to get JSON data")
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Annotate(err, "failed to read response body")
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return errors.Annotate(err, "failed to unmarshal JSON")
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ndl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stockparfait/fetch"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testHandler responds with the status codes in sequence, and then with the
+// body and 200 OK.
+type testHandler struct {
+	mu         sync.Mutex
+	codes      []int
+	retryAfter string
+	body       string
+	requests   int
+}
+
+func (h *testHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests++
+	if len(h.codes) > 0 {
+		code := h.codes[0]
+		h.codes = h.codes[1:]
+		if h.retryAfter != "" {
+			w.Header().Set("Retry-After", h.retryAfter)
+		}
+		w.WriteHeader(code)
+		w.Write([]byte("try again"))
+		return
+	}
+	w.Write([]byte(h.body))
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	Convey("RetryPolicy backoff works", t, func() {
+		p := RetryPolicy{MinWait: time.Second, MaxWait: 5 * time.Second}
+		So(p.backoff(0), ShouldEqual, time.Second)
+		So(p.backoff(1), ShouldEqual, 2*time.Second)
+		So(p.backoff(2), ShouldEqual, 4*time.Second)
+		So(p.backoff(3), ShouldEqual, 5*time.Second)
+		So(p.backoff(100), ShouldEqual, 5*time.Second)
+
+		p.Jitter = 0.5
+		for i := 0; i < 10; i++ {
+			w := p.backoff(1)
+			So(w, ShouldBeLessThanOrEqualTo, 2*time.Second)
+			So(w, ShouldBeGreaterThanOrEqualTo, time.Second)
+		}
+	})
+
+	Convey("retryAfter works", t, func() {
+		now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+		resp := &http.Response{Header: make(http.Header)}
+		_, ok := retryAfter(resp, now)
+		So(ok, ShouldBeFalse)
+
+		resp.Header.Set("Retry-After", "7")
+		w, ok := retryAfter(resp, now)
+		So(ok, ShouldBeTrue)
+		So(w, ShouldEqual, 7*time.Second)
+
+		resp.Header.Set("Retry-After", "Wed, 01 Jan 2020 12:00:30 GMT")
+		w, ok = retryAfter(resp, now)
+		So(ok, ShouldBeTrue)
+		So(w, ShouldEqual, 30*time.Second)
+
+		resp.Header.Set("Retry-After", "soon")
+		_, ok = retryAfter(resp, now)
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("Limiter works", t, func() {
+		ctx := context.Background()
+		l := NewLimiter(100.0, 2)
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			So(l.Wait(ctx), ShouldBeNil)
+		}
+		// The burst of 2 is immediate, and the other 2 wait 10ms each.
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 15*time.Millisecond)
+
+		Convey("and is canceled with the context", func() {
+			l := NewLimiter(0.001, 1)
+			So(l.Wait(ctx), ShouldBeNil)
+			cctx, cancel := context.WithCancel(ctx)
+			cancel()
+			So(l.Wait(cctx), ShouldNotBeNil)
+		})
+
+		Convey("nil limiter does not limit", func() {
+			var l *Limiter
+			So(l.Wait(ctx), ShouldBeNil)
+		})
+	})
+
+	Convey("Client retries transient failures", t, func() {
+		h := &testHandler{body: `{"datatable": {"data": [], "columns": []}}`}
+		server := httptest.NewServer(h)
+		defer server.Close()
+
+		ctx := fetch.UseClient(context.Background(), server.Client())
+		c := newClient(server.URL, "key").
+			Retry(RetryPolicy{Retries: 3, MinWait: time.Millisecond, MaxWait: 2 * time.Millisecond}).
+			Limit(nil)
+		ctx = InjectClient(ctx, c)
+		So(GetClient(ctx), ShouldEqual, c)
+
+		Convey("5xx and 429", func() {
+			h.codes = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+			var page tablePage
+			So(NewTableQuery("TEST/TABLE").readPage(ctx, &page), ShouldBeNil)
+			So(h.requests, ShouldEqual, 3)
+		})
+
+		Convey("honoring Retry-After", func() {
+			c.Retry(RetryPolicy{Retries: 3, MinWait: time.Millisecond, MaxWait: 2 * time.Second})
+			h.codes = []int{http.StatusTooManyRequests}
+			h.retryAfter = "1"
+			start := time.Now()
+			var page tablePage
+			So(NewTableQuery("TEST/TABLE").readPage(ctx, &page), ShouldBeNil)
+			So(h.requests, ShouldEqual, 2)
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, time.Second)
+		})
+
+		Convey("capping Retry-After at MaxWait", func() {
+			h.codes = []int{http.StatusTooManyRequests}
+			h.retryAfter = "86400"
+			start := time.Now()
+			var page tablePage
+			So(NewTableQuery("TEST/TABLE").readPage(ctx, &page), ShouldBeNil)
+			So(h.requests, ShouldEqual, 2)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+
+		Convey("up to the number of retries", func() {
+			h.codes = []int{500, 500, 500, 500, 500}
+			var page tablePage
+			err := NewTableQuery("TEST/TABLE").readPage(ctx, &page)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "exhausted 3 retries")
+			So(h.requests, ShouldEqual, 4)
+		})
+
+		Convey("but not permanent failures", func() {
+			h.codes = []int{http.StatusForbidden}
+			var page tablePage
+			err := NewTableQuery("TEST/TABLE").readPage(ctx, &page)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "try again")
+			So(h.requests, ShouldEqual, 1)
+		})
+
+		Convey("unless the context is canceled", func() {
+			h.codes = []int{500}
+			c.Retry(RetryPolicy{Retries: 3, MinWait: time.Hour, MaxWait: time.Hour})
+			cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			_, err := BulkDownload(cctx, "TEST/TABLE")
+			So(err, ShouldNotBeNil)
+			So(h.requests, ShouldEqual, 1)
+		})
+	})
+}
@@ -14,4 +14,7 @@
 
 // Package sharadar implements specific schemas and methods for downloading
 // Sharadar equity and fund prices tables through Nasdaq Data Link.
+//
+// Provider implements provider.Provider for the Sharadar tables, which is how
+// Dataset.DownloadAll and Dataset.UpdateAll populate the DB.
 package sharadar
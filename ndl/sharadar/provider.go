@@ -0,0 +1,150 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sharadar
+
+import (
+	"context"
+	"strings"
+
+	"github.com/stockparfait/errors"
+	"github.com/stockparfait/logging"
+	"github.com/stockparfait/stockparfait/db"
+	"github.com/stockparfait/stockparfait/provider"
+)
+
+// Provider implements provider.Provider for the Sharadar tables, using the
+// Dataset to download and convert the data. Each call replaces the
+// corresponding data in the Dataset, and returns its maps as is.
+type Provider struct {
+	dataset      *Dataset
+	tables       []TableName // price tables
+	fundamentals bool        // whether FundamentalsTable is requested
+}
+
+var _ provider.Provider = &Provider{}
+var _ provider.Renamer = &Provider{}
+var _ provider.FundamentalsProvider = &Provider{}
+var _ provider.PriceStreamer = &Provider{}
+
+// NewProvider for the requested tables. If FundamentalsTable is among the
+// tables, the provider also supplies the fundamentals for the tickers of the
+// price tables. If no price tables are given, the default is all price tables.
+func NewProvider(d *Dataset, tables ...TableName) *Provider {
+	p := Provider{dataset: d}
+	p.tables, p.fundamentals = splitTables(tables)
+	return &p
+}
+
+// Tickers implements provider.Provider.
+func (p *Provider) Tickers(ctx context.Context) (map[string]db.TickerRow, error) {
+	d := p.dataset
+	d.Tickers = make(map[string]db.TickerRow)
+	logging.Infof(ctx, "fetching tickers for %s...", strings.Join(p.tables, ", "))
+	if err := d.FetchTickers(ctx, p.tables...); err != nil {
+		return nil, errors.Annotate(err, "failed to fetch tickers")
+	}
+	return d.Tickers, nil
+}
+
+// Actions implements provider.Provider. It must be called after Tickers, since
+// it skips any ticker not in TICKERS.
+func (p *Provider) Actions(ctx context.Context, since db.Date) (map[string][]db.ActionRow, error) {
+	d := p.dataset
+	d.RawActions = make(map[string][]Action)
+	d.Actions = make(map[string][]db.ActionRow)
+	d.NumRawActions = 0
+	if err := d.FetchActionsSince(ctx, since, RelevantActions...); err != nil {
+		return nil, errors.Annotate(err, "failed to fetch actions")
+	}
+	d.ConvertActions()
+	return d.Actions, nil
+}
+
+// Renames implements provider.Renamer.
+func (p *Provider) Renames() []db.Rename {
+	return p.dataset.Renames()
+}
+
+// Prices implements provider.Provider. All the prices of all the tickers are
+// bulk-downloaded, and otherwise they are fetched using the paging table API
+// from the tables of the tickers. It must be called after Tickers, since it
+// skips any ticker not in TICKERS.
+func (p *Provider) Prices(ctx context.Context, since db.Date, tickers ...string) (map[string][]db.PriceRow, error) {
+	d := p.dataset
+	d.Prices = make(map[string][]db.PriceRow)
+	d.NumPrices = 0
+	if since.IsZero() && len(tickers) == 0 {
+		for _, t := range p.tables {
+			logging.Infof(ctx, "bulk-downloading %s prices", t)
+			currPrices := d.NumPrices
+			if err := d.BulkDownloadPrices(ctx, t); err != nil {
+				return nil, errors.Annotate(err, "failed to download %s price table", t)
+			}
+			logging.Infof(ctx, "downloaded %d %s prices", d.NumPrices-currPrices, t)
+		}
+		return d.Prices, nil
+	}
+	for _, t := range p.tables {
+		var ts []string
+		for _, ticker := range tickers {
+			if row, ok := d.Tickers[ticker]; ok && row.Source == t {
+				ts = append(ts, ticker)
+			}
+		}
+		if len(tickers) > 0 && len(ts) == 0 {
+			continue
+		}
+		logging.Infof(ctx, "fetching %s prices after %s", t, since)
+		if err := d.FetchPrices(ctx, t, since, ts...); err != nil {
+			return nil, errors.Annotate(err, "failed to fetch %s prices", t)
+		}
+	}
+	return d.Prices, nil
+}
+
+// StreamPrices implements provider.PriceStreamer by bulk-downloading the price
+// tables and passing the prices to f one ticker at a time, without storing them
+// in the Dataset. It must be called after Tickers, since it skips any ticker
+// not in TICKERS. The prices of each ticker are taken only from the table of
+// its TickerRow.Source, so each ticker is passed to f at most once.
+func (p *Provider) StreamPrices(ctx context.Context, f func(ticker string, prices []db.PriceRow) error) error {
+	d := p.dataset
+	d.Prices = make(map[string][]db.PriceRow)
+	d.NumPrices = 0
+	for _, t := range p.tables {
+		logging.Infof(ctx, "bulk-downloading %s prices", t)
+		currPrices := d.NumPrices
+		if err := d.BulkStreamPrices(ctx, t, f); err != nil {
+			return errors.Annotate(err, "failed to stream %s price table", t)
+		}
+		logging.Infof(ctx, "streamed %d %s prices", d.NumPrices-currPrices, t)
+	}
+	return nil
+}
+
+// Fundamentals implements provider.FundamentalsProvider. The result is nil
+// unless FundamentalsTable is requested.
+func (p *Provider) Fundamentals(ctx context.Context) (map[string][]db.FundamentalsRow, error) {
+	if !p.fundamentals {
+		return nil, nil
+	}
+	d := p.dataset
+	d.Fundamentals = make(map[string][]db.FundamentalsRow)
+	d.NumFundamentals = 0
+	if err := d.BulkDownloadFundamentals(ctx); err != nil {
+		return nil, errors.Annotate(err, "failed to download fundamentals")
+	}
+	return d.Fundamentals, nil
+}
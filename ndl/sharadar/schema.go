@@ -155,6 +155,8 @@ var RelevantActions = []ActionType{
 	RegulatoryDelistingAction,
 	SpinoffDividendAction,
 	SplitAction,
+	TickerChangeFromAction,
+	TickerChangeToAction,
 	VoluntaryDelistingAction,
 }
 
@@ -270,6 +272,121 @@ var PriceSchema = ndl.Schema{
 	{Name: "lastupdated", Type: "Date"},
 }
 
+// Fundamentals is a row in the SF1 table: core fundamentals of a company for a
+// single reporting period and dimension.
+type Fundamentals struct {
+	Ticker            string
+	Dimension         string  // ARQ, ARY, ART, MRQ, MRY or MRT
+	CalendarDate      db.Date // normalized end of the calendar period
+	DateKey           db.Date // SEC filing date
+	ReportPeriod      db.Date // the end of the fiscal period
+	LastUpdated       db.Date //
+	Revenue           float32
+	CostOfRevenue     float32
+	GrossProfit       float32
+	OperatingIncome   float32
+	NetIncome         float32
+	EBITDA            float32
+	EPS               float32
+	EPSDiluted        float32
+	DividendsPerShare float32
+	OperatingCashFlow float32
+	FreeCashFlow      float32
+	Assets            float32
+	Liabilities       float32
+	Equity            float32
+	Debt              float32
+	Cash              float32
+	Shares            float32 // weighted average shares outstanding
+	MarketCap         float32
+	EV                float32
+	PE                float32
+	PS                float32
+	PB                float32
+	GrossMargin       float32
+	NetMargin         float32
+	ROE               float32
+}
+
+var _ ndl.ValueLoader = &Fundamentals{}
+
+// FundamentalsSchema is the expected schema for the SF1 table. Only the
+// columns used by the Fundamentals are listed; SF1 has many more.
+var FundamentalsSchema = ndl.Schema{
+	{Name: "ticker", Type: "text"},
+	{Name: "dimension", Type: "text"},
+	{Name: "calendardate", Type: "Date"},
+	{Name: "datekey", Type: "Date"},
+	{Name: "reportperiod", Type: "Date"},
+	{Name: "lastupdated", Type: "Date"},
+	{Name: "revenue", Type: "double"},
+	{Name: "cor", Type: "double"},
+	{Name: "gp", Type: "double"},
+	{Name: "opinc", Type: "double"},
+	{Name: "netinc", Type: "double"},
+	{Name: "ebitda", Type: "double"},
+	{Name: "eps", Type: "double"},
+	{Name: "epsdil", Type: "double"},
+	{Name: "dps", Type: "double"},
+	{Name: "ncfo", Type: "double"},
+	{Name: "fcf", Type: "double"},
+	{Name: "assets", Type: "double"},
+	{Name: "liabilities", Type: "double"},
+	{Name: "equity", Type: "double"},
+	{Name: "debt", Type: "double"},
+	{Name: "cashneq", Type: "double"},
+	{Name: "shareswa", Type: "double"},
+	{Name: "marketcap", Type: "double"},
+	{Name: "ev", Type: "double"},
+	{Name: "pe", Type: "double"},
+	{Name: "ps", Type: "double"},
+	{Name: "pb", Type: "double"},
+	{Name: "grossmargin", Type: "double"},
+	{Name: "netmargin", Type: "double"},
+	{Name: "roe", Type: "double"},
+}
+
+// numFields maps numerical column names to the corresponding fields.
+func (r *Fundamentals) numFields() map[string]*float32 {
+	return map[string]*float32{
+		"revenue":     &r.Revenue,
+		"cor":         &r.CostOfRevenue,
+		"gp":          &r.GrossProfit,
+		"opinc":       &r.OperatingIncome,
+		"netinc":      &r.NetIncome,
+		"ebitda":      &r.EBITDA,
+		"eps":         &r.EPS,
+		"epsdil":      &r.EPSDiluted,
+		"dps":         &r.DividendsPerShare,
+		"ncfo":        &r.OperatingCashFlow,
+		"fcf":         &r.FreeCashFlow,
+		"assets":      &r.Assets,
+		"liabilities": &r.Liabilities,
+		"equity":      &r.Equity,
+		"debt":        &r.Debt,
+		"cashneq":     &r.Cash,
+		"shareswa":    &r.Shares,
+		"marketcap":   &r.MarketCap,
+		"ev":          &r.EV,
+		"pe":          &r.PE,
+		"ps":          &r.PS,
+		"pb":          &r.PB,
+		"grossmargin": &r.GrossMargin,
+		"netmargin":   &r.NetMargin,
+		"roe":         &r.ROE,
+	}
+}
+
+// dateFields maps date column names to the corresponding fields.
+func (r *Fundamentals) dateFields() map[string]*db.Date {
+	return map[string]*db.Date{
+		"calendardate": &r.CalendarDate,
+		"datekey":      &r.DateKey,
+		"reportperiod": &r.ReportPeriod,
+		"lastupdated":  &r.LastUpdated,
+	}
+}
+
 func typeErr(v ndl.Value, tp string) error {
 	return errors.Reason("expected %s but found %T: %v", tp, v, v)
 }
@@ -636,3 +753,70 @@ func (r *Price) FromCSV(row []string, columnMap map[string]int) error {
 	}
 	return nil
 }
+
+// Load implements ndl.ValueLoader.
+func (r *Fundamentals) Load(v []ndl.Value, s ndl.Schema) error {
+	if !FundamentalsSchema.SubsetOf(s) {
+		return errors.Reason("unexpected schema: %s", s.String())
+	}
+	if len(v) != len(s) {
+		return errors.Reason("expected %d values, received %d: %v", len(s), len(v), v)
+	}
+	m := s.MapFields()
+	var err error
+
+	if r.Ticker, err = value2str(v[m["ticker"]]); err != nil {
+		return errors.Annotate(err, "ticker should be a string")
+	}
+	if r.Dimension, err = value2str(v[m["dimension"]]); err != nil {
+		return errors.Annotate(err, "dimension should be a string")
+	}
+	for field, ptr := range r.dateFields() {
+		if *ptr, err = value2date(v[m[field]]); err != nil {
+			return errors.Annotate(err, "%s should be a date string", field)
+		}
+	}
+	for field, ptr := range r.numFields() {
+		if *ptr, err = value2num(v[m[field]]); err != nil {
+			return errors.Annotate(err, "%s should be a number", field)
+		}
+	}
+	return nil
+}
+
+// FromCSV sets the value of Fundamentals from a CSV row based on a column map
+// {field name -> column number}, where the field names are as in the SF1
+// table. Unlike Price, the row may contain columns not in FundamentalsSchema.
+func (r *Fundamentals) FromCSV(row []string, columnMap map[string]int) error {
+	if len(row) != len(columnMap) {
+		return errors.Reason("expected %d columns, received %d: %v",
+			len(columnMap), len(row), row)
+	}
+	r.Ticker = row[columnMap["ticker"]]
+	r.Dimension = row[columnMap["dimension"]]
+
+	var err error
+	for field, ptr := range r.dateFields() {
+		s := row[columnMap[field]]
+		if s == "" {
+			*ptr = db.Date{}
+			continue
+		}
+		if *ptr, err = db.NewDateFromString(s); err != nil {
+			return errors.Annotate(err, "%s must be a date string: '%s'", field, s)
+		}
+	}
+	for field, ptr := range r.numFields() {
+		s := row[columnMap[field]]
+		if s == "" { // missing values are common in SF1, assuming 0
+			*ptr = 0.0
+			continue
+		}
+		v, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return errors.Annotate(err, "%s should be a number: %v", field, s)
+		}
+		*ptr = float32(v)
+	}
+	return nil
+}
@@ -15,6 +15,7 @@
 package sharadar
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stockparfait/stockparfait/db"
@@ -286,4 +287,64 @@ func TestSchema(t *testing.T) {
 			})
 		})
 	})
+
+	Convey("Fundamentals works", t, func() {
+		// Values by column name; all other numerical columns are zero.
+		values := map[string]ndl.Value{
+			"ticker":       "ABC",
+			"dimension":    "ARQ",
+			"calendardate": "2020-03-31",
+			"datekey":      "2020-05-01",
+			"reportperiod": "2020-03-28",
+			"lastupdated":  "2020-05-02",
+			"revenue":      1000.0,
+			"netinc":       100.0,
+			"pe":           15.5,
+		}
+		expected := Fundamentals{
+			Ticker:       "ABC",
+			Dimension:    "ARQ",
+			CalendarDate: db.NewDate(2020, 3, 31),
+			DateKey:      db.NewDate(2020, 5, 1),
+			ReportPeriod: db.NewDate(2020, 3, 28),
+			LastUpdated:  db.NewDate(2020, 5, 2),
+			Revenue:      1000.0,
+			NetIncome:    100.0,
+			PE:           15.5,
+		}
+
+		Convey("Load", func() {
+			schema := append(ndl.Schema{{Name: "extrafield", Type: "Weird"}},
+				FundamentalsSchema...)
+			data := make([]ndl.Value, len(schema))
+			for i, f := range schema {
+				data[i] = values[f.Name]
+			}
+			var f Fundamentals
+			So(f.Load(data, schema), ShouldBeNil)
+			So(f, ShouldResemble, expected)
+		})
+
+		Convey("FromCSV", func() {
+			header := []string{"extrafield"}
+			row := []string{"fake"}
+			for _, f := range FundamentalsSchema {
+				header = append(header, f.Name)
+				v := values[f.Name]
+				switch x := v.(type) {
+				case string:
+					row = append(row, x)
+				case float64:
+					row = append(row, fmt.Sprintf("%g", x))
+				default:
+					row = append(row, "") // missing value
+				}
+			}
+			cmap, err := FundamentalsSchema.MapCSVColumns(header)
+			So(err, ShouldBeNil)
+			var f Fundamentals
+			So(f.FromCSV(row, cmap), ShouldBeNil)
+			So(f, ShouldResemble, expected)
+		})
+	})
 }
@@ -19,13 +19,14 @@ import (
 	"io"
 	"runtime"
 	"sort"
-	"strings"
+	"strconv"
 
 	"github.com/stockparfait/errors"
 	"github.com/stockparfait/iterator"
 	"github.com/stockparfait/logging"
 	"github.com/stockparfait/stockparfait/db"
 	"github.com/stockparfait/stockparfait/ndl"
+	"github.com/stockparfait/stockparfait/provider"
 )
 
 type TableName = string
@@ -35,6 +36,9 @@ const (
 	ActionsTable  = TableName("ACTIONS")
 	EquitiesTable = TableName("SEP")
 	FundsTable    = TableName("SFP")
+	// FundamentalsTable is not a price table, but it can be listed among the
+	// tables for download, along with the price tables.
+	FundamentalsTable = TableName("SF1")
 )
 
 func FullTableName(table TableName) string {
@@ -58,7 +62,16 @@ func FetchTickers(ctx context.Context, tables ...TableName) *ndl.RowIterator {
 // FetchActions returns a transparently paging iterator over Action. If no
 // actions are specified, the default is all actions.
 func FetchActions(ctx context.Context, actions ...ActionType) *ndl.RowIterator {
+	return FetchActionsSince(ctx, db.Date{}, actions...)
+}
+
+// FetchActionsSince is the same as FetchActions, but only returns actions
+// strictly after the given date. Zero date means all dates.
+func FetchActionsSince(ctx context.Context, since db.Date, actions ...ActionType) *ndl.RowIterator {
 	q := ndl.NewTableQuery(FullTableName(ActionsTable))
+	if !since.IsZero() {
+		q = q.Gt("date", since.String())
+	}
 	if len(actions) > 0 {
 		strs := make([]string, len(actions))
 		for i, a := range actions {
@@ -69,24 +82,45 @@ func FetchActions(ctx context.Context, actions ...ActionType) *ndl.RowIterator {
 	return q.Read(ctx)
 }
 
+// FetchPrices returns a transparently paging iterator over Price from the
+// given price table, strictly after the given date. Zero date means all dates.
+// When no tickers are supplied, the default is all tickers.
+func FetchPrices(ctx context.Context, table TableName, since db.Date, tickers ...string) *ndl.RowIterator {
+	q := ndl.NewTableQuery(FullTableName(table))
+	if !since.IsZero() {
+		q = q.Gt("date", since.String())
+	}
+	if len(tickers) > 0 {
+		q = q.Equal("ticker", tickers...)
+	}
+	return q.Read(ctx)
+}
+
 // Dataset for downloading and converting NDL Sharadar SEP/SFP data to
 // db.Database.
 type Dataset struct {
 	Tickers       map[string]db.TickerRow
 	RawActions    map[string][]Action
+	Actions       map[string][]db.ActionRow
 	Prices        map[string][]db.PriceRow
-	Monthly       map[string][]db.ResampledRow
+	Fundamentals  map[string][]db.FundamentalsRow
 	NumRawActions int
 	NumPrices     int
+	// Fundamentals rows, all dimensions.
+	NumFundamentals int
+	// BulkCache is the directory for caching the bulk download archives, see
+	// ndl.BulkFile. Empty means no caching.
+	BulkCache string
 }
 
 // NewDataset initializes an empty Sharadar dataset.
 func NewDataset() *Dataset {
 	return &Dataset{
-		Tickers:    make(map[string]db.TickerRow),
-		RawActions: make(map[string][]Action),
-		Prices:     make(map[string][]db.PriceRow),
-		Monthly:    make(map[string][]db.ResampledRow),
+		Tickers:      make(map[string]db.TickerRow),
+		RawActions:   make(map[string][]Action),
+		Actions:      make(map[string][]db.ActionRow),
+		Prices:       make(map[string][]db.PriceRow),
+		Fundamentals: make(map[string][]db.FundamentalsRow),
 	}
 }
 
@@ -105,12 +139,14 @@ func (d *Dataset) FetchTickers(ctx context.Context, tables ...TableName) error {
 		}
 		d.Tickers[t.Ticker] = db.TickerRow{
 			Source:      t.TableName,
+			ID:          strconv.Itoa(t.Permaticker),
 			Exchange:    t.Exchange,
 			Name:        t.Name,
 			Category:    t.Category,
 			Sector:      t.Sector,
 			Industry:    t.Industry,
 			Location:    t.Location,
+			Currency:    t.Currency,
 			SECFilings:  t.SECFilings,
 			CompanySite: t.CompanySite,
 			Active:      !t.IsDelisted,
@@ -122,7 +158,13 @@ func (d *Dataset) FetchTickers(ctx context.Context, tables ...TableName) error {
 // FetchActions downloads "raw" Sharadar actions filtered by 'actions'. If no
 // actions are specified, the default is all actions.
 func (d *Dataset) FetchActions(ctx context.Context, actions ...ActionType) error {
-	it := FetchActions(ctx, actions...)
+	return d.FetchActionsSince(ctx, db.Date{}, actions...)
+}
+
+// FetchActionsSince is the same as FetchActions, but only downloads actions
+// strictly after the given date. Zero date means all dates.
+func (d *Dataset) FetchActionsSince(ctx context.Context, since db.Date, actions ...ActionType) error {
+	it := FetchActionsSince(ctx, since, actions...)
 	for {
 		var a Action
 		ok, err := it.Next(&a)
@@ -143,6 +185,79 @@ func (d *Dataset) FetchActions(ctx context.Context, actions ...ActionType) error
 	return nil
 }
 
+// action2row converts a Sharadar action into the DB format. The second value
+// is false if the action has no DB equivalent.
+func action2row(a Action) (db.ActionRow, bool) {
+	row := db.ActionRow{
+		Date:         a.Date,
+		Value:        a.Value,
+		ContraTicker: a.ContraTicker,
+	}
+	switch a.Action {
+	case SplitAction:
+		row.Type = db.SplitAction
+	case DividendAction:
+		row.Type = db.DividendAction
+	case SpinoffDividendAction:
+		row.Type = db.SpinoffAction
+	case ListedAction:
+		row.Type = db.ListedAction
+	case DelistedAction, RegulatoryDelistingAction, VoluntaryDelistingAction,
+		BankruptcyLiquidationAction:
+		row.Type = db.DelistedAction
+	case AcquisitionByAction, MergerFromAction:
+		row.Type = db.AcquiredAction
+	default:
+		return db.ActionRow{}, false
+	}
+	return row, true
+}
+
+// ConvertActions converts RawActions to the DB format in Actions, skipping any
+// ticker not in TICKERS and any action without a DB equivalent.
+func (d *Dataset) ConvertActions() {
+	for t, actions := range d.RawActions {
+		if _, ok := d.Tickers[t]; !ok {
+			continue
+		}
+		for _, a := range actions {
+			if row, ok := action2row(a); ok {
+				d.Actions[t] = append(d.Actions[t], row)
+			}
+		}
+	}
+}
+
+// Renames extracts the ticker changes from RawActions. A rename may be reported
+// by both the old and the new ticker, in which case it is returned once.
+func (d *Dataset) Renames() []db.Rename {
+	seen := make(map[db.Rename]struct{})
+	var res []db.Rename
+	for t, actions := range d.RawActions {
+		for _, a := range actions {
+			var r db.Rename
+			switch a.Action {
+			case TickerChangeFromAction:
+				r = db.Rename{Date: a.Date, Old: a.ContraTicker, New: t}
+			case TickerChangeToAction:
+				r = db.Rename{Date: a.Date, Old: t, New: a.ContraTicker}
+			default:
+				continue
+			}
+			if r.Old == "" || r.New == "" || r.Old == r.New {
+				continue
+			}
+			if _, ok := seen[r]; ok {
+				continue
+			}
+			seen[r] = struct{}{}
+			res = append(res, r)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Date.Before(res[j].Date) })
+	return res
+}
+
 type pricesResult struct {
 	Prices map[string][]db.PriceRow
 	Error  error
@@ -171,6 +286,24 @@ func (it *rowIter) Next() ([]string, bool) {
 	return row, true
 }
 
+// price2row converts Sharadar's Price into the DB format.
+func price2row(p Price) db.PriceRow {
+	var adjCoeff float32
+	if p.Close != 0 {
+		adjCoeff = p.CloseUnadjusted / p.Close
+	}
+	return db.PriceRow{
+		Date:               p.Date,
+		Close:              p.CloseUnadjusted,
+		CloseSplitAdjusted: p.Close,
+		CloseFullyAdjusted: p.CloseAdjusted,
+		Open:               p.Open * adjCoeff,
+		High:               p.High * adjCoeff,
+		Low:                p.Low * adjCoeff,
+		CashVolume:         p.Close * p.Volume,
+	}
+}
+
 func row2res(colMap map[string]int) func([]string, pricesResult) pricesResult {
 	return func(row []string, res pricesResult) pricesResult {
 		if res.Error != nil {
@@ -185,54 +318,82 @@ func row2res(colMap map[string]int) func([]string, pricesResult) pricesResult {
 		if res.Prices == nil {
 			res.Prices = make(map[string][]db.PriceRow)
 		}
-		var adjCoeff float32
-		if p.Close != 0 {
-			adjCoeff = p.CloseUnadjusted / p.Close
-		}
-		res.Prices[p.Ticker] = append(res.Prices[p.Ticker], db.PriceRow{
-			Date:               p.Date,
-			Close:              p.CloseUnadjusted,
-			CloseSplitAdjusted: p.Close,
-			CloseFullyAdjusted: p.CloseAdjusted,
-			Open:               p.Open * adjCoeff,
-			High:               p.High * adjCoeff,
-			Low:                p.Low * adjCoeff,
-			CashVolume:         p.Close * p.Volume,
-		})
+		res.Prices[p.Ticker] = append(res.Prices[p.Ticker], price2row(p))
 		return res
 	}
 }
 
-// BulkDownloadPrices downloads daily prices using bulk download API. It must be
-// run after downloading TICKERS table, since it will skip any ticker not in
-// TICKERS.
-func (d *Dataset) BulkDownloadPrices(ctx context.Context, table TableName) error {
+// openBulkCSV returns the CSV reader of the bulk download archive of the
+// table, downloading it into the cache first if f is not nil.
+func openBulkCSV(ctx context.Context, table TableName, f *ndl.BulkFile) (*ndl.CSVReader, error) {
+	if f != nil && f.Cached() {
+		logging.Infof(ctx, "reading cached %s", f.Path())
+		return f.OpenCSV()
+	}
 	fullTable := FullTableName(table)
-	logging.Infof(ctx, "initiating bulk download of %s prices", table)
+	logging.Infof(ctx, "initiating bulk download of %s", table)
 	h, err := ndl.BulkDownload(ctx, fullTable)
 	if err != nil {
-		return errors.Annotate(err, "failed to initiate bulk download of %s", table)
+		return nil, errors.Annotate(err,
+			"failed to initiate bulk download of %s", table)
 	}
 	if h.Status != ndl.StatusFresh && h.Status != ndl.StatusRegenerating {
-		return errors.Reason(
+		return nil, errors.Reason(
 			"table %s is not ready for bulk download, status=%s", table, h.Status)
 	}
 	var interval int64 = 10 * 1024 * 1024 // log every 10MB
 	h.MonitorFactory = ndl.LoggingMonitorFactory(ctx, fullTable, interval)
-	r, err := ndl.BulkDownloadCSV(ctx, h)
-	if err != nil {
-		return errors.Annotate(err, "failed to bulk-download CSV data of %s", table)
+	if f == nil {
+		return ndl.BulkDownloadCSV(ctx, h)
 	}
-	defer r.Close()
+	if err := f.Download(ctx, h); err != nil {
+		return nil, errors.Annotate(err, "failed to download into cache")
+	}
+	return f.OpenCSV()
+}
 
+// bulkDownloadCSV initiates the bulk download of the table, or reads its
+// current version from the cache, and returns the CSV reader positioned after
+// the header, and the map of schema columns. When error is nil, the caller must
+// close the reader.
+func (d *Dataset) bulkDownloadCSV(ctx context.Context, table TableName, schema ndl.Schema) (*ndl.CSVReader, map[string]int, error) {
+	var f *ndl.BulkFile
+	if d.BulkCache != "" {
+		fullTable := FullTableName(table)
+		m, err := ndl.FetchTableMetadata(ctx, fullTable)
+		if err != nil {
+			return nil, nil, errors.Annotate(err,
+				"failed to fetch metadata of %s", table)
+		}
+		f = ndl.NewBulkFile(d.BulkCache, fullTable, m)
+	}
+	r, err := openBulkCSV(ctx, table, f)
+	if err != nil {
+		return nil, nil, errors.Annotate(err,
+			"failed to bulk-download CSV data of %s", table)
+	}
 	header, err := r.Read()
 	if err != nil {
-		return errors.Annotate(err, "failed to read CSV header")
+		r.Close()
+		return nil, nil, errors.Annotate(err, "failed to read CSV header")
 	}
-	colMap, err := PriceSchema.MapCSVColumns(header)
+	colMap, err := schema.MapCSVColumns(header)
 	if err != nil {
-		return errors.Annotate(err, "unexpected CSV header")
+		r.Close()
+		return nil, nil, errors.Annotate(err, "unexpected CSV header")
 	}
+	return r, colMap, nil
+}
+
+// BulkDownloadPrices downloads daily prices using bulk download API. It must be
+// run after downloading TICKERS table, since it will skip any ticker not in
+// TICKERS.
+func (d *Dataset) BulkDownloadPrices(ctx context.Context, table TableName) error {
+	r, colMap, err := d.bulkDownloadCSV(ctx, table, PriceSchema)
+	if err != nil {
+		return errors.Annotate(err, "failed to bulk-download %s", table)
+	}
+	defer r.Close()
 
 	logging.Infof(ctx, "unzipping the prices CSV file...")
 
@@ -283,50 +444,319 @@ func (d *Dataset) BulkDownloadPrices(ctx context.Context, table TableName) error
 	return nil
 }
 
-// DownloadAll - tickers, actions and prices for the requested tables.
-func (d *Dataset) DownloadAll(ctx context.Context, dbPath, dbName string, tables ...TableName) error {
-	if len(tables) == 0 {
-		tables = []TableName{EquitiesTable, FundsTable}
+// rowBatch is a batch of consecutive CSV rows with its sequential number.
+type rowBatch struct {
+	Index int
+	Rows  [][]string
+}
+
+// rowBatchIter groups CSV rows into numbered batches, so the batches parsed in
+// parallel can be put back in order.
+type rowBatchIter struct {
+	rows  *rowIter
+	size  int
+	index int
+}
+
+var _ iterator.Iterator[rowBatch] = &rowBatchIter{}
+
+func (it *rowBatchIter) Next() (rowBatch, bool) {
+	b := rowBatch{Index: it.index}
+	for len(b.Rows) < it.size {
+		row, ok := it.rows.Next()
+		if !ok {
+			break
+		}
+		b.Rows = append(b.Rows, row)
 	}
-	logging.Infof(ctx, "fetching tickers for %s...", strings.Join(tables, ", "))
-	if err := d.FetchTickers(ctx, tables...); err != nil {
-		return errors.Annotate(err, "failed to fetch tickers")
+	if len(b.Rows) == 0 {
+		return rowBatch{}, false
 	}
-	logging.Infof(ctx, "downloaded %d tickers", len(d.Tickers))
-	currPrices := 0
-	for _, t := range tables {
-		logging.Infof(ctx, "bulk-downloading %s prices", t)
-		if err := d.BulkDownloadPrices(ctx, t); err != nil {
-			return errors.Annotate(err, "failed to download %s price table", t)
+	it.index++
+	return b, true
+}
+
+// tickerPrices are the prices of a ticker from consecutive CSV rows.
+type tickerPrices struct {
+	Ticker string
+	Prices []db.PriceRow
+}
+
+// pricesBatch is the parsed rowBatch split into runs of the same ticker.
+type pricesBatch struct {
+	Index int
+	Runs  []tickerPrices
+	Error error
+}
+
+func parseBatch(colMap map[string]int) func(rowBatch) pricesBatch {
+	return func(b rowBatch) pricesBatch {
+		res := pricesBatch{Index: b.Index}
+		for _, row := range b.Rows {
+			var p Price
+			if err := p.FromCSV(row, colMap); err != nil {
+				res.Error = errors.Annotate(err, "failed to parse CSV row")
+				return res
+			}
+			n := len(res.Runs)
+			if n == 0 || res.Runs[n-1].Ticker != p.Ticker {
+				res.Runs = append(res.Runs, tickerPrices{Ticker: p.Ticker})
+				n++
+			}
+			res.Runs[n-1].Prices = append(res.Runs[n-1].Prices, price2row(p))
+		}
+		return res
+	}
+}
+
+// PriceSink receives the complete prices of a ticker sorted by date.
+type PriceSink = func(ticker string, prices []db.PriceRow) error
+
+// BulkStreamPrices is the streaming version of BulkDownloadPrices. Rather than
+// accumulating all the prices in d.Prices, it relies on the bulk CSV being
+// sorted by ticker, and passes the prices of each ticker to the sink as soon as
+// the next ticker begins. Thus, only the prices of a single ticker and the
+// batches being parsed in parallel are kept in memory. It is an error if the
+// rows of a ticker are not consecutive. Similar to BulkDownloadPrices, it must
+// be run after downloading TICKERS table, and it skips any ticker not in
+// TICKERS. It also skips the tickers listed in TICKERS for a different table,
+// so a ticker present in several tables is streamed only once.
+func (d *Dataset) BulkStreamPrices(ctx context.Context, table TableName, sink PriceSink) error {
+	r, colMap, err := d.bulkDownloadCSV(ctx, table, PriceSchema)
+	if err != nil {
+		return errors.Annotate(err, "failed to bulk-download %s", table)
+	}
+	defer r.Close()
+
+	logging.Infof(ctx, "streaming the prices CSV file...")
+
+	rows := &rowIter{reader: r}
+	batches := &rowBatchIter{rows: rows, size: 10000}
+	m := iterator.ParallelMap[rowBatch, pricesBatch](
+		ctx, runtime.NumCPU(), batches, parseBatch(colMap))
+	defer m.Close()
+
+	var curr tickerPrices
+	seen := make(map[string]struct{}) // tickers already streamed or skipped
+	flush := func() error {
+		t := curr.Ticker
+		if t == "" {
+			return nil
+		}
+		if _, ok := seen[t]; ok {
+			return errors.Reason("%s prices are not consecutive in the CSV", t)
+		}
+		seen[t] = struct{}{}
+		row, ok := d.Tickers[t]
+		if !ok {
+			logging.Warningf(ctx, "skipping %s prices, it's not in TICKERS table", t)
+			return nil
+		}
+		if row.Source != table {
+			logging.Warningf(ctx, "skipping %s prices in %s, it's listed in %s",
+				t, table, row.Source)
+			return nil
+		}
+		prices := curr.Prices
+		sort.Slice(prices, func(i, j int) bool {
+			return prices[i].Date.Before(prices[j].Date)
+		})
+		d.NumPrices += len(prices)
+		if err := sink(t, prices); err != nil {
+			return errors.Annotate(err, "failed to process %s prices", t)
 		}
-		logging.Infof(ctx, "downloaded %d %s prices", d.NumPrices-currPrices, t)
-		currPrices = d.NumPrices
+		if len(seen)%1000 == 0 {
+			logging.Debugf(ctx, "streamed prices for %d tickers", len(seen))
+		}
+		return nil
+	}
+
+	pending := make(map[int]pricesBatch) // batches parsed ahead of their turn
+	next := 0
+	for b, ok := m.Next(); ok; b, ok = m.Next() {
+		if b.Error != nil {
+			return errors.Annotate(b.Error, "failed to parse CSV")
+		}
+		pending[b.Index] = b
+		for b, ok := pending[next]; ok; b, ok = pending[next] {
+			delete(pending, next)
+			next++
+			for _, run := range b.Runs {
+				if run.Ticker == curr.Ticker {
+					curr.Prices = append(curr.Prices, run.Prices...)
+					continue
+				}
+				if err := flush(); err != nil {
+					return err
+				}
+				curr = run
+			}
+		}
+	}
+	if rows.Error != nil {
+		return errors.Annotate(rows.Error, "failed to read CSV")
+	}
+	return flush()
+}
+
+// fundamentals2row converts Sharadar's Fundamentals into the DB format.
+func fundamentals2row(f Fundamentals) (db.FundamentalsRow, error) {
+	dim, err := db.NewDimension(f.Dimension)
+	if err != nil {
+		return db.FundamentalsRow{}, errors.Annotate(err, "invalid dimension")
 	}
-	logging.Infof(ctx, "downloaded total %d prices", d.NumPrices)
-	logging.Infof(ctx, "writing tickers...")
-	w := db.NewWriter(dbPath, dbName)
-	if err := w.WriteTickers(d.Tickers); err != nil {
-		return errors.Annotate(err, "failed to write tickers")
+	return db.FundamentalsRow{
+		Dimension:         dim,
+		DateKey:           f.DateKey,
+		ReportPeriod:      f.ReportPeriod,
+		Revenue:           f.Revenue,
+		CostOfRevenue:     f.CostOfRevenue,
+		GrossProfit:       f.GrossProfit,
+		OperatingIncome:   f.OperatingIncome,
+		NetIncome:         f.NetIncome,
+		EBITDA:            f.EBITDA,
+		EPS:               f.EPS,
+		EPSDiluted:        f.EPSDiluted,
+		DividendsPerShare: f.DividendsPerShare,
+		OperatingCashFlow: f.OperatingCashFlow,
+		FreeCashFlow:      f.FreeCashFlow,
+		Assets:            f.Assets,
+		Liabilities:       f.Liabilities,
+		Equity:            f.Equity,
+		Debt:              f.Debt,
+		Cash:              f.Cash,
+		Shares:            f.Shares,
+		MarketCap:         f.MarketCap,
+		EV:                f.EV,
+		PE:                f.PE,
+		PS:                f.PS,
+		PB:                f.PB,
+		GrossMargin:       f.GrossMargin,
+		NetMargin:         f.NetMargin,
+		ROE:               f.ROE,
+	}, nil
+}
+
+// BulkDownloadFundamentals downloads the SF1 fundamentals table using bulk
+// download API. Similar to BulkDownloadPrices, it must be run after
+// downloading TICKERS table, and it skips any ticker not in TICKERS. The rows
+// for each ticker are sorted by dimension and DateKey.
+func (d *Dataset) BulkDownloadFundamentals(ctx context.Context) error {
+	r, colMap, err := d.bulkDownloadCSV(ctx, FundamentalsTable, FundamentalsSchema)
+	if err != nil {
+		return errors.Annotate(err, "failed to bulk-download %s", FundamentalsTable)
 	}
-	logging.Infof(ctx, "writing prices...")
-	for ticker, prices := range d.Prices {
-		if err := w.WritePrices(ticker, prices); err != nil {
-			return errors.Annotate(err, "failed to write prices for %s", ticker)
+	defer r.Close()
+
+	logging.Infof(ctx, "unzipping the fundamentals CSV file...")
+	skippedTickers := make(map[string]struct{}) // dedup log messages
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Annotate(err, "failed to read CSV")
+		}
+		var f Fundamentals
+		if err := f.FromCSV(row, colMap); err != nil {
+			return errors.Annotate(err, "failed to parse CSV row")
+		}
+		if _, ok := d.Tickers[f.Ticker]; !ok {
+			if _, ok = skippedTickers[f.Ticker]; !ok {
+				logging.Warningf(ctx,
+					"skipping %s fundamentals, it's not in TICKERS table", f.Ticker)
+				skippedTickers[f.Ticker] = struct{}{}
+			}
+			continue
+		}
+		fr, err := fundamentals2row(f)
+		if err != nil {
+			return errors.Annotate(err, "failed to convert %s fundamentals", f.Ticker)
 		}
-		d.Monthly[ticker] = db.ComputeMonthly(prices)
+		d.Fundamentals[f.Ticker] = append(d.Fundamentals[f.Ticker], fr)
+		d.NumFundamentals++
 	}
-	logging.Infof(ctx, "writing monthly resampled prices...")
-	if err := w.WriteMonthly(d.Monthly); err != nil {
-		return errors.Annotate(err, "failed to write monthly prices")
+	for _, rows := range d.Fundamentals {
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].Dimension != rows[j].Dimension {
+				return rows[i].Dimension < rows[j].Dimension
+			}
+			return rows[i].DateKey.Before(rows[j].DateKey)
+		})
 	}
-	logging.Infof(ctx, "writing metadata...")
-	if err := w.WriteMetadata(w.Metadata); err != nil {
-		return errors.Annotate(err, "failed to write metadata")
+	return nil
+}
+
+// splitTables separates the price tables from the fundamentals table. The
+// second value is true if the fundamentals table is requested. If no price
+// tables are given, the default is all price tables.
+func splitTables(tables []TableName) ([]TableName, bool) {
+	priceTables := []TableName{}
+	fundamentals := false
+	for _, t := range tables {
+		if t == FundamentalsTable {
+			fundamentals = true
+			continue
+		}
+		priceTables = append(priceTables, t)
 	}
-	logging.Infof(ctx, "cleaning up...")
-	if err := db.Cleanup(ctx, dbPath, dbName); err != nil {
-		return errors.Annotate(err, "failed to clean up DB")
+	if len(priceTables) == 0 {
+		priceTables = []TableName{EquitiesTable, FundsTable}
+	}
+	return priceTables, fundamentals
+}
+
+// FetchPrices downloads daily prices of the given table strictly after the
+// given date (zero date means all dates) using the paging table API, which is
+// more efficient than the bulk download for a small number of rows. When no
+// tickers are supplied, the default is all tickers. Similar to
+// BulkDownloadPrices, it skips any ticker not in TICKERS, and the prices for
+// each updated ticker are sorted by date.
+func (d *Dataset) FetchPrices(ctx context.Context, table TableName, since db.Date, tickers ...string) error {
+	it := FetchPrices(ctx, table, since, tickers...)
+	updated := make(map[string]struct{})
+	skippedTickers := make(map[string]struct{}) // dedup log messages
+	for {
+		var p Price
+		ok, err := it.Next(&p)
+		if err != nil {
+			return errors.Annotate(err, "failed to read %s prices", table)
+		}
+		if !ok {
+			break
+		}
+		if _, ok := d.Tickers[p.Ticker]; !ok {
+			if _, ok = skippedTickers[p.Ticker]; !ok {
+				logging.Warningf(ctx, "skipping %s prices, it's not in TICKERS table",
+					p.Ticker)
+				skippedTickers[p.Ticker] = struct{}{}
+			}
+			continue
+		}
+		d.Prices[p.Ticker] = append(d.Prices[p.Ticker], price2row(p))
+		d.NumPrices++
+		updated[p.Ticker] = struct{}{}
+	}
+	for t := range updated {
+		prices := d.Prices[t]
+		sort.Slice(prices, func(i, j int) bool {
+			return prices[i].Date.Before(prices[j].Date)
+		})
 	}
-	logging.Infof(ctx, "all done.")
 	return nil
 }
+
+// DownloadAll - tickers, actions and prices for the requested tables. If
+// FundamentalsTable is among the tables, it also downloads the fundamentals for
+// the tickers of the price tables. See provider.DownloadAll for details.
+func (d *Dataset) DownloadAll(ctx context.Context, dbPath, dbName string, tables ...TableName) error {
+	return provider.DownloadAll(ctx, NewProvider(d, tables...), dbPath, dbName)
+}
+
+// UpdateAll incrementally updates an existing DB with the requested tables, see
+// provider.UpdateAll for details. The price tables are fetched using the paging
+// table API, and fundamentals, if requested, are always re-downloaded in full.
+func (d *Dataset) UpdateAll(ctx context.Context, dbPath, dbName string, tables ...TableName) error {
+	return provider.UpdateAll(ctx, NewProvider(d, tables...), dbPath, dbName)
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stockparfait/fetch"
@@ -57,7 +58,7 @@ func TestSharadar(t *testing.T) {
 				"2000-01-01", "2020-03-31", "https://sec.filings", "https://com.site",
 			},
 			{
-				"SFP", 200, "C", "Name3", "Exch3", "Y", "Cat3", "CUSIP5 CUSIP6",
+				"SFP", 300, "C", "Name3", "Exch3", "Y", "Cat3", "CUSIP5 CUSIP6",
 				333, "SICSec3", "SICInd3", "FAMASec3", "FAMAInd3", "Sec3", "Ind3",
 				"2 - Micro", "5 - Large", "RT5 RT6", "EUR", "Where",
 				"2020-02-22", "2000-01-11", "2000-02-01", "2020-02-22",
@@ -73,36 +74,42 @@ func TestSharadar(t *testing.T) {
 			expected := map[string]db.TickerRow{
 				"A": {
 					Source:      "SEP",
+					ID:          "100",
 					Exchange:    "Exch1",
 					Name:        "Name1",
 					Category:    "Cat1",
 					Sector:      "Sec1",
 					Industry:    "Ind1",
 					Location:    "Here",
+					Currency:    "USD",
 					SECFilings:  "https://sec.filings",
 					CompanySite: "https://com.site",
 					Active:      true,
 				},
 				"B": {
 					Source:      "SFP",
+					ID:          "200",
 					Exchange:    "Exch2",
 					Name:        "Name2",
 					Category:    "Cat2",
 					Sector:      "Sec2",
 					Industry:    "Ind2",
 					Location:    "There",
+					Currency:    "RUB",
 					SECFilings:  "https://sec.filings",
 					CompanySite: "https://com.site",
 					Active:      false,
 				},
 				"C": {
 					Source:      "SFP",
+					ID:          "300",
 					Exchange:    "Exch3",
 					Name:        "Name3",
 					Category:    "Cat3",
 					Sector:      "Sec3",
 					Industry:    "Ind3",
 					Location:    "Where",
+					Currency:    "EUR",
 					SECFilings:  "https://sec.filings",
 					CompanySite: "https://com.site",
 					Active:      false,
@@ -169,7 +176,7 @@ func TestSharadar(t *testing.T) {
 			Convey("for selected actions", func() {
 				So(ds.FetchActions(ctx, RelevantActions...), ShouldBeNil)
 				So(server.RequestQuery["action"], ShouldResemble,
-					[]string{"acquisitionby,delisted,dividend,listed,mergerfrom,regulatorydelisting,spinoffdividend,split,voluntarydelisting"})
+					[]string{"acquisitionby,delisted,dividend,listed,mergerfrom,regulatorydelisting,spinoffdividend,split,tickerchangefrom,tickerchangeto,voluntarydelisting"})
 			})
 		})
 
@@ -259,6 +266,123 @@ C,2019-09-24,19.74,19.75,19.73,19.75,138502.0,19.75,19.75,2019-09-24
 			ds.Tickers["B"] = db.TickerRow{}
 			So(ds.BulkDownloadPrices(ctx, EquitiesTable), ShouldBeNil)
 			So(ds.Prices, ShouldResemble, expected)
+
+			Convey("with the bulk cache", func() {
+				tmpdir, tmpdirErr := os.MkdirTemp("", "testbulkcache")
+				So(tmpdirErr, ShouldBeNil)
+				defer os.RemoveAll(tmpdir)
+
+				metaJSON := `{"datatable": {
+  "status": {"refreshed_at": "2021-11-09T22:51:22.000Z"},
+  "data_version": {"code": "1"}
+}}`
+				server.ResponseBody = []string{metaJSON, bulkJSON, bulkZipStr}
+				ds := NewDataset()
+				ds.BulkCache = tmpdir
+				ds.Tickers["A"] = db.TickerRow{}
+				ds.Tickers["B"] = db.TickerRow{}
+				So(ds.BulkDownloadPrices(ctx, EquitiesTable), ShouldBeNil)
+				So(ds.Prices, ShouldResemble, expected)
+				So(testutil.FileExists(filepath.Join(
+					tmpdir, "SHARADAR_SEP-1-20211109T225122.zip")), ShouldBeTrue)
+
+				// The same version is read from the cache.
+				server.ResponseBody = []string{metaJSON}
+				ds.Prices = make(map[string][]db.PriceRow)
+				So(ds.BulkDownloadPrices(ctx, EquitiesTable), ShouldBeNil)
+				So(ds.Prices, ShouldResemble, expected)
+				So(server.RequestPath, ShouldEqual,
+					"/api/v3/datatables/SHARADAR/SEP/metadata.json")
+			})
+
+			Convey("streaming one ticker at a time", func() {
+				server.ResponseBody = []string{bulkJSON, bulkZipStr}
+				ds := NewDataset()
+				ds.Tickers["A"] = db.TickerRow{Source: EquitiesTable}
+				ds.Tickers["B"] = db.TickerRow{Source: EquitiesTable}
+				var tickers []string
+				streamed := make(map[string][]db.PriceRow)
+				So(ds.BulkStreamPrices(ctx, EquitiesTable,
+					func(t string, prices []db.PriceRow) error {
+						tickers = append(tickers, t)
+						streamed[t] = prices
+						return nil
+					}), ShouldBeNil)
+				So(tickers, ShouldResemble, []string{"A", "B"})
+				So(streamed, ShouldResemble, expected)
+				So(ds.NumPrices, ShouldEqual, 4)
+				So(len(ds.Prices), ShouldEqual, 0)
+			})
+
+			Convey("streaming only the tickers of the table", func() {
+				server.ResponseBody = []string{bulkJSON, bulkZipStr}
+				ds := NewDataset()
+				ds.Tickers["A"] = db.TickerRow{Source: EquitiesTable}
+				ds.Tickers["B"] = db.TickerRow{Source: FundsTable}
+				var tickers []string
+				So(ds.BulkStreamPrices(ctx, EquitiesTable,
+					func(t string, prices []db.PriceRow) error {
+						tickers = append(tickers, t)
+						return nil
+					}), ShouldBeNil)
+				So(tickers, ShouldResemble, []string{"A"})
+				So(ds.NumPrices, ShouldEqual, 2)
+			})
+
+			Convey("streaming fails when not sorted by ticker", func() {
+				var unsortedZip bytes.Buffer
+				zipW := zip.NewWriter(&unsortedZip)
+				w, err := zipW.Create("test.csv")
+				So(err, ShouldBeNil)
+				_, err = bytes.NewBufferString(bulkCSVRaw +
+					"A,2021-11-10,0.3,0.33,0.3,0.33,7500.0,0.33,0.33,2021-11-10\n").WriteTo(w)
+				So(err, ShouldBeNil)
+				So(zipW.Close(), ShouldBeNil)
+
+				server.ResponseBody = []string{bulkJSON, unsortedZip.String()}
+				ds := NewDataset()
+				ds.Tickers["A"] = db.TickerRow{Source: EquitiesTable}
+				err = ds.BulkStreamPrices(ctx, EquitiesTable,
+					func(t string, prices []db.PriceRow) error { return nil })
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "A prices are not consecutive")
+			})
+		})
+
+		Convey("BulkDownloadFundamentals", func() {
+			fundamentalsCSVRaw := `ticker,dimension,calendardate,datekey,reportperiod,lastupdated,revenue,cor,gp,opinc,netinc,ebitda,eps,epsdil,dps,ncfo,fcf,assets,liabilities,equity,debt,cashneq,shareswa,marketcap,ev,pe,ps,pb,grossmargin,netmargin,roe,extra
+A,ARQ,2021-06-30,2021-08-01,2021-06-30,2021-08-02,200,,,,20,,,,,,,,,,,,,,,12.5,,,,0.1,,x
+A,ARQ,2021-03-31,2021-05-01,2021-03-31,2021-05-02,100,,,,10,,,,,,,,,,,,,,,10,,,,0.1,,x
+A,MRQ,2021-03-31,2021-05-01,2021-03-31,2021-05-02,100,,,,10,,,,,,,,,,,,,,,10,,,,0.1,,x
+C,ARQ,2021-03-31,2021-05-01,2021-03-31,2021-05-02,100,,,,10,,,,,,,,,,,,,,,10,,,,0.1,,x
+`
+			var fZip bytes.Buffer
+			zipW := zip.NewWriter(&fZip)
+			w, err := zipW.Create("test.csv")
+			So(err, ShouldBeNil)
+			_, err = bytes.NewBufferString(fundamentalsCSVRaw).WriteTo(w)
+			So(err, ShouldBeNil)
+			So(zipW.Close(), ShouldBeNil)
+			server.ResponseBody = []string{bulkJSON, fZip.String()}
+
+			row := func(dim db.Dimension, dateKey, period db.Date, revenue, netinc, pe float32) db.FundamentalsRow {
+				r := db.TestFundamentals(dim, dateKey, period, revenue, netinc)
+				r.PE = pe
+				r.NetMargin = 0.1
+				return r
+			}
+
+			ds := NewDataset()
+			ds.Tickers["A"] = db.TickerRow{}
+			So(ds.BulkDownloadFundamentals(ctx), ShouldBeNil)
+			So(ds.NumFundamentals, ShouldEqual, 3)
+			So(ds.Fundamentals, ShouldResemble, map[string][]db.FundamentalsRow{
+				"A": {
+					row(db.ARQ, db.NewDate(2021, 5, 1), db.NewDate(2021, 3, 31), 100, 10, 10),
+					row(db.ARQ, db.NewDate(2021, 8, 1), db.NewDate(2021, 6, 30), 200, 20, 12.5),
+					row(db.MRQ, db.NewDate(2021, 5, 1), db.NewDate(2021, 3, 31), 100, 10, 10),
+				},
+			})
 		})
 
 		Convey("DownloadAll", func() {
@@ -270,6 +394,7 @@ C,2019-09-24,19.74,19.75,19.73,19.75,138502.0,19.75,19.75,2019-09-24
 
 			server.ResponseBody = []string{
 				tickersPage,
+				actionsPage,
 				bulkJSON,
 				bulkZipStr,
 			}
@@ -279,13 +404,131 @@ C,2019-09-24,19.74,19.75,19.73,19.75,138502.0,19.75,19.75,2019-09-24
 			d := db.NewReader(tmpdir, dbName)
 			meta, err := d.Metadata()
 			So(err, ShouldBeNil)
+			// Only A is listed in SEP, the prices of B and C are in SFP.
 			So(meta, ShouldResemble, db.Metadata{
-				Start:      db.NewDate(2019, 9, 24),
-				End:        db.NewDate(2021, 11, 9),
-				NumTickers: 3,
-				NumPrices:  5,
-				NumMonthly: 3,
+				Start:        db.NewDate(2021, 11, 8),
+				End:          db.NewDate(2021, 11, 9),
+				NumTickers:   3,
+				NumPrices:    2,
+				NumWeekly:    1,
+				NumMonthly:   1,
+				NumQuarterly: 1,
+				NumYearly:    1,
+				NumActions:   3,
+			})
+			So(d.HasPrices("B"), ShouldBeFalse)
+			actions, err := d.Actions("A")
+			So(err, ShouldBeNil)
+			So(actions, ShouldResemble, []db.ActionRow{
+				db.TestAction(db.NewDate(2000, 1, 1), db.SplitAction, 2.0, "CT1"),
+				db.TestAction(db.NewDate(2001, 1, 1), db.DividendAction, 1.23, ""),
 			})
 		})
+
+		Convey("UpdateAll", func() {
+			tmpdir, tmpdirErr := os.MkdirTemp("", "testupdate")
+			So(tmpdirErr, ShouldBeNil)
+			defer os.RemoveAll(tmpdir)
+
+			dbName := "testdb"
+
+			server.ResponseBody = []string{
+				tickersPage,
+				actionsPage,
+				bulkJSON,
+				bulkZipStr,
+			}
+			So(NewDataset().DownloadAll(ctx, tmpdir, dbName, EquitiesTable), ShouldBeNil)
+
+			updateActionsPage, err := ndl.TestTablePage([][]ndl.Value{
+				{"2021-11-10", "split", "B", "Name2", 2.0, "", ""},
+				{"2021-11-10", "tickerchangefrom", "A", "Name1", 0.0, "OLDA", ""},
+				{"2021-11-10", "tickerchangeto", "OLDA", "Name1", 0.0, "A", ""},
+			}, ActionSchema, "")
+			So(err, ShouldBeNil)
+
+			updateEquitiesPage, err := ndl.TestTablePage([][]ndl.Value{
+				{"A", "2021-11-10", 0.34, 0.34, 0.34, 0.34, 100.0, 0.34, 0.34, "2021-11-10"},
+			}, PriceSchema, "")
+			So(err, ShouldBeNil)
+
+			updateFundsPage, err := ndl.TestTablePage([][]ndl.Value{
+				{"B", "2021-11-10", 5.0, 5.0, 5.0, 5.0, 100.0, 5.0, 10.0, "2021-11-10"},
+				{"C", "2021-12-01", 20.0, 20.0, 20.0, 20.0, 100.0, 20.0, 20.0, "2021-12-01"},
+			}, PriceSchema, "")
+			So(err, ShouldBeNil)
+
+			// Full history of B adjusted for the new split.
+			staleB, err := ndl.TestTablePage([][]ndl.Value{
+				{"B", "2021-09-24", 4.87, 4.87, 4.87, 4.875, 77004.0, 4.875, 9.75, "2021-11-10"},
+				{"B", "2021-09-23", 4.97, 5.45, 4.75, 2.5, 5384.0, 2.5, 10.0, "2021-11-10"},
+				{"B", "2021-11-10", 5.0, 5.0, 5.0, 5.0, 100.0, 5.0, 10.0, "2021-11-10"},
+			}, PriceSchema, "")
+			So(err, ShouldBeNil)
+
+			server.ResponseBody = []string{
+				tickersPage,
+				updateActionsPage,
+				updateEquitiesPage,
+				updateFundsPage,
+				staleB,
+			}
+			So(NewDataset().UpdateAll(ctx, tmpdir, dbName, EquitiesTable, FundsTable),
+				ShouldBeNil)
+
+			r := db.NewReader(tmpdir, dbName)
+			meta, err := r.Metadata()
+			So(err, ShouldBeNil)
+			So(meta, ShouldResemble, db.Metadata{
+				Start:        db.NewDate(2021, 9, 23),
+				End:          db.NewDate(2021, 12, 1),
+				NumTickers:   3,
+				NumPrices:    7,
+				NumWeekly:    4,
+				NumMonthly:   4,
+				NumQuarterly: 4,
+				NumYearly:    3,
+				NumActions:   4,
+			})
+
+			actionsB, err := r.Actions("B")
+			So(err, ShouldBeNil)
+			So(actionsB, ShouldResemble, []db.ActionRow{
+				db.TestAction(db.NewDate(2000, 2, 1), db.ListedAction, 0.0, ""),
+				db.TestAction(db.NewDate(2021, 11, 10), db.SplitAction, 2.0, ""),
+			})
+
+			symbols, err := r.AllSymbolRows()
+			So(err, ShouldBeNil)
+			So(symbols, ShouldResemble, map[string][]db.SymbolRow{
+				"OLDA": {{End: db.NewDate(2021, 11, 10), ID: "100"}},
+			})
+			ticker, err := r.Resolve("OLDA", db.NewDate(2021, 11, 9))
+			So(err, ShouldBeNil)
+			So(ticker, ShouldEqual, "A")
+
+			pricesA, err := r.Prices("A")
+			So(err, ShouldBeNil)
+			So(len(pricesA), ShouldEqual, 3)
+			So(pricesA[2].Date, ShouldResemble, db.NewDate(2021, 11, 10))
+
+			pricesB, err := r.Prices("B")
+			So(err, ShouldBeNil)
+			So(len(pricesB), ShouldEqual, 3)
+			So(pricesB[0].Date, ShouldResemble, db.NewDate(2021, 9, 23))
+			So(pricesB[0].CloseSplitAdjusted, ShouldEqual, 2.5)
+			So(pricesB[1].CloseSplitAdjusted, ShouldEqual, 4.875)
+
+			monthlyA, err := r.Monthly("A", db.Date{}, db.Date{})
+			So(err, ShouldBeNil)
+			So(len(monthlyA), ShouldEqual, 1)
+			So(monthlyA[0].NumSamples, ShouldEqual, 3)
+			So(monthlyA[0].DateClose, ShouldResemble, db.NewDate(2021, 11, 10))
+
+			monthlyB, err := r.Monthly("B", db.Date{}, db.Date{})
+			So(err, ShouldBeNil)
+			So(len(monthlyB), ShouldEqual, 2)
+			So(monthlyB[0].OpenSplitAdjusted, ShouldEqual, 2.5)
+		})
 	})
 }
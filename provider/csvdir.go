@@ -0,0 +1,198 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/stockparfait/errors"
+	"github.com/stockparfait/stockparfait/db"
+)
+
+// CSVDir is a Provider reading a directory of CSV files in the format of
+// parfait-list output:
+//
+//	tickers.csv              - the tickers table
+//	actions/<TICKER>.csv     - actions of each ticker (optional)
+//	prices/<TICKER>.csv      - daily prices of each ticker
+//
+// The column names of the tickers and the prices can be customized by the
+// configs. The actions must have the columns of db.ActionRowHeader(), of which
+// only Date and Action are required.
+type CSVDir struct {
+	Dir           string
+	TickersConfig *db.TickerRowConfig
+	PricesConfig  *db.PriceRowConfig
+}
+
+var _ Provider = &CSVDir{}
+
+// NewCSVDir creates a CSVDir provider with the default configs.
+func NewCSVDir(dir string) *CSVDir {
+	return &CSVDir{
+		Dir:           dir,
+		TickersConfig: db.NewTickerRowConfig(),
+		PricesConfig:  db.NewPriceRowConfig(),
+	}
+}
+
+// Tickers implements Provider.
+func (c *CSVDir) Tickers(ctx context.Context) (map[string]db.TickerRow, error) {
+	fileName := filepath.Join(c.Dir, "tickers.csv")
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Annotate(err, "cannot open tickers file '%s'", fileName)
+	}
+	defer f.Close()
+
+	tickers := make(map[string]db.TickerRow)
+	if err := db.ReadCSVTickers(f, c.TickersConfig, tickers); err != nil {
+		return nil, errors.Annotate(err, "failed to read tickers from '%s'", fileName)
+	}
+	return tickers, nil
+}
+
+// listTickers returns the tickers of the CSV files in the subdirectory. A
+// missing subdirectory has no tickers.
+func (c *CSVDir) listTickers(subdir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(c.Dir, subdir, "*.csv"))
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to list %s files", subdir)
+	}
+	tickers := make([]string, len(files))
+	for i, f := range files {
+		tickers[i] = strings.TrimSuffix(filepath.Base(f), ".csv")
+	}
+	return tickers, nil
+}
+
+// Actions implements Provider.
+func (c *CSVDir) Actions(ctx context.Context, since db.Date) (map[string][]db.ActionRow, error) {
+	tickers, err := c.listTickers("actions")
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to list actions")
+	}
+	res := make(map[string][]db.ActionRow)
+	for _, t := range tickers {
+		fileName := filepath.Join(c.Dir, "actions", t+".csv")
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, errors.Annotate(err, "cannot open actions file '%s'", fileName)
+		}
+		actions, err := readCSVActions(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read actions from '%s'", fileName)
+		}
+		for _, a := range actions {
+			if a.Date.After(since) {
+				res[t] = append(res[t], a)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Prices implements Provider. A ticker without a prices file has no prices.
+func (c *CSVDir) Prices(ctx context.Context, since db.Date, tickers ...string) (map[string][]db.PriceRow, error) {
+	if len(tickers) == 0 {
+		var err error
+		if tickers, err = c.listTickers("prices"); err != nil {
+			return nil, errors.Annotate(err, "failed to list prices")
+		}
+	}
+	res := make(map[string][]db.PriceRow)
+	for _, t := range tickers {
+		fileName := filepath.Join(c.Dir, "prices", t+".csv")
+		f, err := os.Open(fileName)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Annotate(err, "cannot open prices file '%s'", fileName)
+		}
+		prices, err := db.ReadCSVPrices(f, c.PricesConfig)
+		f.Close()
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read prices from '%s'", fileName)
+		}
+		for _, p := range prices {
+			if p.Date.After(since) {
+				res[t] = append(res[t], p)
+			}
+		}
+	}
+	return res, nil
+}
+
+// readCSVActions reads the actions of a single ticker with the header of
+// db.ActionRowHeader() and returns them sorted by date.
+func readCSVActions(r io.Reader) ([]db.ActionRow, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Annotate(err, "failed to read actions from CSV")
+	}
+	if len(rows) <= 1 {
+		return nil, nil
+	}
+	colMap := make(map[string]int)
+	for i, h := range rows[0] {
+		colMap[h] = i
+	}
+	header := db.ActionRowHeader()
+	dateCol, ok := colMap[header[0]]
+	if !ok {
+		return nil, errors.Reason("actions CSV requires a %s column", header[0])
+	}
+	actionCol, ok := colMap[header[1]]
+	if !ok {
+		return nil, errors.Reason("actions CSV requires a %s column", header[1])
+	}
+	valueCol, hasValue := colMap[header[2]]
+	contraCol, hasContra := colMap[header[3]]
+
+	var actions []db.ActionRow
+	for i, row := range rows[1:] {
+		var a db.ActionRow
+		if a.Date, err = db.NewDateFromString(row[dateCol]); err != nil {
+			return nil, errors.Annotate(err, "row %d: failed to parse date", i)
+		}
+		if a.Type, err = db.NewActionType(row[actionCol]); err != nil {
+			return nil, errors.Annotate(err, "row %d: failed to parse action", i)
+		}
+		if hasValue && row[valueCol] != "" {
+			v, err := strconv.ParseFloat(row[valueCol], 32)
+			if err != nil {
+				return nil, errors.Annotate(err, "row %d: failed to parse value", i)
+			}
+			a.Value = float32(v)
+		}
+		if hasContra {
+			a.ContraTicker = row[contraCol]
+		}
+		actions = append(actions, a)
+	}
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].Date.Before(actions[j].Date)
+	})
+	return actions, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stockparfait/stockparfait/db"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeFile(fileName, contents string) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, []byte(contents), 0644)
+}
+
+func TestCSVDir(t *testing.T) {
+	t.Parallel()
+
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testcsvdir")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	Convey("CSVDir works", t, func() {
+		ctx := context.Background()
+		dir, err := os.MkdirTemp(tmpdir, "csv") // fresh for each Convey leaf
+		So(err, ShouldBeNil)
+		So(writeFile(filepath.Join(dir, "tickers.csv"), `Ticker,Exchange,Name
+A,NYSE,Company A
+B,NASDAQ,Company B
+`), ShouldBeNil)
+		So(writeFile(filepath.Join(dir, "actions", "A.csv"), `Date,Action,Value,Contra Ticker
+2021-01-06,split,2,
+2021-01-04,listed,0,
+`), ShouldBeNil)
+		So(writeFile(filepath.Join(dir, "prices", "A.csv"), `Date,Close,Close split adj,Close fully adj,Cash Volume
+2021-01-05,11,11,11,1000
+2021-01-04,10,10,10,1000
+2021-01-06,12,12,12,1000
+`), ShouldBeNil)
+		So(writeFile(filepath.Join(dir, "prices", "B.csv"), `Date,Close
+2021-01-04,20
+`), ShouldBeNil)
+
+		c := NewCSVDir(dir)
+		d := func(day uint8) db.Date { return db.NewDate(2021, 1, day) }
+
+		Convey("Tickers", func() {
+			tickers, err := c.Tickers(ctx)
+			So(err, ShouldBeNil)
+			So(len(tickers), ShouldEqual, 2)
+			So(tickers["A"].Name, ShouldEqual, "Company A")
+			So(tickers["B"].Exchange, ShouldEqual, "NASDAQ")
+		})
+
+		Convey("Actions", func() {
+			actions, err := c.Actions(ctx, db.Date{})
+			So(err, ShouldBeNil)
+			So(actions, ShouldResemble, map[string][]db.ActionRow{
+				"A": {
+					db.TestAction(d(4), db.ListedAction, 0, ""),
+					db.TestAction(d(6), db.SplitAction, 2, ""),
+				},
+			})
+
+			actions, err = c.Actions(ctx, d(4))
+			So(err, ShouldBeNil)
+			So(actions, ShouldResemble, map[string][]db.ActionRow{
+				"A": {db.TestAction(d(6), db.SplitAction, 2, "")},
+			})
+		})
+
+		Convey("Prices", func() {
+			prices, err := c.Prices(ctx, db.Date{})
+			So(err, ShouldBeNil)
+			So(len(prices), ShouldEqual, 2)
+			So(len(prices["A"]), ShouldEqual, 3)
+			So(prices["A"][0].Date, ShouldResemble, d(4))
+			So(prices["B"][0].Close, ShouldEqual, 20)
+
+			prices, err = c.Prices(ctx, d(4), "A", "C")
+			So(err, ShouldBeNil)
+			So(len(prices), ShouldEqual, 1)
+			So(len(prices["A"]), ShouldEqual, 2)
+			So(prices["A"][0].Date, ShouldResemble, d(5))
+		})
+
+		Convey("invalid action", func() {
+			So(writeFile(filepath.Join(dir, "actions", "B.csv"), `Date,Action
+2021-01-04,bogus
+`), ShouldBeNil)
+			_, err := c.Actions(ctx, db.Date{})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unknown action type")
+		})
+
+		Convey("DownloadAll", func() {
+			So(DownloadAll(ctx, c, dir, "testdb"), ShouldBeNil)
+			r := db.NewReader(dir, "testdb")
+			meta, err := r.Metadata()
+			So(err, ShouldBeNil)
+			So(meta.NumTickers, ShouldEqual, 2)
+			So(meta.NumPrices, ShouldEqual, 4)
+			So(meta.NumActions, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,27 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package provider implements populating and incrementally updating a DB from
+// an arbitrary source of market data.
+//
+// A data vendor implements the Provider interface, which fetches the tickers,
+// the actions and the daily prices in the DB format. DownloadAll and UpdateAll
+// then do the rest: they compute the resampled prices, track the ticker and
+// symbol history, re-download the prices adjusted by new actions, and write it
+// all into a new DB snapshot. Optional capabilities, such as ticker renames and
+// fundamentals, are separate interfaces that a Provider may also implement.
+//
+// CSVDir is a Provider for a directory of CSV files; the Sharadar provider is in
+// the ndl/sharadar package.
+package provider
@@ -0,0 +1,514 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/stockparfait/errors"
+	"github.com/stockparfait/logging"
+	"github.com/stockparfait/stockparfait/db"
+)
+
+// Provider is a source of daily market data. All the data is returned in the
+// DB format, and the actions and the prices of each ticker are sorted by date.
+type Provider interface {
+	// Tickers fetches all the tickers available from the provider.
+	Tickers(ctx context.Context) (map[string]db.TickerRow, error)
+	// Actions fetches the actions of all the tickers strictly after the given
+	// date. Zero date means all dates.
+	Actions(ctx context.Context, since db.Date) (map[string][]db.ActionRow, error)
+	// Prices fetches the daily prices strictly after the given date (zero date
+	// means all dates) of the given tickers. When no tickers are supplied, the
+	// default is all tickers.
+	Prices(ctx context.Context, since db.Date, tickers ...string) (map[string][]db.PriceRow, error)
+}
+
+// Renamer is optionally implemented by a Provider which reports ticker changes.
+type Renamer interface {
+	// Renames returns the ticker changes among the actions fetched by the latest
+	// Actions call, sorted by date.
+	Renames() []db.Rename
+}
+
+// FundamentalsProvider is optionally implemented by a Provider which supplies
+// fundamentals.
+type FundamentalsProvider interface {
+	// Fundamentals fetches the complete fundamentals of all the tickers, sorted
+	// by dimension and DateKey for each ticker. Nil result means that the
+	// fundamentals are not requested: UpdateAll preserves the existing ones in
+	// the DB, if any, and DownloadAll removes them.
+	Fundamentals(ctx context.Context) (map[string][]db.FundamentalsRow, error)
+}
+
+// PriceStreamer is optionally implemented by a Provider which can supply all
+// the prices one ticker at a time, to avoid keeping them all in memory. It is
+// used by DownloadAll instead of Provider.Prices.
+type PriceStreamer interface {
+	// StreamPrices fetches all the daily prices of all the tickers, and calls f
+	// with the complete prices of each ticker sorted by date, one ticker at a
+	// time. An error returned by f aborts the streaming.
+	StreamPrices(ctx context.Context, f func(ticker string, prices []db.PriceRow) error) error
+}
+
+// AdjustingActions are the actions which change historical adjusted prices.
+var AdjustingActions = []db.ActionType{
+	db.SplitAction,
+	db.DividendAction,
+	db.SpinoffAction,
+}
+
+func isAdjusting(a db.ActionRow) bool {
+	for _, t := range AdjustingActions {
+		if a.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// knownTickers removes the rows of the tickers missing from the tickers table.
+func knownTickers[T any](ctx context.Context, kind string, rows map[string][]T, tickers map[string]db.TickerRow) map[string][]T {
+	for t := range rows {
+		if _, ok := tickers[t]; !ok {
+			logging.Warningf(ctx, "skipping %s %s, it's not in the tickers table", t, kind)
+			delete(rows, t)
+		}
+	}
+	return rows
+}
+
+func count[T any](rows map[string][]T) int {
+	n := 0
+	for _, r := range rows {
+		n += len(r)
+	}
+	return n
+}
+
+func renames(p Provider) []db.Rename {
+	if rn, ok := p.(Renamer); ok {
+		return rn.Renames()
+	}
+	return nil
+}
+
+// fetchTickersAndActions since the given date.
+func fetchTickersAndActions(ctx context.Context, p Provider, since db.Date) (map[string]db.TickerRow, map[string][]db.ActionRow, error) {
+	logging.Infof(ctx, "fetching tickers...")
+	tickers, err := p.Tickers(ctx)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "failed to fetch tickers")
+	}
+	logging.Infof(ctx, "downloaded %d tickers", len(tickers))
+	if since.IsZero() {
+		logging.Infof(ctx, "fetching actions...")
+	} else {
+		logging.Infof(ctx, "fetching actions after %s...", since)
+	}
+	actions, err := p.Actions(ctx, since)
+	if err != nil {
+		return nil, nil, errors.Annotate(err, "failed to fetch actions")
+	}
+	actions = knownTickers(ctx, "actions", actions, tickers)
+	return tickers, actions, nil
+}
+
+// updateSymbols records the renames in the symbol history of the DB, if there
+// are any.
+func updateSymbols(r *db.Reader, w *db.Writer, tickers map[string]db.TickerRow, renames []db.Rename) error {
+	symbols := make(map[string][]db.SymbolRow)
+	if r != nil && r.HasSymbols() {
+		var err error
+		if symbols, err = r.AllSymbolRows(); err != nil {
+			return errors.Annotate(err, "failed to read symbols")
+		}
+	}
+	if db.UpdateSymbols(symbols, tickers, renames) == 0 {
+		return nil
+	}
+	return w.WriteSymbols(symbols)
+}
+
+// updateTickerHistory records the attributes of the existing tickers which
+// changed in the new tickers as being in effect until the date.
+func updateTickerHistory(r *db.Reader, w *db.Writer, tickers map[string]db.TickerRow, date db.Date) error {
+	if !r.HasTickers() {
+		return nil
+	}
+	old, err := r.AllTickerRows()
+	if err != nil {
+		return errors.Annotate(err, "failed to read tickers")
+	}
+	history := make(map[string][]db.TickerHistoryRow)
+	if r.HasTickerHistory() {
+		if history, err = r.AllTickerHistoryRows(); err != nil {
+			return errors.Annotate(err, "failed to read ticker history")
+		}
+	}
+	if db.UpdateTickerHistory(history, old, tickers, date) == 0 {
+		return nil
+	}
+	return w.WriteTickerHistory(history)
+}
+
+func writeResampled(ctx context.Context, w *db.Writer, resampled map[db.Frequency]map[string][]db.ResampledRow) error {
+	for _, f := range db.Frequencies {
+		logging.Infof(ctx, "writing %s resampled prices...", f)
+		if err := w.WriteResampled(f, resampled[f]); err != nil {
+			return errors.Annotate(err, "failed to write %s prices", f)
+		}
+	}
+	return nil
+}
+
+// readResampled loads the existing resampled prices of all frequencies. If the
+// DB is missing a frequency, e.g. when it was created by an earlier version, it
+// is recomputed from the existing daily prices.
+func readResampled(ctx context.Context, r *db.Reader) (map[db.Frequency]map[string][]db.ResampledRow, error) {
+	res := make(map[db.Frequency]map[string][]db.ResampledRow)
+	for _, f := range db.Frequencies {
+		if r.HasResampled(f) {
+			rows, err := r.AllResampledRows(f)
+			if err != nil {
+				return nil, errors.Annotate(err, "failed to read %s prices", f)
+			}
+			res[f] = rows
+			continue
+		}
+		logging.Infof(ctx, "computing missing %s resampled prices...", f)
+		tickers, err := r.Tickers(ctx)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read tickers")
+		}
+		rows := make(map[string][]db.ResampledRow)
+		for _, t := range tickers {
+			if !r.HasPrices(t) {
+				continue
+			}
+			prices, err := r.Prices(t)
+			if err != nil {
+				return nil, errors.Annotate(err, "failed to read prices for %s", t)
+			}
+			rows[t] = db.ComputeResampled(prices, f.Period)
+		}
+		res[f] = rows
+	}
+	return res, nil
+}
+
+// writeFundamentals fetches the fundamentals, if the provider supplies them, and
+// writes them to the DB. The second value is false if nothing was written.
+func writeFundamentals(ctx context.Context, p Provider, w *db.Writer) (bool, error) {
+	fp, ok := p.(FundamentalsProvider)
+	if !ok {
+		return false, nil
+	}
+	logging.Infof(ctx, "fetching fundamentals...")
+	fundamentals, err := fp.Fundamentals(ctx)
+	if err != nil {
+		return false, errors.Annotate(err, "failed to fetch fundamentals")
+	}
+	if fundamentals == nil {
+		return false, nil
+	}
+	logging.Infof(ctx, "downloaded %d fundamentals rows", count(fundamentals))
+	// Historical rows may be restated or removed, so the old fundamentals are
+	// replaced in full.
+	if err := w.DeleteFundamentals(); err != nil {
+		return false, errors.Annotate(err, "failed to remove old fundamentals")
+	}
+	logging.Infof(ctx, "writing fundamentals...")
+	for ticker, rows := range fundamentals {
+		if err := w.WriteFundamentals(ticker, rows); err != nil {
+			return false, errors.Annotate(err, "failed to write fundamentals for %s", ticker)
+		}
+	}
+	return true, nil
+}
+
+// commit writes the metadata, cleans up and commits the DB.
+func commit(ctx context.Context, w *db.Writer) error {
+	logging.Infof(ctx, "writing metadata...")
+	if err := w.WriteMetadata(w.Metadata); err != nil {
+		return errors.Annotate(err, "failed to write metadata")
+	}
+	logging.Infof(ctx, "cleaning up...")
+	if err := w.Cleanup(ctx); err != nil {
+		return errors.Annotate(err, "failed to clean up DB")
+	}
+	logging.Infof(ctx, "committing...")
+	if err := w.Commit(); err != nil {
+		return errors.Annotate(err, "failed to commit DB")
+	}
+	logging.Infof(ctx, "all done.")
+	return nil
+}
+
+// DownloadAll fetches the tickers, the actions, all the prices and, if
+// supported by the provider, the fundamentals, and writes them into a new
+// snapshot of the DB, replacing all of its previous content, including the
+// fundamentals when they are not supplied, except for the ticker history: the
+// attributes of the existing tickers which changed are added to it as in
+// UpdateAll. Actions and prices of the tickers missing from the tickers table
+// are skipped. If the provider is a PriceStreamer, the prices are streamed into
+// the DB one ticker at a time, rather than fetched all at once.
+func DownloadAll(ctx context.Context, p Provider, dbPath, dbName string) error {
+	w := db.NewWriter(dbPath, dbName)
+	defer w.Rollback()
+	return downloadAll(ctx, p, w)
+}
+
+// downloadAll implements DownloadAll with the given Writer, which may already
+// hold the write lock.
+func downloadAll(ctx context.Context, p Provider, w *db.Writer) error {
+	r, err := w.Begin()
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB for writing")
+	}
+	tickers, actions, err := fetchTickersAndActions(ctx, p, db.Date{})
+	if err != nil {
+		return errors.Annotate(err, "failed to fetch tickers and actions")
+	}
+	logging.Infof(ctx, "downloaded %d actions", count(actions))
+
+	resampled := make(map[db.Frequency]map[string][]db.ResampledRow)
+	for _, f := range db.Frequencies {
+		resampled[f] = make(map[string][]db.ResampledRow)
+	}
+	writePrices := func(ticker string, ps []db.PriceRow) error {
+		if err := w.WritePrices(ticker, ps); err != nil {
+			return errors.Annotate(err, "failed to write prices for %s", ticker)
+		}
+		for _, f := range db.Frequencies {
+			resampled[f][ticker] = db.ComputeResampled(ps, f.Period)
+		}
+		return nil
+	}
+
+	if s, ok := p.(PriceStreamer); ok {
+		logging.Infof(ctx, "streaming prices...")
+		numPrices := 0
+		err := s.StreamPrices(ctx, func(ticker string, ps []db.PriceRow) error {
+			if _, ok := tickers[ticker]; !ok {
+				logging.Warningf(ctx, "skipping %s prices, it's not in the tickers table", ticker)
+				return nil
+			}
+			numPrices += len(ps)
+			return writePrices(ticker, ps)
+		})
+		if err != nil {
+			return errors.Annotate(err, "failed to stream prices")
+		}
+		logging.Infof(ctx, "wrote total %d prices", numPrices)
+	} else {
+		logging.Infof(ctx, "fetching prices...")
+		prices, err := p.Prices(ctx, db.Date{})
+		if err != nil {
+			return errors.Annotate(err, "failed to fetch prices")
+		}
+		prices = knownTickers(ctx, "prices", prices, tickers)
+		logging.Infof(ctx, "downloaded total %d prices", count(prices))
+		logging.Infof(ctx, "writing prices...")
+		for ticker, ps := range prices {
+			if err := writePrices(ticker, ps); err != nil {
+				return errors.Annotate(err, "failed to write prices")
+			}
+		}
+	}
+
+	if err := updateTickerHistory(r, w, tickers, db.DateInNY(time.Now())); err != nil {
+		return errors.Annotate(err, "failed to update ticker history")
+	}
+	logging.Infof(ctx, "writing tickers...")
+	if err := w.WriteTickers(tickers); err != nil {
+		return errors.Annotate(err, "failed to write tickers")
+	}
+	logging.Infof(ctx, "writing actions...")
+	if err := w.WriteActions(actions); err != nil {
+		return errors.Annotate(err, "failed to write actions")
+	}
+	if err := updateSymbols(nil, w, tickers, renames(p)); err != nil {
+		return errors.Annotate(err, "failed to write symbols")
+	}
+	if err := writeResampled(ctx, w, resampled); err != nil {
+		return errors.Annotate(err, "failed to write resampled prices")
+	}
+	ok, err := writeFundamentals(ctx, p, w)
+	if err != nil {
+		return errors.Annotate(err, "failed to write fundamentals")
+	}
+	// The snapshot starts with the previous fundamentals, which are removed when
+	// not supplied.
+	if !ok {
+		if err := w.DeleteFundamentals(); err != nil {
+			return errors.Annotate(err, "failed to remove old fundamentals")
+		}
+	}
+	return commit(ctx, w)
+}
+
+// UpdateAll incrementally updates an existing DB: it re-fetches the tickers and
+// fetches only the actions and the prices strictly after the latest price date
+// in the DB metadata, appends them to the existing ones and recomputes the
+// affected resampled bars. Tickers with a split, dividend or spinoff after that
+// date have their historical adjusted prices changed, and their full price
+// history is re-fetched. So is the history of a renamed ticker, which is stored
+// under its new name, and the rename is recorded in the symbol history. The
+// data of the tickers no longer in the tickers table is removed. Fundamentals,
+// if supplied, are always replaced in full, as historical rows may be restated.
+// If the DB has no metadata, it falls back to DownloadAll. The DB is locked for
+// writing for the whole update, so concurrent updates are serialized rather
+// than overwrite each other.
+func UpdateAll(ctx context.Context, p Provider, dbPath, dbName string) error {
+	w := db.NewWriter(dbPath, dbName)
+	r, err := w.Begin()
+	if err != nil {
+		return errors.Annotate(err, "failed to lock DB for update")
+	}
+	defer w.Rollback()
+	if !r.HasMetadata() {
+		logging.Infof(ctx, "no existing DB metadata, downloading everything")
+		return downloadAll(ctx, p, w)
+	}
+	meta, err := r.Metadata()
+	if err != nil {
+		return errors.Annotate(err, "failed to read DB metadata")
+	}
+	since := meta.End
+	tickers, newActions, err := fetchTickersAndActions(ctx, p, since)
+	if err != nil {
+		return errors.Annotate(err, "failed to fetch tickers and actions")
+	}
+	stale := make(map[string]struct{}) // tickers to re-fetch in full
+	for t, actions := range newActions {
+		for _, a := range actions {
+			if isAdjusting(a) {
+				stale[t] = struct{}{}
+			}
+		}
+	}
+	// The full history of a renamed ticker is available under its new name.
+	rns := renames(p)
+	for _, rn := range rns {
+		if _, ok := tickers[rn.New]; ok && !r.HasPrices(rn.New) {
+			stale[rn.New] = struct{}{}
+		}
+	}
+	logging.Infof(ctx, "downloaded %d actions, %d tickers with adjusted prices or renamed",
+		count(newActions), len(stale))
+
+	logging.Infof(ctx, "fetching prices after %s", since)
+	prices, err := p.Prices(ctx, since)
+	if err != nil {
+		return errors.Annotate(err, "failed to fetch prices")
+	}
+	prices = knownTickers(ctx, "prices", prices, tickers)
+	for t := range stale {
+		logging.Infof(ctx, "re-downloading all prices for %s", t)
+		full, err := p.Prices(ctx, db.Date{}, t)
+		if err != nil {
+			return errors.Annotate(err, "failed to fetch prices for %s", t)
+		}
+		prices[t] = full[t]
+	}
+	logging.Infof(ctx, "downloaded total %d prices", count(prices))
+
+	resampled, err := readResampled(ctx, r)
+	if err != nil {
+		return errors.Annotate(err, "failed to read resampled prices")
+	}
+	w.Metadata = meta
+	if err := updateTickerHistory(r, w, tickers, db.DateInNY(time.Now())); err != nil {
+		return errors.Annotate(err, "failed to update ticker history")
+	}
+	// The prices of the tickers no longer in the tickers table are removed by
+	// Cleanup, and must not be counted in the metadata.
+	if r.HasTickers() {
+		oldTickers, err := r.AllTickerRows()
+		if err != nil {
+			return errors.Annotate(err, "failed to read tickers")
+		}
+		for t := range oldTickers {
+			if _, ok := tickers[t]; ok || !r.HasPrices(t) {
+				continue
+			}
+			old, err := r.Prices(t)
+			if err != nil {
+				return errors.Annotate(err, "failed to read prices for %s", t)
+			}
+			w.Metadata.NumPrices -= len(old)
+		}
+	}
+	logging.Infof(ctx, "writing tickers...")
+	if err := w.WriteTickers(tickers); err != nil {
+		return errors.Annotate(err, "failed to write tickers")
+	}
+	actions := make(map[string][]db.ActionRow)
+	if r.HasActions() {
+		if actions, err = r.AllActionRows(); err != nil {
+			return errors.Annotate(err, "failed to read actions")
+		}
+	}
+	for t, as := range newActions {
+		actions[t] = append(actions[t], as...)
+	}
+	logging.Infof(ctx, "writing actions...")
+	if err := w.WriteActions(actions); err != nil {
+		return errors.Annotate(err, "failed to write actions")
+	}
+	if err := updateSymbols(r, w, tickers, rns); err != nil {
+		return errors.Annotate(err, "failed to update symbols")
+	}
+	logging.Infof(ctx, "writing prices for %d tickers...", len(prices))
+	for ticker, ps := range prices {
+		if len(ps) == 0 {
+			continue
+		}
+		var old []db.PriceRow
+		if r.HasPrices(ticker) {
+			if old, err = r.Prices(ticker); err != nil {
+				return errors.Annotate(err, "failed to read prices for %s", ticker)
+			}
+		}
+		from := ps[0].Date
+		if _, ok := stale[ticker]; !ok {
+			i := sort.Search(len(old), func(i int) bool {
+				return !old[i].Date.Before(from)
+			})
+			ps = append(old[:i:i], ps...)
+		}
+		w.Metadata.NumPrices -= len(old) // WritePrices adds the new total
+		if err := w.WritePrices(ticker, ps); err != nil {
+			return errors.Annotate(err, "failed to write prices for %s", ticker)
+		}
+		for _, f := range db.Frequencies {
+			resampled[f][ticker] = db.ComputeResampledTail(
+				resampled[f][ticker], ps, from, f.Period)
+		}
+	}
+	for _, f := range db.Frequencies {
+		resampled[f] = knownTickers(ctx, f.String()+" prices", resampled[f], tickers)
+	}
+	if err := writeResampled(ctx, w, resampled); err != nil {
+		return errors.Annotate(err, "failed to write resampled prices")
+	}
+	if _, err := writeFundamentals(ctx, p, w); err != nil {
+		return errors.Annotate(err, "failed to write fundamentals")
+	}
+	return commit(ctx, w)
+}
@@ -0,0 +1,299 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"context"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stockparfait/stockparfait/db"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testProvider serves the data from memory and records the Prices calls.
+type testProvider struct {
+	tickers      map[string]db.TickerRow
+	actions      map[string][]db.ActionRow
+	prices       map[string][]db.PriceRow
+	renames      []db.Rename
+	fundamentals map[string][]db.FundamentalsRow
+	fetched      [][]string // tickers of each Prices call
+}
+
+var _ Provider = &testProvider{}
+var _ Renamer = &testProvider{}
+var _ FundamentalsProvider = &testProvider{}
+
+func (p *testProvider) Tickers(ctx context.Context) (map[string]db.TickerRow, error) {
+	res := make(map[string]db.TickerRow)
+	for t, r := range p.tickers {
+		res[t] = r
+	}
+	return res, nil
+}
+
+func (p *testProvider) Actions(ctx context.Context, since db.Date) (map[string][]db.ActionRow, error) {
+	res := make(map[string][]db.ActionRow)
+	for t, actions := range p.actions {
+		for _, a := range actions {
+			if a.Date.After(since) {
+				res[t] = append(res[t], a)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (p *testProvider) Prices(ctx context.Context, since db.Date, tickers ...string) (map[string][]db.PriceRow, error) {
+	p.fetched = append(p.fetched, tickers)
+	if len(tickers) == 0 {
+		for t := range p.prices {
+			tickers = append(tickers, t)
+		}
+	}
+	res := make(map[string][]db.PriceRow)
+	for _, t := range tickers {
+		for _, pr := range p.prices[t] {
+			if pr.Date.After(since) {
+				res[t] = append(res[t], pr)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (p *testProvider) Renames() []db.Rename {
+	return p.renames
+}
+
+func (p *testProvider) Fundamentals(ctx context.Context) (map[string][]db.FundamentalsRow, error) {
+	return p.fundamentals, nil
+}
+
+// testStreamer is a testProvider which also streams prices.
+type testStreamer struct {
+	*testProvider
+}
+
+var _ PriceStreamer = &testStreamer{}
+
+func (p *testStreamer) StreamPrices(ctx context.Context, f func(ticker string, prices []db.PriceRow) error) error {
+	var tickers []string
+	for t := range p.prices {
+		tickers = append(tickers, t)
+	}
+	sort.Strings(tickers)
+	for _, t := range tickers {
+		if err := f(t, p.prices[t]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	tmpdir, tmpdirErr := os.MkdirTemp("", "testprovider")
+	defer os.RemoveAll(tmpdir)
+
+	Convey("Test setup succeeded", t, func() {
+		So(tmpdirErr, ShouldBeNil)
+	})
+
+	Convey("DownloadAll and UpdateAll work", t, func() {
+		ctx := context.Background()
+		dbName := "testdb"
+		dbDir, err := os.MkdirTemp(tmpdir, "db") // fresh for each Convey leaf
+		So(err, ShouldBeNil)
+		d := func(month, day uint8) db.Date { return db.NewDate(2021, month, day) }
+		price := func(date db.Date, c float32) db.PriceRow {
+			return db.TestPrice(date, c, c, c, 1000.0, true)
+		}
+
+		p := &testProvider{
+			tickers: map[string]db.TickerRow{
+				"A": {ID: "1"},
+				"B": {ID: "2"},
+			},
+			actions: map[string][]db.ActionRow{
+				"A": {db.TestAction(d(1, 4), db.ListedAction, 0, "")},
+				"X": {db.TestAction(d(1, 4), db.ListedAction, 0, "")},
+			},
+			prices: map[string][]db.PriceRow{
+				"A": {price(d(1, 4), 10), price(d(1, 5), 11)},
+				"B": {price(d(1, 4), 20), price(d(1, 5), 21)},
+				"X": {price(d(1, 4), 30)}, // not in tickers
+			},
+			fundamentals: map[string][]db.FundamentalsRow{
+				"A": {{Dimension: db.MRQ, DateKey: d(1, 4), Revenue: 100}},
+			},
+		}
+		So(DownloadAll(ctx, p, dbDir, dbName), ShouldBeNil)
+		So(p.fetched, ShouldResemble, [][]string{nil})
+
+		r := db.NewReader(dbDir, dbName)
+		meta, err := r.Metadata()
+		So(err, ShouldBeNil)
+		So(meta, ShouldResemble, db.Metadata{
+			Start:           d(1, 4),
+			End:             d(1, 5),
+			NumTickers:      2,
+			NumPrices:       4,
+			NumWeekly:       2,
+			NumMonthly:      2,
+			NumQuarterly:    2,
+			NumYearly:       2,
+			NumActions:      1,
+			NumFundamentals: 1,
+		})
+		So(r.HasPrices("X"), ShouldBeFalse)
+
+		Convey("and DownloadAll removes the fundamentals not supplied", func() {
+			p.fundamentals = nil
+			So(DownloadAll(ctx, p, dbDir, dbName), ShouldBeNil)
+
+			r := db.NewReader(dbDir, dbName)
+			meta, err := r.Metadata()
+			So(err, ShouldBeNil)
+			So(meta.NumFundamentals, ShouldEqual, 0)
+			So(r.HasFundamentals("A"), ShouldBeFalse)
+		})
+
+		Convey("and DownloadAll updates the ticker history", func() {
+			p.tickers["A"] = db.TickerRow{ID: "1", Exchange: "NYSE"}
+			So(DownloadAll(ctx, p, dbDir, dbName), ShouldBeNil)
+
+			r := db.NewReader(dbDir, dbName)
+			history, err := r.AllTickerHistoryRows()
+			So(err, ShouldBeNil)
+			So(len(history["A"]), ShouldEqual, 1)
+			So(history["A"][0].Row, ShouldResemble, db.TickerRow{ID: "1"})
+			So(history["B"], ShouldBeNil)
+
+			row, err := r.TickerRowAsOf("A", d(1, 5))
+			So(err, ShouldBeNil)
+			So(row.Exchange, ShouldEqual, "")
+		})
+
+		Convey("and DownloadAll streams prices", func() {
+			p.fetched = nil
+			So(DownloadAll(ctx, &testStreamer{p}, dbDir, dbName), ShouldBeNil)
+			So(p.fetched, ShouldBeNil)
+
+			r := db.NewReader(dbDir, dbName)
+			streamedMeta, err := r.Metadata()
+			So(err, ShouldBeNil)
+			So(streamedMeta, ShouldResemble, meta)
+			So(r.HasPrices("X"), ShouldBeFalse)
+
+			pricesA, err := r.Prices("A")
+			So(err, ShouldBeNil)
+			So(pricesA, ShouldResemble, p.prices["A"])
+		})
+
+		Convey("and UpdateAll drops delisted tickers and replaces fundamentals", func() {
+			delete(p.tickers, "B")
+			p.tickers["A"] = db.TickerRow{ID: "1", Active: true}
+			p.tickers["C"] = db.TickerRow{ID: "3", Active: true}
+			p.prices["C"] = []db.PriceRow{price(d(1, 6), 40)}
+			p.fundamentals = map[string][]db.FundamentalsRow{
+				"C": {{Dimension: db.MRQ, DateKey: d(1, 6), Revenue: 200}},
+			}
+			So(UpdateAll(ctx, p, dbDir, dbName), ShouldBeNil)
+
+			r := db.NewReader(dbDir, dbName)
+			meta, err := r.Metadata()
+			So(err, ShouldBeNil)
+			So(meta, ShouldResemble, db.Metadata{
+				Start:           d(1, 4),
+				End:             d(1, 6),
+				NumTickers:      2,
+				NumPrices:       3,
+				NumWeekly:       2,
+				NumMonthly:      2,
+				NumQuarterly:    2,
+				NumYearly:       2,
+				NumActions:      1,
+				NumFundamentals: 1,
+			})
+			So(r.HasPrices("B"), ShouldBeFalse)
+			So(r.HasFundamentals("A"), ShouldBeFalse)
+			So(r.HasFundamentals("C"), ShouldBeTrue)
+			monthly, err := r.AllResampledRows(db.Monthly)
+			So(err, ShouldBeNil)
+			So(len(monthly), ShouldEqual, 2)
+			So(monthly["B"], ShouldBeNil)
+
+			report, err := db.Check(ctx, dbDir, dbName, false)
+			So(err, ShouldBeNil)
+			So(report.Issues, ShouldBeNil)
+		})
+
+		Convey("and UpdateAll appends and re-fetches adjusted prices", func() {
+			// B splits, and its entire history is adjusted.
+			p.actions["B"] = []db.ActionRow{db.TestAction(d(1, 6), db.SplitAction, 2, "")}
+			p.prices["A"] = append(p.prices["A"], price(d(1, 6), 12))
+			p.prices["B"] = []db.PriceRow{
+				price(d(1, 4), 10), price(d(1, 5), 10.5), price(d(1, 6), 11)}
+			// C is the new name of OLDC, whose entire history is under C.
+			p.tickers["C"] = db.TickerRow{ID: "3"}
+			p.renames = []db.Rename{{Date: d(1, 6), Old: "OLDC", New: "C"}}
+			p.prices["C"] = []db.PriceRow{price(d(1, 5), 40), price(d(1, 6), 41)}
+			p.fundamentals = nil // preserve the existing fundamentals
+			p.fetched = nil
+
+			So(UpdateAll(ctx, p, dbDir, dbName), ShouldBeNil)
+			So(len(p.fetched), ShouldEqual, 3)
+			So(p.fetched[0], ShouldBeNil)
+			So(p.fetched[1:], ShouldContain, []string{"B"})
+			So(p.fetched[1:], ShouldContain, []string{"C"})
+
+			r := db.NewReader(dbDir, dbName)
+			meta, err := r.Metadata()
+			So(err, ShouldBeNil)
+			So(meta.End, ShouldResemble, d(1, 6))
+			So(meta.NumTickers, ShouldEqual, 3)
+			So(meta.NumPrices, ShouldEqual, 8)
+			So(meta.NumActions, ShouldEqual, 2)
+			So(meta.NumFundamentals, ShouldEqual, 1)
+
+			pricesA, err := r.Prices("A")
+			So(err, ShouldBeNil)
+			So(pricesA, ShouldResemble, p.prices["A"])
+
+			pricesB, err := r.Prices("B")
+			So(err, ShouldBeNil)
+			So(pricesB, ShouldResemble, p.prices["B"])
+
+			pricesC, err := r.Prices("C")
+			So(err, ShouldBeNil)
+			So(pricesC, ShouldResemble, p.prices["C"])
+
+			ticker, err := r.Resolve("OLDC", d(1, 5))
+			So(err, ShouldBeNil)
+			So(ticker, ShouldEqual, "C")
+
+			monthlyB, err := r.Monthly("B", db.Date{}, db.Date{})
+			So(err, ShouldBeNil)
+			So(len(monthlyB), ShouldEqual, 1)
+			So(monthlyB[0].NumSamples, ShouldEqual, 3)
+			So(monthlyB[0].OpenSplitAdjusted, ShouldEqual, 10)
+		})
+	})
+}
@@ -24,7 +24,7 @@ import (
 
 type Column struct {
 	Kind string  `json:"kind" required:"true" choices:"ticker,name,exchange,category,sector,industry,price,volume"`
-	Date db.Date `json:"date"` // required for "price" and "volume"
+	Date db.Date `json:"date"` // required for "price" (see Reader.PriceAt) and "volume" (exact date only)
 	Sort string  `json:"sort" choices:",ascending,descending"`
 }
 
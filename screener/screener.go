@@ -74,23 +74,27 @@ func (r Row) CSV() []string {
 	return res
 }
 
-func cachePrices(reader *db.Reader, ticker string, prices []db.PriceRow) ([]db.PriceRow, error) {
-	if len(prices) > 0 {
-		return prices, nil
-	}
-	prices, err := reader.Prices(ticker)
-	if err != nil {
-		return nil, errors.Annotate(err, "failed to read prices for %s", ticker)
-	}
-	return prices, nil
-}
-
 func processTicker(reader *db.Reader, cols []Column, ticker string) (Row, error) {
 	tr, err := reader.TickerRow(ticker)
 	if err != nil {
 		return nil, errors.Annotate(err, "failed to read ticker row for %s", ticker)
 	}
-	var prices []db.PriceRow
+	prices := make(map[db.Date]*db.PriceRow) // nil: no price at or before date
+	priceAt := func(date db.Date) (*db.PriceRow, error) {
+		if p, ok := prices[date]; ok {
+			return p, nil
+		}
+		p, ok, err := reader.PriceAt(ticker, date)
+		if err != nil {
+			return nil, errors.Annotate(err, "failed to read prices for %s", ticker)
+		}
+		if !ok {
+			prices[date] = nil
+			return nil, nil
+		}
+		prices[date] = &p
+		return &p, nil
+	}
 	cells := make([]Cell, len(cols))
 	for i, col := range cols {
 		switch col.Kind {
@@ -107,24 +111,21 @@ func processTicker(reader *db.Reader, cols []Column, ticker string) (Row, error)
 		case "industry":
 			cells[i] = String(tr.Industry)
 		case "price":
-			prices, err = cachePrices(reader, ticker, prices)
+			p, err := priceAt(col.Date)
 			if err != nil {
-				return nil, errors.Annotate(err, "failed to read prices for %s", ticker)
+				return nil, errors.Annotate(err, "failed to read price for %s", ticker)
 			}
-			for _, p := range prices {
-				if p.Date == col.Date {
-					cells[i] = Number(float64(p.CloseFullyAdjusted))
-				}
+			if p != nil {
+				cells[i] = Number(float64(p.CloseFullyAdjusted))
 			}
 		case "volume":
-			prices, err = cachePrices(reader, ticker, prices)
+			p, err := priceAt(col.Date)
 			if err != nil {
-				return nil, errors.Annotate(err, "failed to read prices for %s", ticker)
+				return nil, errors.Annotate(err, "failed to read volume for %s", ticker)
 			}
-			for _, p := range prices {
-				if p.Date == col.Date {
-					cells[i] = Number(float64(p.CashVolume))
-				}
+			// Volume is a per-day quantity, and is not carried forward.
+			if p != nil && p.Date.Date() == col.Date.Date() {
+				cells[i] = Number(float64(p.CashVolume))
 			}
 		}
 	}
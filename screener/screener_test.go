@@ -80,6 +80,7 @@ func TestScreener(t *testing.T) {
 		So(w.WriteTickers(tickers), ShouldBeNil)
 		So(w.WritePrices("A", pricesA), ShouldBeNil)
 		So(w.WritePrices("B", pricesB), ShouldBeNil)
+		So(w.Commit(), ShouldBeNil)
 
 		ctx := context.Background()
 
@@ -94,7 +95,9 @@ func TestScreener(t *testing.T) {
     {"kind": "sector"},
     {"kind": "industry"},
     {"kind": "price", "date": "2019-01-02"},
-    {"kind": "volume", "date": "2019-01-02"}
+    {"kind": "volume", "date": "2019-01-02"},
+    {"kind": "price", "date": "2019-01-05"},
+    {"kind": "volume", "date": "2019-01-05"}
   ]
 }`, tmpdir, dbName)
 		var config Config
@@ -104,9 +107,9 @@ func TestScreener(t *testing.T) {
 		var buf bytes.Buffer
 		So(tbl.WriteCSV(&buf, table.Params{}), ShouldBeNil)
 		So("\n"+buf.String(), ShouldEqual, `
-Ticker,Name,Exchange,Category,Sector,Industry,Split+Div Adjusted Close 2019-01-02,Cash Volume 2019-01-02
-B,Name B,ex B,cat B,sec B,ind B,110.00,1100.00
-A,Name A,ex A,cat A,sec A,ind A,11.00,1100.00
+Ticker,Name,Exchange,Category,Sector,Industry,Split+Div Adjusted Close 2019-01-02,Cash Volume 2019-01-02,Split+Div Adjusted Close 2019-01-05,Cash Volume 2019-01-05
+B,Name B,ex B,cat B,sec B,ind B,110.00,1100.00,120.00,
+A,Name A,ex A,cat A,sec A,ind A,11.00,1100.00,12.00,
 `)
 	})
 }
@@ -0,0 +1,92 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"github.com/stockparfait/stockparfait/db"
+)
+
+// The fully adjusted prices are the split-adjusted prices multiplied by the
+// dividend adjustment factor CloseFullyAdjusted / CloseSplitAdjusted. A
+// dividend D with the ex-date t multiplies the factor of all the prices before
+// t by (1 - D / C), where C is the split-adjusted close before t. Therefore, the
+// dividends can be recovered from the stored prices without the actions. Note,
+// that the cash value of spinoffs is adjusted for the same way, and is
+// indistinguishable from a dividend.
+
+// dividendThreshold is the minimum relative change of the dividend adjustment
+// factor recognized as a dividend, which filters out float32 rounding errors.
+const dividendThreshold = 1e-5
+
+// dividend returns the split-adjusted dividend with the ex-date of prices[i]
+// implied by the change of the dividend adjustment factor since the previous
+// price, or 0 if there is none.
+func dividend(prices []db.PriceRow, i int) float64 {
+	if i < 1 || i >= len(prices) {
+		return 0
+	}
+	prev, curr := prices[i-1], prices[i]
+	if prev.CloseSplitAdjusted <= 0 || curr.CloseSplitAdjusted <= 0 ||
+		curr.CloseFullyAdjusted <= 0 {
+		return 0
+	}
+	f0 := float64(prev.CloseFullyAdjusted) / float64(prev.CloseSplitAdjusted)
+	f1 := float64(curr.CloseFullyAdjusted) / float64(curr.CloseSplitAdjusted)
+	x := 1 - f0/f1
+	if x < dividendThreshold {
+		return 0
+	}
+	return x * float64(prev.CloseSplitAdjusted)
+}
+
+// NewDividendsFromPrices returns the dividend events derived from the prices
+// sorted by date: the split-adjusted cash dividends dated by their ex-dates.
+func NewDividendsFromPrices(prices []db.PriceRow) *Timeseries {
+	var dates []db.Date
+	var data []float64
+	for i := range prices {
+		if d := dividend(prices, i); d > 0 {
+			dates = append(dates, prices[i].Date)
+			data = append(data, d)
+		}
+	}
+	return NewTimeseries(dates, data)
+}
+
+// TrailingDividendYield computes for each price the sum of the dividends with
+// the ex-dates within the preceding year, that is strictly after the same date
+// a year ago, divided by the split-adjusted close. The dividends are derived
+// from the prices, see NewDividendsFromPrices, so the yield is understated for
+// the first year of the prices.
+func TrailingDividendYield(prices []db.PriceRow) *Timeseries {
+	dates := make([]db.Date, len(prices))
+	data := make([]float64, len(prices))
+	divs := make([]float64, len(prices))
+	sum := 0.0
+	j := 0 // the first dividend in the trailing window
+	for i, p := range prices {
+		divs[i] = dividend(prices, i)
+		sum += divs[i]
+		yearAgo := db.NewDateFromTime(p.Date.Date().ToTime().AddDate(-1, 0, 0))
+		for ; j < i && !prices[j].Date.Date().After(yearAgo); j++ {
+			sum -= divs[j]
+		}
+		dates[i] = p.Date
+		if p.CloseSplitAdjusted > 0 {
+			data[i] = sum / float64(p.CloseSplitAdjusted)
+		}
+	}
+	return NewTimeseries(dates, data)
+}
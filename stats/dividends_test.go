@@ -0,0 +1,78 @@
+// Copyright 2022 Stock Parfait
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"testing"
+
+	"github.com/stockparfait/stockparfait/db"
+	"github.com/stockparfait/testutil"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDividends(t *testing.T) {
+	t.Parallel()
+
+	Convey("Dividends are derived from prices", t, func() {
+		round := func(xs []float64) []float64 {
+			res := make([]float64, len(xs))
+			for i, x := range xs {
+				res[i] = testutil.Round(x, 5)
+			}
+			return res
+		}
+		dt1 := db.NewDate(2020, 1, 2)
+		dt2 := db.NewDate(2020, 3, 2) // dividend 0.5
+		dt3 := db.NewDate(2021, 3, 1) // dividend 1.0
+		dt4 := db.NewDate(2021, 3, 3)
+		// The unadjusted close is 20 before a 2:1 split on dt4, the split-adjusted
+		// close is always 10, and the dividend factor is 0.855 before dt2, 0.9 before dt3, and 1 after.
+		prices := []db.PriceRow{
+			db.TestPriceRow(dt1, 20, 20, 20, 20, 10, 8.55, 1000.0, true),
+			db.TestPriceRow(dt2, 20, 20, 20, 20, 10, 9, 1000.0, true),
+			db.TestPriceRow(dt3, 20, 20, 20, 20, 10, 10, 1000.0, true),
+			db.TestPriceRow(dt4, 10, 10, 10, 10, 10, 10, 1000.0, true),
+		}
+
+		Convey("NewDividendsFromPrices", func() {
+			ts := NewDividendsFromPrices(prices)
+			So(ts.Dates(), ShouldResemble, []db.Date{dt2, dt3})
+			So(round(ts.Data()), ShouldResemble, []float64{0.5, 1.0})
+		})
+
+		Convey("PriceDividend", func() {
+			ts := NewTimeseriesFromPrices(prices, PriceDividend)
+			So(ts.Dates(), ShouldResemble, []db.Date{dt1, dt2, dt3, dt4})
+			So(round(ts.Data()), ShouldResemble, []float64{0, 0.5, 1.0, 0})
+		})
+
+		Convey("TrailingDividendYield", func() {
+			ts := NewTimeseriesFromPrices(prices, PriceTrailingDividendYield)
+			So(ts.Dates(), ShouldResemble, []db.Date{dt1, dt2, dt3, dt4})
+			// dt3 is within a year of dt2, dt4 is not.
+			So(round(ts.Data()), ShouldResemble, []float64{0, 0.05, 0.15, 0.1})
+		})
+
+		Convey("no dividends", func() {
+			flat := []db.PriceRow{
+				db.TestPriceRow(dt1, 20, 20, 20, 20, 10, 5, 1000.0, true),
+				db.TestPriceRow(dt2, 20, 20, 20, 20, 10, 5, 1000.0, true),
+			}
+			So(len(NewDividendsFromPrices(flat).Dates()), ShouldEqual, 0)
+			So(TrailingDividendYield(flat).Data(), ShouldResemble, []float64{0, 0})
+		})
+	})
+}
@@ -134,8 +134,8 @@ func (t *Timeseries) Shift(shift int) *Timeseries {
 }
 
 // LogProfits computes a new Timeseries of log-profits {log(x[t+n]) -
-// log(x[t])}. The associated log-profit date is t+n. When intarday is true,
-// skip log-profits spanning more than one day.
+// log(x[t])}. The associated log-profit date is t+n. When intraday is true,
+// skip log-profits spanning more than one day, i.e. across trading sessions.
 func (t *Timeseries) LogProfits(n int, intraday bool) *Timeseries {
 	if n < 1 {
 		panic(errors.Reason("n=%d must be >= 1", n))
@@ -175,10 +175,17 @@ const (
 	PriceCloseSplitAdjusted
 	PriceCloseFullyAdjusted
 	PriceCashVolume
+	PriceDividend              // split-adjusted dividend on its ex-date, or 0
+	PriceTrailingDividendYield // see TrailingDividendYield
 )
 
-// NewTimeseriesFromPrices initializes Timeseries from PriceRow slice.
+// NewTimeseriesFromPrices initializes Timeseries from PriceRow slice. The
+// dividend fields are derived from the adjusted closes, see
+// NewDividendsFromPrices.
 func NewTimeseriesFromPrices(prices []db.PriceRow, f PriceField) *Timeseries {
+	if f == PriceTrailingDividendYield {
+		return TrailingDividendYield(prices)
+	}
 	dates := make([]db.Date, len(prices))
 	data := make([]float64, len(prices))
 	for i, p := range prices {
@@ -210,6 +217,8 @@ func NewTimeseriesFromPrices(prices []db.PriceRow, f PriceField) *Timeseries {
 			data[i] = float64(p.CloseFullyAdjusted)
 		case PriceCashVolume:
 			data[i] = float64(p.CashVolume)
+		case PriceDividend:
+			data[i] = dividend(prices, i)
 		default:
 			panic(errors.Reason("unsupported PriceField: %d", f))
 		}
@@ -217,6 +226,115 @@ func NewTimeseriesFromPrices(prices []db.PriceRow, f PriceField) *Timeseries {
 	return NewTimeseries(dates, data)
 }
 
+// FundamentalsField is an enum type indicating which FundamentalsRow field to
+// use.
+type FundamentalsField uint8
+
+const (
+	FundamentalsRevenue FundamentalsField = iota
+	FundamentalsCostOfRevenue
+	FundamentalsGrossProfit
+	FundamentalsOperatingIncome
+	FundamentalsNetIncome
+	FundamentalsEBITDA
+	FundamentalsEPS
+	FundamentalsEPSDiluted
+	FundamentalsDividendsPerShare
+	FundamentalsOperatingCashFlow
+	FundamentalsFreeCashFlow
+	FundamentalsAssets
+	FundamentalsLiabilities
+	FundamentalsEquity
+	FundamentalsDebt
+	FundamentalsCash
+	FundamentalsShares
+	FundamentalsMarketCap
+	FundamentalsEV
+	FundamentalsPE
+	FundamentalsPS
+	FundamentalsPB
+	FundamentalsGrossMargin
+	FundamentalsNetMargin
+	FundamentalsROE
+)
+
+func fundamentalsValue(r db.FundamentalsRow, f FundamentalsField) float32 {
+	switch f {
+	case FundamentalsRevenue:
+		return r.Revenue
+	case FundamentalsCostOfRevenue:
+		return r.CostOfRevenue
+	case FundamentalsGrossProfit:
+		return r.GrossProfit
+	case FundamentalsOperatingIncome:
+		return r.OperatingIncome
+	case FundamentalsNetIncome:
+		return r.NetIncome
+	case FundamentalsEBITDA:
+		return r.EBITDA
+	case FundamentalsEPS:
+		return r.EPS
+	case FundamentalsEPSDiluted:
+		return r.EPSDiluted
+	case FundamentalsDividendsPerShare:
+		return r.DividendsPerShare
+	case FundamentalsOperatingCashFlow:
+		return r.OperatingCashFlow
+	case FundamentalsFreeCashFlow:
+		return r.FreeCashFlow
+	case FundamentalsAssets:
+		return r.Assets
+	case FundamentalsLiabilities:
+		return r.Liabilities
+	case FundamentalsEquity:
+		return r.Equity
+	case FundamentalsDebt:
+		return r.Debt
+	case FundamentalsCash:
+		return r.Cash
+	case FundamentalsShares:
+		return r.Shares
+	case FundamentalsMarketCap:
+		return r.MarketCap
+	case FundamentalsEV:
+		return r.EV
+	case FundamentalsPE:
+		return r.PE
+	case FundamentalsPS:
+		return r.PS
+	case FundamentalsPB:
+		return r.PB
+	case FundamentalsGrossMargin:
+		return r.GrossMargin
+	case FundamentalsNetMargin:
+		return r.NetMargin
+	case FundamentalsROE:
+		return r.ROE
+	}
+	panic(errors.Reason("unsupported FundamentalsField: %d", f))
+}
+
+// NewTimeseriesFromFundamentals initializes Timeseries from FundamentalsRow
+// slice of a single dimension sorted by DateKey, e.g. as returned by
+// db.Reader.Fundamentals(). The values are dated by their DateKey, so the
+// series steps on the dates when the data became known, and each value is
+// valid until the next date. When several rows have the same DateKey, the last
+// one wins.
+func NewTimeseriesFromFundamentals(rows []db.FundamentalsRow, f FundamentalsField) *Timeseries {
+	dates := []db.Date{}
+	data := []float64{}
+	for _, r := range rows {
+		v := float64(fundamentalsValue(r, f))
+		if l := len(dates); l > 0 && dates[l-1] == r.DateKey {
+			data[l-1] = v
+			continue
+		}
+		dates = append(dates, r.DateKey)
+		data = append(data, v)
+	}
+	return NewTimeseries(dates, data)
+}
+
 // TimeseriesIntersectIndices returns the slice of indices S effectively
 // intersecting the given Timeseries by Date. That is:
 //
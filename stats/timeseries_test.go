@@ -221,6 +221,24 @@ func TestTimeseries(t *testing.T) {
 			})
 		})
 
+		Convey("FromFundamentals", func() {
+			d1 := db.NewDate(2020, 2, 10)
+			d2 := db.NewDate(2020, 5, 10)
+			p1 := db.NewDate(2019, 12, 31)
+			p2 := db.NewDate(2020, 3, 31)
+			rows := []db.FundamentalsRow{
+				db.TestFundamentals(db.ARQ, d1, p1, 100.0, 10.0),
+				db.TestFundamentals(db.ARQ, d2, p2, 110.0, 11.0),
+				db.TestFundamentals(db.ARQ, d2, p2, 120.0, 12.0), // amended
+			}
+			ts := NewTimeseriesFromFundamentals(rows, FundamentalsRevenue)
+			So(ts.Dates(), ShouldResemble, []db.Date{d1, d2})
+			So(ts.Data(), ShouldResemble, []float64{100.0, 120.0})
+
+			ts = NewTimeseriesFromFundamentals(rows, FundamentalsNetIncome)
+			So(ts.Data(), ShouldResemble, []float64{10.0, 12.0})
+		})
+
 		Convey("TimeseriesIntersect", func() {
 			Convey("Second sequence ends before first", func() {
 				t1 := NewTimeseries([]db.Date{